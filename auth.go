@@ -2,132 +2,71 @@ package main
 
 import (
 	"context"
-	"crypto/rsa"
-	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
-	"time"
+	"strings"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/dgrijalva/jwt-go/request"
 )
 
 type key int
 
-const (
-	audience = "codernote-project"
-	issuer   = "https://securetoken.google.com/codernote-project"
-)
-
 const (
 	uidKey key = iota
+	// パーソナルアクセストークンで認証されたときだけ入る
+	tokenScopeKey
 )
 
-func authMiddleware(next http.Handler) http.Handler {
+func (s *server) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		publicKeyMap, err := fetchPublicKeyMap()
+		raw, err := request.OAuth2Extractor.ExtractToken(r)
 		if err != nil {
-			log.Println(err)
-			http.Error(w, "failed to fetch public key", http.StatusInternalServerError)
+			http.Error(w, "invalid token", http.StatusUnauthorized)
 			return
 		}
 
-		token, err := request.ParseFromRequest(r, request.OAuth2Extractor, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-				return nil, errors.New("invalid signing method")
-			}
-			if token.Method != jwt.SigningMethodRS256 {
-				return nil, errors.New("invalid signing method")
-			}
-
-			kid, ok := token.Header["kid"].(string)
-			if !ok {
-				return nil, errors.New("token header should have kid field")
+		if strings.HasPrefix(raw, accessTokenPrefix) {
+			token, err := s.verifyAccessToken(raw)
+			if err != nil {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
 			}
-			pubKey, ok := publicKeyMap[kid]
-			if !ok {
-				return nil, errors.New("invalid public key id")
+			if !isAllowedScope(token.Scope, r.Method) {
+				http.Error(w, "insufficient token scope", http.StatusForbidden)
+				return
 			}
-
-			return pubKey, nil
-		})
-		if err != nil {
-			log.Println(err)
-			http.Error(w, "invalid token", http.StatusUnauthorized)
-			return
-		}
-
-		if !isValidToken(token) {
-			http.Error(w, "invalid token", http.StatusUnauthorized)
+			ctx := context.WithValue(r.Context(), uidKey, token.UserID)
+			ctx = context.WithValue(ctx, tokenScopeKey, token.Scope)
+			next.ServeHTTP(w, r.WithContext(ctx))
 			return
 		}
 
-		claims := token.Claims.(jwt.MapClaims)
-		uid := claims["sub"].(string)
-		if uid == "" {
+		uid, err := s.verifier.Verify(raw)
+		if err != nil {
+			log.Println(err)
+			var kerr *keyFetchError
+			if errors.As(err, &kerr) {
+				http.Error(w, "failed to fetch public key", http.StatusInternalServerError)
+				return
+			}
 			http.Error(w, "invalid token", http.StatusUnauthorized)
 			return
 		}
 		ctx := context.WithValue(r.Context(), uidKey, uid)
 		r = r.WithContext(ctx)
 
-		// log.Printf("Got a valid token. Header: %v Claims: %v", token.Header, token.Claims)
 		next.ServeHTTP(w, r)
 	})
 }
 
-type publicKeyMap map[string]*rsa.PublicKey
-
-func fetchPublicKeyMap() (km publicKeyMap, err error) {
-	const srcURL = "https://www.googleapis.com/robot/v1/metadata/x509/securetoken@system.gserviceaccount.com"
-
-	resp, err := http.Get(srcURL)
-	if err != nil {
-		return nil, errors.New("error fetching public key: " + err.Error())
-	}
-	defer resp.Body.Close()
-
-	d := make(map[string]string)
-	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
-		return nil, errors.New("error decoding public key http response body: " + err.Error())
-	}
-
-	keyMap := make(map[string]*rsa.PublicKey)
-	for k, v := range d {
-		key, err := jwt.ParseRSAPublicKeyFromPEM([]byte(v))
-		if err != nil {
-			return nil, errors.New("error parsing public key: " + err.Error())
+// Authorizationヘッダがあるときだけ検証し、uidKeyをcontextに入れる
+func (s *server) optionalAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
 		}
-		keyMap[k] = key
-	}
-
-	return keyMap, nil
-}
-
-func isValidToken(token *jwt.Token) bool {
-	now := time.Now().Unix()
-	if !token.Valid {
-		return false
-	}
-	claims := token.Claims.(jwt.MapClaims)
-	if claims.VerifyExpiresAt(now, true) == false {
-		return false
-	}
-	if claims.VerifyIssuedAt(now, true) == false {
-		return false
-	}
-	if claims.VerifyAudience(audience, true) == false {
-		return false
-	}
-	if claims.VerifyIssuer(issuer, true) == false {
-		return false
-	}
-	if sub, ok := claims["sub"].(string); !ok || sub == "" {
-		return false
-	}
-	if authTime, ok := claims["auth_time"].(float64); !ok || int64(authTime) > now {
-		return false
-	}
-	return true
+		s.authMiddleware(next).ServeHTTP(w, r)
+	})
 }
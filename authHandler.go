@@ -1,7 +1,9 @@
 package main
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"log"
 	"math/rand"
 	"net/http"
@@ -12,30 +14,32 @@ import (
 	"time"
 
 	validation "github.com/go-ozzo/ozzo-validation"
-	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 
 	. "github.com/tsushiy/codernote-backend/db"
+	"github.com/tsushiy/codernote-backend/store"
 )
 
 const (
 	defaultNameLen = 24
 	letters        = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	maxMergeTags   = 50
+
+	maxBulkOperations = 100
+	// 1つの操作で付け外しできるタグの数
+	maxBulkTags = 20
+
+	defaultFeedLimit = 20
+	maxFeedLimit     = 100
+
+	maxCommentLen = 10000
 )
 
 func (s *server) loginPostHandler(w http.ResponseWriter, r *http.Request) {
 	uid := r.Context().Value(uidKey).(string)
 
-	var user User
-	if err := s.db.
-		Where(User{
-			UserID: uid,
-		}).
-		Attrs(User{
-			UserID: uid,
-			Name:   randStr(defaultNameLen),
-		}).
-		FirstOrCreate(&user).Error; err != nil {
+	user, err := s.store.FirstOrCreateUser(uid, randStr(defaultNameLen))
+	if err != nil {
 		log.Println(err)
 		http.Error(w, "failed to fetch or create user", http.StatusInternalServerError)
 		return
@@ -69,16 +73,8 @@ func (s *server) userNamePostHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var user User
-	if err := s.db.
-		Where(User{
-			UserID: uid,
-		}).
-		Assign(User{
-			UserID: uid,
-			Name:   name,
-		}).
-		FirstOrCreate(&user).Error; err != nil {
+	user, err := s.store.UpdateUserName(uid, name)
+	if err != nil {
 		log.Println(err)
 		http.Error(w, "failed to change username", http.StatusInternalServerError)
 		return
@@ -88,15 +84,30 @@ func (s *server) userNamePostHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(user)
 }
 
+func (s *server) userDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(uidKey).(string)
+	if _, ok := r.Context().Value(tokenScopeKey).(string); ok {
+		http.Error(w, "account cannot be deleted with an access token", http.StatusForbidden)
+		return
+	}
+
+	err := s.store.DeleteUser(uid)
+	if err == store.ErrNotFound {
+		http.Error(w, "user is not registered", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to delete user", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 func (s *server) userSettingGetHandler(w http.ResponseWriter, r *http.Request) {
 	uid := r.Context().Value(uidKey).(string)
 
-	var detail UserDetail
-	if err := s.db.
-		Where(UserDetail{
-			UserID: uid,
-		}).
-		FirstOrInit(&detail).Error; err != nil {
+	detail, err := s.store.GetUserDetail(uid)
+	if err != nil {
 		log.Println(err)
 		http.Error(w, "failed to get setting", http.StatusInternalServerError)
 		return
@@ -115,6 +126,8 @@ func (s *server) userSettingPostHandler(w http.ResponseWriter, r *http.Request)
 		YukicoderID  string
 		AOJID        string
 		LeetCodeID   string
+
+		PublicJudgeIDs bool
 	}
 	var b changeSettingBody
 	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
@@ -124,7 +137,10 @@ func (s *server) userSettingPostHandler(w http.ResponseWriter, r *http.Request)
 
 	elem := reflect.ValueOf(&b).Elem()
 	for i := 0; i < elem.NumField(); i++ {
-		x := elem.Field(i).Interface().(string)
+		x, ok := elem.Field(i).Interface().(string)
+		if !ok {
+			continue
+		}
 		if err := validation.Validate(
 			x,
 			validation.Length(0, 100),
@@ -135,20 +151,17 @@ func (s *server) userSettingPostHandler(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	var detail UserDetail
-	if err := s.db.
-		Where(UserDetail{
-			UserID: uid,
-		}).
-		Assign(map[string]interface{}{
-			"user_id":       uid,
-			"at_coder_id":   b.AtCoderID,
-			"codeforces_id": b.CodeforcesID,
-			"yukicoder_id":  b.YukicoderID,
-			"aoj_id":        b.AOJID,
-			"leet_code_id":  b.LeetCodeID,
-		}).
-		FirstOrCreate(&detail).Error; err != nil {
+	detail, err := s.store.UpdateUserDetail(UserDetail{
+		UserID:       uid,
+		AtCoderID:    b.AtCoderID,
+		CodeforcesID: b.CodeforcesID,
+		YukicoderID:  b.YukicoderID,
+		AOJID:        b.AOJID,
+		LeetCodeID:   b.LeetCodeID,
+
+		PublicJudgeIDs: b.PublicJudgeIDs,
+	})
+	if err != nil {
 		log.Println(err)
 		http.Error(w, "failed to change setting", http.StatusInternalServerError)
 		return
@@ -158,6 +171,155 @@ func (s *server) userSettingPostHandler(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(detail)
 }
 
+func (s *server) solvedGetHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(uidKey).(string)
+	q := r.URL.Query()
+	domain := q.Get("domain")
+
+	problemNoList, err := s.store.SolvedProblemNos(uid, domain)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to get solved problems", http.StatusInternalServerError)
+		return
+	}
+
+	type response struct {
+		ProblemNoList []int
+	}
+	resp := response{ProblemNoList: []int{}}
+	resp.ProblemNoList = append(resp.ProblemNoList, problemNoList...)
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *server) accessTokenListGetHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(uidKey).(string)
+	if _, ok := r.Context().Value(tokenScopeKey).(string); ok {
+		http.Error(w, "access tokens cannot be managed with an access token", http.StatusForbidden)
+		return
+	}
+
+	tokens, err := s.store.ListAccessTokens(uid)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to get tokens", http.StatusInternalServerError)
+		return
+	}
+
+	type response struct {
+		Tokens []AccessToken
+	}
+	resp := response{Tokens: []AccessToken{}}
+	resp.Tokens = append(resp.Tokens, tokens...)
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *server) accessTokenPostHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(uidKey).(string)
+	if _, ok := r.Context().Value(tokenScopeKey).(string); ok {
+		http.Error(w, "access tokens cannot be managed with an access token", http.StatusForbidden)
+		return
+	}
+
+	type tokenPostBody struct {
+		Name  string
+		Scope string
+	}
+	var b tokenPostBody
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(b.Name)
+	if err := validation.Validate(
+		name,
+		validation.Required,
+		validation.Length(1, 100),
+	); err != nil {
+		http.Error(w, "invalid token name", http.StatusBadRequest)
+		return
+	}
+	if b.Scope != scopeRead && b.Scope != scopeReadWrite {
+		http.Error(w, "invalid token scope", http.StatusBadRequest)
+		return
+	}
+
+	count, err := s.store.CountAccessTokens(uid)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to count tokens", http.StatusInternalServerError)
+		return
+	}
+	if count >= maxAccessTokens {
+		http.Error(w, "too many tokens", http.StatusBadRequest)
+		return
+	}
+
+	raw, err := genAccessToken()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to create a token", http.StatusInternalServerError)
+		return
+	}
+	token := AccessToken{
+		UserID: uid,
+		Name:   name,
+		Hash:   hashAccessToken(raw),
+		Scope:  b.Scope,
+	}
+	if err := s.store.CreateAccessToken(&token); err != nil {
+		log.Println(err)
+		http.Error(w, "failed to create a token", http.StatusInternalServerError)
+		return
+	}
+
+	// トークンそのものはこのレスポンスでしか返さない
+	type response struct {
+		AccessToken
+		Token string
+	}
+	resp := response{
+		AccessToken: token,
+		Token:       raw,
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *server) accessTokenDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(uidKey).(string)
+	if _, ok := r.Context().Value(tokenScopeKey).(string); ok {
+		http.Error(w, "access tokens cannot be managed with an access token", http.StatusForbidden)
+		return
+	}
+
+	vars := mux.Vars(r)
+	tokenNo, _ := strconv.Atoi(vars["tokenNo"])
+	if tokenNo == 0 {
+		http.Error(w, "invalid request path", http.StatusBadRequest)
+		return
+	}
+
+	token, err := s.store.GetAccessToken(uid, tokenNo)
+	if err != nil {
+		http.Error(w, "token does not exist", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.store.DeleteAccessToken(token.No); err != nil {
+		log.Println(err)
+		http.Error(w, "failed to delete token", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
 func (s *server) authNoteGetHandler(w http.ResponseWriter, r *http.Request) {
 	uid := r.Context().Value(uidKey).(string)
 	q := r.URL.Query()
@@ -168,19 +330,15 @@ func (s *server) authNoteGetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	nfilter := Note{ID: noteID}
-	var note Note
-	if err := s.db.
-		Preload("User").
-		Preload("Problem").
-		Where(&nfilter).
-		Take(&note).Error; err != nil {
+	note, err := s.store.GetNote(noteID)
+	if err != nil {
 		http.Error(w, "note not found", http.StatusNotFound)
 		return
 	}
 
 	if note.User.UserID != uid && note.Public == 1 {
 		http.Error(w, "note not found", http.StatusNotFound)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
@@ -197,17 +355,8 @@ func (s *server) myNoteGetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pfilter := Problem{No: problemNo}
-	ufilter := User{UserID: uid}
-	var note Note
-	if err := s.db.
-		Preload("User").
-		Preload("Problem").
-		Joins("left join problems on problems.no = notes.problem_no").
-		Joins("left join users on users.no = notes.user_no").
-		Where(&pfilter).
-		Where(&ufilter).
-		Take(&note).Error; err != nil {
+	note, err := s.store.GetUserNote(uid, problemNo)
+	if err != nil {
 		log.Println(err)
 		http.Error(w, "note not found", http.StatusNotFound)
 		return
@@ -253,45 +402,18 @@ func (s *server) myNotePostHandler(w http.ResponseWriter, r *http.Request) {
 		public = 1
 	}
 
-	var problem Problem
-	var user User
-	if err := s.db.
-		Where(Problem{
-			No: problemNo,
-		}).
-		Take(&problem).Error; err != nil {
+	if _, err := s.store.GetProblem(problemNo); err != nil {
 		http.Error(w, "no problem matched", http.StatusBadRequest)
 		return
 	}
-	if err := s.db.
-		Where(User{
-			UserID: uid,
-		}).
-		Take(&user).Error; err != nil {
+	user, err := s.store.GetUser(uid)
+	if err != nil {
 		http.Error(w, "user not registered", http.StatusBadRequest)
 		return
 	}
 
-	var note Note
-	randID, err := genUUID()
+	note, err := s.store.SaveNote(user.No, problemNo, b.Text, public)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "failed to create a note", http.StatusInternalServerError)
-		return
-	}
-	if err := s.db.
-		Where(Note{
-			ProblemNo: problemNo,
-			UserNo:    user.No,
-		}).
-		Attrs(Note{
-			ID: randID,
-		}).
-		Assign(Note{
-			Text:   b.Text,
-			Public: public,
-		}).
-		FirstOrCreate(&note).Error; err != nil {
 		log.Println(err)
 		http.Error(w, "failed to create or update note", http.StatusInternalServerError)
 		return
@@ -311,21 +433,14 @@ func (s *server) myNoteDeleteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pfilter := Problem{No: problemNo}
-	ufilter := User{UserID: uid}
-	var note Note
-	if err := s.db.
-		Joins("left join problems on problems.no = notes.problem_no").
-		Joins("left join users on users.no = notes.user_no").
-		Where(&pfilter).
-		Where(&ufilter).
-		Take(&note).Error; err != nil {
+	note, err := s.store.GetUserNote(uid, problemNo)
+	if err != nil {
 		log.Println(err)
 		http.Error(w, "note does not exist", http.StatusBadRequest)
 		return
 	}
 
-	if err := s.db.Delete(&note).Error; err != nil {
+	if err := s.store.DeleteNote(note); err != nil {
 		log.Println(err)
 		http.Error(w, "failed to delete note", http.StatusInternalServerError)
 		return
@@ -340,7 +455,7 @@ func (s *server) myNoteListGetHandler(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 	domain := q.Get("domain")
 	contestID := q.Get("contestId")
-	tag := q.Get("tag")
+	text := strings.TrimSpace(q.Get("q"))
 	limit, _ := strconv.Atoi(q.Get("limit"))
 	skip, _ := strconv.Atoi(q.Get("skip"))
 	order := q.Get("order")
@@ -351,96 +466,39 @@ func (s *server) myNoteListGetHandler(w http.ResponseWriter, r *http.Request) {
 		limit = 100
 	}
 
-	if order == "" || order == "-updated" {
-		order = "updated_at desc"
-	} else {
+	noteOrder, ok := parseNoteOrder(order, text)
+	if !ok {
 		http.Error(w, "invalid sort order", http.StatusBadRequest)
 		return
 	}
 
-	pfilter := Problem{
+	f := store.NoteFilter{
 		Domain:    domain,
 		ContestID: contestID,
-	}
-	ufilter := User{UserID: uid}
-	tfilter := Tag{Key: tag}
-
-	// ここ綺麗に書きたい
-	count := 0
-	if tag == "" {
-		if err := s.db.
-			Model(&Note{}).
-			Joins("left join problems on problems.no = notes.problem_no").
-			Joins("left join users on users.no = notes.user_no").
-			Where(&pfilter).
-			Where(&ufilter).
-			Count(&count).Error; err != nil {
-			log.Println(err)
-			http.Error(w, "failed to count", http.StatusInternalServerError)
-			return
-		}
-	} else {
-		if err := s.db.
-			Model(&Note{}).
-			Joins("left join problems on problems.no = notes.problem_no").
-			Joins("left join users on users.no = notes.user_no").
-			Joins("left join tag_maps on tag_maps.note_id = notes.id").
-			Joins("left join tags on tags.no = tag_maps.tag_no").
-			Where(&pfilter).
-			Where(&ufilter).
-			Where(&tfilter).
-			Count(&count).Error; err != nil {
-			log.Println(err)
-			http.Error(w, "failed to count", http.StatusInternalServerError)
-			return
-		}
+		UserID:    uid,
+		Text:      text,
+		Limit:     limit,
+		Skip:      skip,
+		Order:     noteOrder,
+	}
+	if err := parseTagFilter(q, &f); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	var notes []Note
-	if tag == "" {
-		if err := s.db.
-			Limit(limit).Offset(skip).Order(order).
-			Preload("User").
-			Preload("Problem").
-			Joins("left join problems on problems.no = notes.problem_no").
-			Joins("left join users on users.no = notes.user_no").
-			Where(&pfilter).
-			Where(&ufilter).
-			Find(&notes).Error; err != nil {
-			log.Println(err)
-			http.Error(w, "failed to fetch note list", http.StatusInternalServerError)
-			return
-		}
-	} else {
-		if err := s.db.
-			Limit(limit).Offset(skip).Order(order).
-			Preload("User").
-			Preload("Problem").
-			Joins("left join problems on problems.no = notes.problem_no").
-			Joins("left join users on users.no = notes.user_no").
-			Joins("left join tag_maps on tag_maps.note_id = notes.id").
-			Joins("left join tags on tags.no = tag_maps.tag_no").
-			Where(&pfilter).
-			Where(&ufilter).
-			Where(&tfilter).
-			Find(&notes).Error; err != nil {
-			log.Println(err)
-			http.Error(w, "failed to fetch note list", http.StatusInternalServerError)
-			return
-		}
-	}
+	s.writeNoteList(w, f)
+}
 
-	type noteListResp struct {
-		Count int
-		Notes []Note
-	}
-	resp := noteListResp{
-		Count: count,
-		Notes: notes,
-	}
+func (s *server) myTagsGetHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(uidKey).(string)
 
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	json.NewEncoder(w).Encode(resp)
+	tags, err := s.store.ListUserTags(uid)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to get tags", http.StatusInternalServerError)
+		return
+	}
+	writeTagList(w, tags)
 }
 
 func (s *server) tagGetHandler(w http.ResponseWriter, r *http.Request) {
@@ -453,34 +511,22 @@ func (s *server) tagGetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pfilter := Problem{No: problemNo}
-	ufilter := User{UserID: uid}
-
-	type result struct {
-		Key string
-	}
-	var res []result
-	if err := s.db.
-		Model(&Note{}).
-		Joins("left join problems on problems.no = notes.problem_no").
-		Joins("left join users on users.no = notes.user_no").
-		Joins("left join tag_maps on tag_maps.note_id = notes.id").
-		Joins("left join tags on tags.no = tag_maps.tag_no").
-		Where(&pfilter).
-		Where(&ufilter).
-		Select("key").
-		Scan(&res).Error; err != nil {
-		log.Println(err)
-		http.Error(w, "failed to get tags", http.StatusInternalServerError)
-		return
-	}
-
 	type response struct {
 		Tags []string
 	}
 	resp := response{Tags: []string{}}
-	for _, v := range res {
-		resp.Tags = append(resp.Tags, v.Key)
+
+	note, err := s.store.GetUserNote(uid, problemNo)
+	if err == nil {
+		var tags []string
+		if tags, err = s.store.ListNoteTags(note.ID); err == nil {
+			resp.Tags = append(resp.Tags, tags...)
+		}
+	}
+	if err != nil && err != store.ErrNotFound {
+		log.Println(err)
+		http.Error(w, "failed to get tags", http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
@@ -506,75 +552,22 @@ func (s *server) tagPostHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	key := strings.TrimSpace(b.Tag)
-	if key == "" {
-		http.Error(w, "empty tag", http.StatusBadRequest)
-		return
-	}
-	if len(key) > 200 {
-		http.Error(w, "too large tag", http.StatusBadRequest)
-		return
-	}
-	if isInvalidTag(key) {
-		http.Error(w, "invalid tag", http.StatusBadRequest)
+	key := s.normalizeTag(b.Tag)
+	if err := validateTag(key); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	var problem Problem
-	if err := s.db.
-		Where(Problem{
-			No: problemNo,
-		}).
-		Take(&problem).Error; err != nil {
+	if _, err := s.store.GetProblem(problemNo); err != nil {
 		http.Error(w, "no problem matched", http.StatusBadRequest)
 		return
 	}
-	var user User
-	if err := s.db.
-		Where(User{
-			UserID: uid,
-		}).
-		Take(&user).Error; err != nil {
-		http.Error(w, "user is not registered", http.StatusBadRequest)
-		return
-	}
-	var tag Tag
-	if err := s.db.
-		Where(Tag{
-			Key: key,
-		}).
-		FirstOrCreate(&tag).Error; err != nil {
-		log.Println(err)
-		http.Error(w, "failed to fetch tag info", http.StatusInternalServerError)
-		return
-	}
-	var note Note
-	randID, err := genUUID()
+	user, err := s.store.GetUser(uid)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "failed to create a note", http.StatusInternalServerError)
-		return
-	}
-	if err := s.db.
-		Where(Note{
-			ProblemNo: problemNo,
-			UserNo:    user.No,
-		}).
-		Attrs(Note{
-			ID: randID,
-		}).
-		FirstOrCreate(&note).Error; err != nil {
-		log.Println(err)
-		http.Error(w, "failed to fetch note", http.StatusInternalServerError)
+		http.Error(w, "user is not registered", http.StatusBadRequest)
 		return
 	}
-	var tagMap TagMap
-	if err := s.db.
-		Where(TagMap{
-			NoteID: note.ID,
-			TagNo:  tag.No,
-		}).
-		FirstOrCreate(&tagMap).Error; err != nil {
+	if err := s.store.AddNoteTag(user.No, problemNo, key); err != nil {
 		log.Println(err)
 		http.Error(w, "failed to create note-tag map", http.StatusInternalServerError)
 		return
@@ -612,39 +605,21 @@ func (s *server) tagDeleteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var tag Tag
-	if err := s.db.
-		Where(Tag{
-			Key: key,
-		}).
-		Take(&tag).Error; err != nil {
+	tag, err := s.store.GetTag(key)
+	if err != nil {
 		http.Error(w, "tag does not exist", http.StatusBadRequest)
 		return
 	}
 
-	pfilter := Problem{No: problemNo}
-	ufilter := User{UserID: uid}
-	var note Note
-	if err := s.db.
-		Joins("left join problems on problems.no = notes.problem_no").
-		Joins("left join users on users.no = notes.user_no").
-		Where(&pfilter).
-		Where(&ufilter).
-		Take(&note).Error; err != nil {
+	note, err := s.store.GetUserNote(uid, problemNo)
+	if err != nil {
 		http.Error(w, "note does not exist", http.StatusBadRequest)
 		return
 	}
-	var tagMap TagMap
-	if err := s.db.
-		Where(TagMap{
-			NoteID: note.ID,
-			TagNo:  tag.No,
-		}).
-		Take(&tagMap).Error; err != nil {
+	if err := s.store.RemoveNoteTag(note.ID, tag.No); err == store.ErrNotFound {
 		http.Error(w, "note does not have the tag", http.StatusBadRequest)
 		return
-	}
-	if err := s.db.Delete(&tagMap).Error; err != nil {
+	} else if err != nil {
 		log.Println(err)
 		http.Error(w, "failed to delete tag", http.StatusInternalServerError)
 		return
@@ -653,22 +628,711 @@ func (s *server) tagDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-var randSrc = rand.NewSource(time.Now().UnixNano())
+func (s *server) tagRenamePostHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(uidKey).(string)
 
-func randStr(n int) string {
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letters[rand.Int63()%int64(len(letters))]
+	type tagRenameBody struct {
+		From string
+		To   string
 	}
-	return string(b)
+	var b tagRenameBody
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	from := strings.TrimSpace(b.From)
+	to := s.normalizeTag(b.To)
+	if from == "" {
+		http.Error(w, "empty tag", http.StatusBadRequest)
+		return
+	}
+	if err := validateTag(to); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if from == to {
+		http.Error(w, "same tag", http.StatusBadRequest)
+		return
+	}
+
+	tags, err := s.store.ListUserTags(uid)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to get tags", http.StatusInternalServerError)
+		return
+	}
+	used := make(map[string]bool)
+	for _, v := range tags {
+		used[v.Key] = true
+	}
+	if !used[from] {
+		http.Error(w, "tag does not exist", http.StatusBadRequest)
+		return
+	}
+	if used[to] {
+		http.Error(w, "tag already exists, merge instead", http.StatusBadRequest)
+		return
+	}
+
+	s.mergeTags(w, uid, []string{from}, to)
+}
+
+func (s *server) tagMergePostHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(uidKey).(string)
+
+	type tagMergeBody struct {
+		From []string
+		To   string
+	}
+	var b tagMergeBody
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(b.From) == 0 || len(b.From) > maxMergeTags {
+		http.Error(w, "invalid number of tags", http.StatusBadRequest)
+		return
+	}
+	var from []string
+	for _, v := range b.From {
+		key := strings.TrimSpace(v)
+		if key == "" {
+			http.Error(w, "empty tag", http.StatusBadRequest)
+			return
+		}
+		from = append(from, key)
+	}
+	to := s.normalizeTag(b.To)
+	if err := validateTag(to); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mergeTags(w, uid, from, to)
 }
 
-func genUUID() (string, error) {
-	u, err := uuid.NewRandom()
+func (s *server) tagBulkPostHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(uidKey).(string)
+
+	type tagBulkBody struct {
+		Operations []store.NoteTagOp
+	}
+	var b tagBulkBody
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(b.Operations) == 0 || len(b.Operations) > maxBulkOperations {
+		http.Error(w, "invalid number of operations", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.store.GetUser(uid)
 	if err != nil {
-		return "", err
+		http.Error(w, "user is not registered", http.StatusBadRequest)
+		return
+	}
+
+	type result struct {
+		store.NoteTagResult
+		Error string `json:",omitempty"`
+	}
+	type response struct {
+		Results []result
+	}
+
+	// 1つでも不正な操作があれば何も変更せず、どの操作が不正かを返す
+	resp := response{}
+	valid := true
+	for i, op := range b.Operations {
+		res := result{NoteTagResult: store.NoteTagResult{ProblemNo: op.ProblemNo}}
+		var err error
+		b.Operations[i], err = s.validateNoteTagOp(op)
+		if err != nil {
+			res.Error = err.Error()
+			valid = false
+		}
+		resp.Results = append(resp.Results, res)
+	}
+	if !valid {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	results, err := s.store.BulkUpdateNoteTags(user.No, b.Operations)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to update tags", http.StatusInternalServerError)
+		return
+	}
+	for i, v := range results {
+		resp.Results[i].NoteTagResult = v
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// タグを正規化して返す
+func (s *server) validateNoteTagOp(op store.NoteTagOp) (store.NoteTagOp, error) {
+	if len(op.Add)+len(op.Remove) == 0 || len(op.Add)+len(op.Remove) > maxBulkTags {
+		return op, errors.New("invalid number of tags")
+	}
+	if _, err := s.store.GetProblem(op.ProblemNo); err != nil {
+		return op, errors.New("no problem matched")
+	}
+
+	ret := store.NoteTagOp{ProblemNo: op.ProblemNo}
+	for _, v := range op.Add {
+		key := s.normalizeTag(v)
+		if err := validateTag(key); err != nil {
+			return op, err
+		}
+		ret.Add = append(ret.Add, key)
+	}
+	for _, v := range op.Remove {
+		key := strings.TrimSpace(v)
+		if key == "" {
+			return op, errors.New("empty tag")
+		}
+		ret.Remove = append(ret.Remove, key)
+	}
+	return ret, nil
+}
+
+func (s *server) mergeTags(w http.ResponseWriter, uid string, from []string, to string) {
+	updated, err := s.store.MergeUserTags(uid, from, to)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to update tags", http.StatusInternalServerError)
+		return
+	}
+
+	type response struct {
+		Updated int
+	}
+	resp := response{Updated: updated}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *server) noteRevisionListGetHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(uidKey).(string)
+
+	vars := mux.Vars(r)
+	problemNo, _ := strconv.Atoi(vars["problemNo"])
+	if problemNo == 0 {
+		http.Error(w, "invalid request path", http.StatusBadRequest)
+		return
+	}
+
+	note, err := s.store.GetUserNote(uid, problemNo)
+	if err != nil {
+		http.Error(w, "note not found", http.StatusNotFound)
+		return
+	}
+
+	revisions, err := s.store.ListNoteRevisions(note.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to fetch revisions", http.StatusInternalServerError)
+		return
+	}
+
+	type response struct {
+		Revisions []NoteRevision
+	}
+	resp := response{Revisions: []NoteRevision{}}
+	resp.Revisions = append(resp.Revisions, revisions...)
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *server) noteRevisionDiffGetHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(uidKey).(string)
+
+	vars := mux.Vars(r)
+	problemNo, _ := strconv.Atoi(vars["problemNo"])
+	if problemNo == 0 {
+		http.Error(w, "invalid request path", http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	from, _ := strconv.Atoi(q.Get("from"))
+	to, _ := strconv.Atoi(q.Get("to"))
+	if from == 0 || to == 0 {
+		http.Error(w, "invalid revision number", http.StatusBadRequest)
+		return
+	}
+
+	note, err := s.store.GetUserNote(uid, problemNo)
+	if err != nil {
+		http.Error(w, "note not found", http.StatusNotFound)
+		return
+	}
+
+	fromRevision, err := s.store.GetNoteRevision(note.ID, from)
+	if err != nil {
+		http.Error(w, "revision not found", http.StatusNotFound)
+		return
+	}
+	toRevision, err := s.store.GetNoteRevision(note.ID, to)
+	if err != nil {
+		http.Error(w, "revision not found", http.StatusNotFound)
+		return
+	}
+
+	type response struct {
+		From  int
+		To    int
+		Lines []diffLine
+	}
+	resp := response{
+		From:  from,
+		To:    to,
+		Lines: lineDiff(fromRevision.Text, toRevision.Text),
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *server) noteRevisionRestorePostHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(uidKey).(string)
+
+	vars := mux.Vars(r)
+	problemNo, _ := strconv.Atoi(vars["problemNo"])
+	revisionNo, _ := strconv.Atoi(vars["revisionNo"])
+	if problemNo == 0 || revisionNo == 0 {
+		http.Error(w, "invalid request path", http.StatusBadRequest)
+		return
+	}
+
+	note, err := s.store.GetUserNote(uid, problemNo)
+	if err != nil {
+		http.Error(w, "note not found", http.StatusNotFound)
+		return
+	}
+
+	revision, err := s.store.GetNoteRevision(note.ID, revisionNo)
+	if err != nil {
+		http.Error(w, "revision not found", http.StatusNotFound)
+		return
+	}
+
+	note, err = s.store.RestoreNoteRevision(note, revision)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to restore note", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(note)
+}
+
+func (s *server) followPostHandler(w http.ResponseWriter, r *http.Request) {
+	s.updateFollow(w, r, true)
+}
+
+func (s *server) followDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	s.updateFollow(w, r, false)
+}
+
+func (s *server) updateFollow(w http.ResponseWriter, r *http.Request, follow bool) {
+	uid := r.Context().Value(uidKey).(string)
+
+	vars := mux.Vars(r)
+	user, err := s.store.GetUser(uid)
+	if err != nil {
+		http.Error(w, "user is not registered", http.StatusBadRequest)
+		return
+	}
+	target, err := s.store.GetUserByName(vars["userName"])
+	if err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	if user.No == target.No {
+		http.Error(w, "cannot follow yourself", http.StatusBadRequest)
+		return
+	}
+
+	if follow {
+		err = s.store.Follow(user.No, target.No)
+	} else {
+		err = s.store.Unfollow(user.No, target.No)
+	}
+	if err == store.ErrNotFound {
+		http.Error(w, "not following", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to update follow", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *server) followersGetHandler(w http.ResponseWriter, r *http.Request) {
+	s.writeFollowList(w, r, s.store.ListFollowers)
+}
+
+func (s *server) followingGetHandler(w http.ResponseWriter, r *http.Request) {
+	s.writeFollowList(w, r, s.store.ListFollowing)
+}
+
+func (s *server) writeFollowList(w http.ResponseWriter, r *http.Request, list func(userNo int) ([]User, error)) {
+	uid := r.Context().Value(uidKey).(string)
+
+	user, err := s.store.GetUser(uid)
+	if err != nil {
+		http.Error(w, "user is not registered", http.StatusBadRequest)
+		return
+	}
+	users, err := list(user.No)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to get users", http.StatusInternalServerError)
+		return
+	}
+
+	type response struct {
+		Users []User
+	}
+	resp := response{Users: []User{}}
+	resp.Users = append(resp.Users, users...)
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *server) feedGetHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(uidKey).(string)
+
+	q := r.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if maxFeedLimit < limit {
+		limit = maxFeedLimit
+	} else if limit <= 0 {
+		limit = defaultFeedLimit
+	}
+	var after *store.NoteCursor
+	if c := q.Get("cursor"); c != "" {
+		cursor, err := decodeNoteCursor(c)
+		if err != nil {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+		after = &cursor
+	}
+
+	user, err := s.store.GetUser(uid)
+	if err != nil {
+		http.Error(w, "user is not registered", http.StatusBadRequest)
+		return
+	}
+
+	// 1件多く取得して次のページがあるかを判定する
+	notes, err := s.store.ListFeed(user.No, after, limit+1)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to fetch feed", http.StatusInternalServerError)
+		return
+	}
+
+	type response struct {
+		Notes      []Note
+		NextCursor string `json:",omitempty"`
+	}
+	resp := response{Notes: []Note{}}
+	if len(notes) > limit {
+		notes = notes[:limit]
+		last := notes[limit-1]
+		resp.NextCursor = encodeNoteCursor(store.NoteCursor{UpdatedAt: last.UpdatedAt, ID: last.ID})
+	}
+	resp.Notes = append(resp.Notes, notes...)
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// カーソルは "updated_at のUnixナノ秒,ノートID" をbase64urlでエンコードしたもの
+func encodeNoteCursor(c store.NoteCursor) string {
+	s := strconv.FormatInt(c.UpdatedAt.UnixNano(), 10) + "," + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func decodeNoteCursor(s string) (store.NoteCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return store.NoteCursor{}, err
+	}
+	parts := strings.SplitN(string(b), ",", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return store.NoteCursor{}, errors.New("invalid cursor")
+	}
+	nsec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return store.NoteCursor{}, err
+	}
+	return store.NoteCursor{
+		UpdatedAt: time.Unix(0, nsec),
+		ID:        parts[1],
+	}, nil
+}
+
+func (s *server) starPostHandler(w http.ResponseWriter, r *http.Request) {
+	s.updateStar(w, r, true)
+}
+
+func (s *server) starDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	s.updateStar(w, r, false)
+}
+
+func (s *server) updateStar(w http.ResponseWriter, r *http.Request, star bool) {
+	uid := r.Context().Value(uidKey).(string)
+
+	vars := mux.Vars(r)
+	user, err := s.store.GetUser(uid)
+	if err != nil {
+		http.Error(w, "user is not registered", http.StatusBadRequest)
+		return
+	}
+	note, err := s.store.GetNote(vars["noteID"])
+	if err != nil || note.Public != 2 {
+		http.Error(w, "note not found", http.StatusNotFound)
+		return
+	}
+
+	var count int
+	if star {
+		count, err = s.store.StarNote(user.No, note.ID)
+	} else {
+		count, err = s.store.UnstarNote(user.No, note.ID)
+	}
+	if err == store.ErrNotFound {
+		http.Error(w, "not starred", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to update star", http.StatusInternalServerError)
+		return
+	}
+
+	type response struct {
+		StarCount int
+	}
+	resp := response{StarCount: count}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *server) starredNoteListGetHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(uidKey).(string)
+
+	q := r.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	skip, _ := strconv.Atoi(q.Get("skip"))
+	if 1000 < limit {
+		limit = 1000
+	} else if limit == 0 {
+		limit = 100
+	}
+
+	user, err := s.store.GetUser(uid)
+	if err != nil {
+		http.Error(w, "user is not registered", http.StatusBadRequest)
+		return
+	}
+	notes, count, err := s.store.ListStarredNotes(user.No, limit, skip)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to fetch note list", http.StatusInternalServerError)
+		return
+	}
+
+	resp := noteListResp{
+		Count: count,
+		Notes: []Note{},
+	}
+	resp.Notes = append(resp.Notes, notes...)
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *server) noteCommentPostHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(uidKey).(string)
+
+	type commentPostBody struct {
+		Text     string
+		ParentNo *int
+	}
+	var b commentPostBody
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	text := strings.TrimSpace(b.Text)
+	if text == "" {
+		http.Error(w, "empty text", http.StatusBadRequest)
+		return
+	}
+	if len(text) > maxCommentLen {
+		http.Error(w, "too large text", http.StatusBadRequest)
+		return
+	}
+
+	vars := mux.Vars(r)
+	user, err := s.store.GetUser(uid)
+	if err != nil {
+		http.Error(w, "user is not registered", http.StatusBadRequest)
+		return
+	}
+	note, err := s.store.GetNote(vars["noteID"])
+	if err != nil || note.Public != 2 {
+		http.Error(w, "note not found", http.StatusNotFound)
+		return
+	}
+	if note.CommentsDisabled {
+		http.Error(w, "comments are disabled", http.StatusForbidden)
+		return
+	}
+	if b.ParentNo != nil {
+		if _, err := s.store.GetNoteComment(note.ID, *b.ParentNo); err != nil {
+			http.Error(w, "parent comment not found", http.StatusBadRequest)
+			return
+		}
+	}
+
+	comment := NoteComment{
+		NoteID:   note.ID,
+		ParentNo: b.ParentNo,
+		UserNo:   user.No,
+		Text:     text,
+	}
+	if err := s.store.CreateNoteComment(&comment); err != nil {
+		log.Println(err)
+		http.Error(w, "failed to create comment", http.StatusInternalServerError)
+		return
+	}
+	comment.User = user
+	comment.Replies = []NoteComment{}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(comment)
+}
+
+// コメントを書いたユーザーと、ノートの作者が削除できる
+func (s *server) noteCommentDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(uidKey).(string)
+
+	vars := mux.Vars(r)
+	commentNo, _ := strconv.Atoi(vars["commentNo"])
+	if commentNo == 0 {
+		http.Error(w, "invalid request path", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.store.GetUser(uid)
+	if err != nil {
+		http.Error(w, "user is not registered", http.StatusBadRequest)
+		return
+	}
+	note, err := s.store.GetNote(vars["noteID"])
+	if err != nil || (note.Public != 2 && note.UserNo != user.No) {
+		http.Error(w, "note not found", http.StatusNotFound)
+		return
+	}
+	comment, err := s.store.GetNoteComment(note.ID, commentNo)
+	if err != nil {
+		http.Error(w, "comment not found", http.StatusNotFound)
+		return
+	}
+	if comment.UserNo != user.No && note.UserNo != user.No {
+		http.Error(w, "cannot delete this comment", http.StatusForbidden)
+		return
+	}
+
+	if err := s.store.DeleteNoteComment(comment.No); err != nil {
+		log.Println(err)
+		http.Error(w, "failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *server) noteCommentSettingPostHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(uidKey).(string)
+
+	type commentSettingBody struct {
+		Disabled bool
+	}
+	var b commentSettingBody
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	vars := mux.Vars(r)
+	user, err := s.store.GetUser(uid)
+	if err != nil {
+		http.Error(w, "user is not registered", http.StatusBadRequest)
+		return
+	}
+	note, err := s.store.GetNote(vars["noteID"])
+	if err != nil || note.UserNo != user.No {
+		http.Error(w, "note not found", http.StatusNotFound)
+		return
+	}
+
+	if err := s.store.SetNoteCommentsDisabled(note.ID, b.Disabled); err != nil {
+		log.Println(err)
+		http.Error(w, "failed to update note", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+var randSrc = rand.NewSource(time.Now().UnixNano())
+
+func randStr(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = letters[rand.Int63()%int64(len(letters))]
+	}
+	return string(b)
+}
+
+// 前後の空白を除き、normalizeTags が有効なら小文字にして連続する空白を1つにまとめる
+func (s *server) normalizeTag(tag string) string {
+	key := strings.TrimSpace(tag)
+	if s.normalizeTags {
+		key = strings.Join(strings.Fields(strings.ToLower(key)), " ")
+	}
+	return key
+}
+
+func validateTag(key string) error {
+	if key == "" {
+		return errors.New("empty tag")
+	}
+	if len(key) > 200 {
+		return errors.New("too large tag")
+	}
+	if isInvalidTag(key) {
+		return errors.New("invalid tag")
 	}
-	return u.String(), nil
+	return nil
 }
 
 func isInvalidTag(s string) bool {
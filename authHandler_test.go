@@ -0,0 +1,1097 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	. "github.com/tsushiy/codernote-backend/db"
+	"github.com/tsushiy/codernote-backend/store"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	e := newTestEnv(t)
+
+	e.expect(e.do("POST", "/login", "", nil), http.StatusUnauthorized, nil)
+	e.expect(e.do("POST", "/login", "invalid", nil), http.StatusUnauthorized, nil)
+	e.expect(e.do("POST", "/login", accessTokenPrefix+"unknown", nil), http.StatusUnauthorized, nil)
+	e.expect(e.do("POST", "/login", e.token("alice"), nil), http.StatusOK, nil)
+}
+
+func TestLogin(t *testing.T) {
+	e := newTestEnv(t)
+	token := e.token("alice")
+
+	var first, second User
+	e.expect(e.do("POST", "/login", token, nil), http.StatusOK, &first)
+	e.expect(e.do("POST", "/login", token, nil), http.StatusOK, &second)
+	if first.UserID != "alice" || len(first.Name) != defaultNameLen {
+		t.Errorf("unexpected user: %+v", first)
+	}
+	if first.Name != second.Name {
+		t.Errorf("name changed on second login: %s -> %s", first.Name, second.Name)
+	}
+}
+
+func TestUserName(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.login("alice")
+	bob := e.login("bob")
+
+	var user User
+	e.expect(e.do("POST", "/user/name", alice, map[string]string{"Name": " alice_1 "}), http.StatusOK, &user)
+	if user.Name != "alice_1" {
+		t.Errorf("Name = %q, want alice_1", user.Name)
+	}
+
+	for _, name := range []string{"", "ab", "alice!", strings.Repeat("a", 31)} {
+		e.expect(e.do("POST", "/user/name", alice, map[string]string{"Name": name}), http.StatusBadRequest, nil)
+	}
+	e.expect(e.do("POST", "/user/name", bob, map[string]string{"Name": "alice_1"}), http.StatusInternalServerError, nil)
+}
+
+func TestUserSetting(t *testing.T) {
+	e := newTestEnv(t)
+	token := e.login("alice")
+
+	var detail UserDetail
+	e.expect(e.do("GET", "/user/setting", token, nil), http.StatusOK, &detail)
+	if detail.UserID != "alice" || detail.AtCoderID != "" {
+		t.Errorf("unexpected default setting: %+v", detail)
+	}
+
+	body := map[string]string{"AtCoderID": "tourist", "CodeforcesID": "tourist"}
+	e.expect(e.do("POST", "/user/setting", token, body), http.StatusOK, nil)
+	e.expect(e.do("GET", "/user/setting", token, nil), http.StatusOK, &detail)
+	if detail.AtCoderID != "tourist" || detail.CodeforcesID != "tourist" {
+		t.Errorf("setting was not saved: %+v", detail)
+	}
+
+	e.expect(e.do("POST", "/user/setting", token, map[string]string{"AtCoderID": "a b"}), http.StatusBadRequest, nil)
+}
+
+func TestSolved(t *testing.T) {
+	e := newTestEnv(t)
+	token := e.login("alice")
+	p1 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_a"})
+	p2 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_b"})
+	e.store.AddSubmission(Submission{UserID: "alice", Domain: "atcoder", ProblemNo: p1.No, Result: "WA"})
+	e.store.AddSubmission(Submission{UserID: "alice", Domain: "atcoder", ProblemNo: p1.No, Result: "AC"})
+	e.store.AddSubmission(Submission{UserID: "alice", Domain: "atcoder", ProblemNo: p2.No, Result: "WA"})
+	e.store.AddSubmission(Submission{UserID: "bob", Domain: "atcoder", ProblemNo: p2.No, Result: "AC"})
+
+	var resp struct {
+		ProblemNoList []int
+	}
+	e.expect(e.do("GET", "/user/solved?domain=atcoder", token, nil), http.StatusOK, &resp)
+	if len(resp.ProblemNoList) != 1 || resp.ProblemNoList[0] != p1.No {
+		t.Errorf("ProblemNoList = %v, want [%d]", resp.ProblemNoList, p1.No)
+	}
+}
+
+func TestNote(t *testing.T) {
+	e := newTestEnv(t)
+	p := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_a", Title: "A"})
+	path := fmt.Sprintf("/user/note/%d", p.No)
+
+	e.expect(e.do("POST", path, e.token("alice"), map[string]interface{}{"Text": "memo"}), http.StatusBadRequest, nil)
+
+	alice := e.login("alice")
+	bob := e.login("bob")
+	e.expect(e.do("POST", "/user/note/9999", alice, map[string]interface{}{"Text": "memo"}), http.StatusBadRequest, nil)
+	e.expect(e.do("POST", path, alice, map[string]interface{}{"Text": ""}), http.StatusBadRequest, nil)
+	e.expect(e.do("GET", path, alice, nil), http.StatusNotFound, nil)
+
+	var note Note
+	e.expect(e.do("POST", path, alice, map[string]interface{}{"Text": "memo"}), http.StatusOK, &note)
+	if note.Text != "memo" || note.Public != 1 {
+		t.Errorf("unexpected note: %+v", note)
+	}
+
+	var got Note
+	e.expect(e.do("GET", path, alice, nil), http.StatusOK, &got)
+	if got.ID != note.ID || got.Problem.No != p.No || got.User.UserID != "alice" {
+		t.Errorf("unexpected note: %+v", got)
+	}
+
+	// 非公開のノートは本人しか見られない
+	e.expect(e.do("GET", "/user/note?noteId="+note.ID, alice, nil), http.StatusOK, nil)
+	e.expect(e.do("GET", "/user/note?noteId="+note.ID, bob, nil), http.StatusNotFound, nil)
+	e.expect(e.do("GET", "/note?noteId="+note.ID, "", nil), http.StatusNotFound, nil)
+
+	e.expect(e.do("POST", path, alice, map[string]interface{}{"Text": "public memo", "Public": true}), http.StatusOK, &got)
+	if got.ID != note.ID || got.Public != 2 {
+		t.Errorf("note was not updated in place: %+v", got)
+	}
+	e.expect(e.do("GET", "/user/note?noteId="+note.ID, bob, nil), http.StatusOK, nil)
+	e.expect(e.do("GET", "/note?noteId="+note.ID, "", nil), http.StatusOK, &got)
+	if got.Text != "public memo" {
+		t.Errorf("Text = %q, want public memo", got.Text)
+	}
+
+	e.expect(e.do("DELETE", path, bob, nil), http.StatusBadRequest, nil)
+	e.expect(e.do("DELETE", path, alice, nil), http.StatusOK, nil)
+	e.expect(e.do("GET", path, alice, nil), http.StatusNotFound, nil)
+	e.expect(e.do("DELETE", path, alice, nil), http.StatusBadRequest, nil)
+}
+
+func TestMyNoteList(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.login("alice")
+	bob := e.login("bob")
+	p1 := e.store.AddProblem(Problem{Domain: "atcoder", ContestID: "abc001", ProblemID: "abc001_a", Title: "Binary Search"})
+	p2 := e.store.AddProblem(Problem{Domain: "atcoder", ContestID: "abc002", ProblemID: "abc002_a", Title: "Graph"})
+	p3 := e.store.AddProblem(Problem{Domain: "codeforces", ContestID: "1", ProblemID: "A", Title: "Theatre Square"})
+
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p1.No), alice, map[string]interface{}{"Text": "lower bound"}), http.StatusOK, nil)
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p2.No), alice, map[string]interface{}{"Text": "use binary lifting"}), http.StatusOK, nil)
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p3.No), alice, map[string]interface{}{"Text": "ceil division"}), http.StatusOK, nil)
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p1.No), bob, map[string]interface{}{"Text": "binary"}), http.StatusOK, nil)
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d/tag", p3.No), alice, map[string]string{"Tag": "math"}), http.StatusOK, nil)
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"", []int{p3.No, p2.No, p1.No}},
+		{"?domain=atcoder", []int{p2.No, p1.No}},
+		{"?contestId=abc001", []int{p1.No}},
+		{"?tag=math", []int{p3.No}},
+		{"?limit=1&skip=1", []int{p2.No}},
+		// タイトルに一致するものが先に来る
+		{"?q=binary", []int{p1.No, p2.No}},
+		{"?q=binary&order=-updated", []int{p2.No, p1.No}},
+	}
+	for _, tt := range tests {
+		var resp noteListResp
+		e.expect(e.do("GET", "/user/notes"+tt.query, alice, nil), http.StatusOK, &resp)
+		var got []int
+		for _, v := range resp.Notes {
+			got = append(got, v.ProblemNo)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: problems = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	var resp noteListResp
+	e.expect(e.do("GET", "/user/notes?q=lifting", alice, nil), http.StatusOK, &resp)
+	if resp.Count != 1 || resp.Snippets[resp.Notes[0].ID] != "use binary <b>lifting</b>" {
+		t.Errorf("unexpected search result: %+v", resp)
+	}
+
+	e.expect(e.do("GET", "/user/notes?order=stars", alice, nil), http.StatusBadRequest, nil)
+}
+
+func TestNoteListTagFilter(t *testing.T) {
+	e := newTestEnv(t)
+	token := e.login("alice")
+	noteTags := [][]string{
+		{"dp", "graph"},
+		{"dp", "graph", "easy"},
+		{"dp"},
+		{"graph"},
+		{},
+	}
+	var problems []Problem
+	for i, tags := range noteTags {
+		p := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: fmt.Sprint(i)})
+		problems = append(problems, p)
+		e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p.No), token, map[string]interface{}{"Text": "memo"}), http.StatusOK, nil)
+		for _, v := range tags {
+			e.expect(e.do("POST", fmt.Sprintf("/user/note/%d/tag", p.No), token, map[string]string{"Tag": v}), http.StatusOK, nil)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"?tag=dp", []int{0, 1, 2}},
+		{"?tags=dp,graph", []int{0, 1}},
+		{"?tags=dp&tags=graph&tagMode=all", []int{0, 1}},
+		{"?tags=dp,graph&tagMode=any", []int{0, 1, 2, 3}},
+		{"?tags=dp,graph&tagMode=any&excludeTags=easy", []int{0, 2, 3}},
+		{"?excludeTags=dp,graph", []int{4}},
+		{"?tag=dp&tags=graph", []int{0, 1}},
+		{"?tags=dp,graph&tagMode=any&limit=2&skip=1", []int{1, 2}},
+	}
+	for _, tt := range tests {
+		var resp noteListResp
+		e.expect(e.do("GET", "/user/notes"+tt.query+"&order=-updated", token, nil), http.StatusOK, &resp)
+		got := make(map[int]bool)
+		for _, v := range resp.Notes {
+			got[v.ProblemNo] = true
+		}
+		var want []int
+		for _, i := range tt.want {
+			want = append(want, problems[i].No)
+		}
+		if len(got) != len(resp.Notes) {
+			t.Errorf("%s: duplicated notes in %d results", tt.query, len(resp.Notes))
+		}
+		for _, v := range want {
+			if !got[v] {
+				t.Errorf("%s: problem %d is missing", tt.query, v)
+			}
+		}
+		if len(resp.Notes) != len(want) {
+			t.Errorf("%s: len(Notes) = %d, want %d", tt.query, len(resp.Notes), len(want))
+		}
+	}
+
+	var resp noteListResp
+	e.expect(e.do("GET", "/user/notes?tags=dp,graph&tagMode=any&limit=1", token, nil), http.StatusOK, &resp)
+	if resp.Count != 4 {
+		t.Errorf("Count = %d, want 4", resp.Count)
+	}
+	e.expect(e.do("GET", "/user/notes?tags=dp&tagMode=none", token, nil), http.StatusBadRequest, nil)
+	e.expect(e.do("GET", "/user/notes?tags=a,b,c,d,e,f,g,h,i,j,k", token, nil), http.StatusBadRequest, nil)
+}
+
+func TestNoteRevision(t *testing.T) {
+	e := newTestEnv(t)
+	token := e.login("alice")
+	p := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_a"})
+	path := fmt.Sprintf("/user/note/%d", p.No)
+
+	e.expect(e.do("GET", path+"/revisions", token, nil), http.StatusNotFound, nil)
+	e.expect(e.do("POST", path, token, map[string]interface{}{"Text": "a\nb"}), http.StatusOK, nil)
+	e.expect(e.do("POST", path, token, map[string]interface{}{"Text": "a\nc"}), http.StatusOK, nil)
+
+	var list struct {
+		Revisions []NoteRevision
+	}
+	e.expect(e.do("GET", path+"/revisions", token, nil), http.StatusOK, &list)
+	if len(list.Revisions) != 2 || list.Revisions[0].Text != "a\nc" {
+		t.Fatalf("unexpected revisions: %+v", list.Revisions)
+	}
+	older, newer := list.Revisions[1].No, list.Revisions[0].No
+
+	var diff struct {
+		Lines []diffLine
+	}
+	e.expect(e.do("GET", fmt.Sprintf("%s/revisions/diff?from=%d&to=%d", path, older, newer), token, nil), http.StatusOK, &diff)
+	if len(diff.Lines) != 3 {
+		t.Errorf("unexpected diff: %+v", diff.Lines)
+	}
+	e.expect(e.do("GET", path+"/revisions/diff?from=1", token, nil), http.StatusBadRequest, nil)
+	e.expect(e.do("GET", fmt.Sprintf("%s/revisions/diff?from=%d&to=9999", path, older), token, nil), http.StatusNotFound, nil)
+
+	var note Note
+	e.expect(e.do("POST", fmt.Sprintf("%s/revisions/%d/restore", path, older), token, nil), http.StatusOK, &note)
+	if note.Text != "a\nb" {
+		t.Errorf("Text = %q, want restored text", note.Text)
+	}
+	e.expect(e.do("GET", path+"/revisions", token, nil), http.StatusOK, &list)
+	if len(list.Revisions) != 3 {
+		t.Errorf("restore should add a revision: %+v", list.Revisions)
+	}
+	e.expect(e.do("POST", path+"/revisions/9999/restore", token, nil), http.StatusNotFound, nil)
+
+	// タグを付けて作られたノートも、作成時の履歴を持つ
+	p2 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_b"})
+	p3 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_c"})
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d/tag", p2.No), token, map[string]string{"Tag": "dp"}), http.StatusOK, nil)
+	e.expect(e.do("POST", "/user/tags/bulk", token, map[string]interface{}{
+		"Operations": []map[string]interface{}{{"ProblemNo": p3.No, "Add": []string{"dp"}}},
+	}), http.StatusOK, nil)
+	for _, no := range []int{p2.No, p3.No} {
+		path := fmt.Sprintf("/user/note/%d", no)
+		e.expect(e.do("GET", path+"/revisions", token, nil), http.StatusOK, &list)
+		if len(list.Revisions) != 1 || list.Revisions[0].Text != "" {
+			t.Errorf("problem %d: revisions = %+v, want one empty revision", no, list.Revisions)
+		}
+	}
+	// 同じノートにタグを足しても履歴は増えない
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d/tag", p2.No), token, map[string]string{"Tag": "graph"}), http.StatusOK, nil)
+	e.expect(e.do("GET", fmt.Sprintf("/user/note/%d/revisions", p2.No), token, nil), http.StatusOK, &list)
+	if len(list.Revisions) != 1 {
+		t.Errorf("revisions = %+v, want 1", list.Revisions)
+	}
+}
+
+func TestTag(t *testing.T) {
+	e := newTestEnv(t)
+	token := e.login("alice")
+	p := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_a"})
+	path := fmt.Sprintf("/user/note/%d/tag", p.No)
+
+	var resp struct {
+		Tags []string
+	}
+	e.expect(e.do("GET", path, token, nil), http.StatusOK, &resp)
+	if len(resp.Tags) != 0 {
+		t.Errorf("Tags = %v, want empty", resp.Tags)
+	}
+
+	// ノートがなくてもタグを付けられる
+	e.expect(e.do("POST", path, token, map[string]string{"Tag": "dp"}), http.StatusOK, nil)
+	e.expect(e.do("POST", path, token, map[string]string{"Tag": "dp"}), http.StatusOK, nil)
+	e.expect(e.do("POST", path, token, map[string]string{"Tag": "greedy"}), http.StatusOK, nil)
+	e.expect(e.do("GET", path, token, nil), http.StatusOK, &resp)
+	if fmt.Sprint(resp.Tags) != "[dp greedy]" {
+		t.Errorf("Tags = %v, want [dp greedy]", resp.Tags)
+	}
+
+	e.expect(e.do("POST", path, token, map[string]string{"Tag": ""}), http.StatusBadRequest, nil)
+	e.expect(e.do("POST", path, token, map[string]string{"Tag": "<script>"}), http.StatusBadRequest, nil)
+	e.expect(e.do("POST", "/user/note/9999/tag", token, map[string]string{"Tag": "dp"}), http.StatusBadRequest, nil)
+
+	e.expect(e.do("DELETE", path, token, map[string]string{"Tag": "dp"}), http.StatusOK, nil)
+	e.expect(e.do("DELETE", path, token, map[string]string{"Tag": "dp"}), http.StatusBadRequest, nil)
+	e.expect(e.do("DELETE", path, token, map[string]string{"Tag": "unknown"}), http.StatusBadRequest, nil)
+	e.expect(e.do("GET", path, token, nil), http.StatusOK, &resp)
+	if fmt.Sprint(resp.Tags) != "[greedy]" {
+		t.Errorf("Tags = %v, want [greedy]", resp.Tags)
+	}
+
+	// ノートを消すとタグも外れる
+	e.expect(e.do("DELETE", fmt.Sprintf("/user/note/%d", p.No), token, nil), http.StatusOK, nil)
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p.No), token, map[string]interface{}{"Text": "memo"}), http.StatusOK, nil)
+	e.expect(e.do("GET", path, token, nil), http.StatusOK, &resp)
+	if len(resp.Tags) != 0 {
+		t.Errorf("Tags = %v, want empty after the note was deleted", resp.Tags)
+	}
+	var list noteListResp
+	e.expect(e.do("GET", "/user/notes?tag=greedy", token, nil), http.StatusOK, &list)
+	if list.Count != 0 {
+		t.Errorf("Count = %d, want 0", list.Count)
+	}
+}
+
+func TestMyTags(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.login("alice")
+	bob := e.login("bob")
+	p1 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_a"})
+	p2 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_b"})
+
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d/tag", p1.No), alice, map[string]string{"Tag": "graph"}), http.StatusOK, nil)
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d/tag", p1.No), alice, map[string]string{"Tag": "dp"}), http.StatusOK, nil)
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d/tag", p2.No), alice, map[string]string{"Tag": "dp"}), http.StatusOK, nil)
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d/tag", p2.No), bob, map[string]string{"Tag": "easy"}), http.StatusOK, nil)
+
+	var resp tagListResp
+	e.expect(e.do("GET", "/user/tags", alice, nil), http.StatusOK, &resp)
+	if got := fmt.Sprint(resp.Tags); got != "[{dp 2} {graph 1}]" {
+		t.Errorf("Tags = %s, want [{dp 2} {graph 1}]", got)
+	}
+	e.expect(e.do("GET", "/user/tags", "", nil), http.StatusUnauthorized, nil)
+}
+
+func TestTagRenameAndMerge(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.login("alice")
+	bob := e.login("bob")
+	var problems []Problem
+	for _, id := range []string{"a", "b", "c"} {
+		problems = append(problems, e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: id}))
+	}
+	tag := func(token string, p Problem, key string) {
+		e.expect(e.do("POST", fmt.Sprintf("/user/note/%d/tag", p.No), token, map[string]string{"Tag": key}), http.StatusOK, nil)
+	}
+	tag(alice, problems[0], "segtree")
+	tag(alice, problems[1], "segtree")
+	tag(alice, problems[1], "seg-tree")
+	tag(alice, problems[2], "SegTree")
+	tag(bob, problems[0], "segtree")
+
+	var resp struct {
+		Updated int
+	}
+	e.expect(e.do("POST", "/user/tags/rename", alice, map[string]string{"From": "unknown", "To": "x"}), http.StatusBadRequest, nil)
+	e.expect(e.do("POST", "/user/tags/rename", alice, map[string]string{"From": "segtree", "To": "seg-tree"}), http.StatusBadRequest, nil)
+	e.expect(e.do("POST", "/user/tags/rename", alice, map[string]string{"From": "segtree", "To": "a/b"}), http.StatusBadRequest, nil)
+	e.expect(e.do("POST", "/user/tags/rename", alice, map[string]string{"From": "segtree", "To": "segment-tree"}), http.StatusOK, &resp)
+	if resp.Updated != 2 {
+		t.Errorf("Updated = %d, want 2", resp.Updated)
+	}
+
+	body := map[string]interface{}{"From": []string{"seg-tree", "SegTree"}, "To": "segment-tree"}
+	e.expect(e.do("POST", "/user/tags/merge", alice, body), http.StatusOK, &resp)
+	if resp.Updated != 2 {
+		t.Errorf("Updated = %d, want 2", resp.Updated)
+	}
+	e.expect(e.do("POST", "/user/tags/merge", alice, map[string]interface{}{"From": []string{}, "To": "x"}), http.StatusBadRequest, nil)
+
+	var tags tagListResp
+	e.expect(e.do("GET", "/user/tags", alice, nil), http.StatusOK, &tags)
+	if got := fmt.Sprint(tags.Tags); got != "[{segment-tree 3}]" {
+		t.Errorf("alice's tags = %s, want [{segment-tree 3}]", got)
+	}
+	e.expect(e.do("GET", "/user/tags", bob, nil), http.StatusOK, &tags)
+	if got := fmt.Sprint(tags.Tags); got != "[{segtree 1}]" {
+		t.Errorf("bob's tags = %s, want [{segtree 1}]", got)
+	}
+}
+
+// 1つのノートに from のタグが複数付いていても、to は1つだけ付く
+func TestMergeTagsOnSameNote(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.login("alice")
+	p := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_a"})
+	path := fmt.Sprintf("/user/note/%d/tag", p.No)
+	e.expect(e.do("POST", path, alice, map[string]string{"Tag": "dp"}), http.StatusOK, nil)
+	e.expect(e.do("POST", path, alice, map[string]string{"Tag": "DP"}), http.StatusOK, nil)
+	e.expect(e.do("POST", path, alice, map[string]string{"Tag": "graph"}), http.StatusOK, nil)
+
+	var resp struct {
+		Updated int
+	}
+	body := map[string]interface{}{"From": []string{"dp", "DP"}, "To": "dynamic-programming"}
+	e.expect(e.do("POST", "/user/tags/merge", alice, body), http.StatusOK, &resp)
+	if resp.Updated != 1 {
+		t.Errorf("Updated = %d, want 1", resp.Updated)
+	}
+
+	note, err := e.store.GetUserNote("alice", p.No)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, err := e.store.ListNoteTags(note.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(tags)
+	if got := fmt.Sprint(tags); got != "[dynamic-programming graph]" {
+		t.Errorf("tags = %s, want [dynamic-programming graph]", got)
+	}
+}
+
+func TestTagNormalization(t *testing.T) {
+	e := newTestEnv(t)
+	e.server.normalizeTags = true
+	token := e.login("alice")
+	p := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_a"})
+	path := fmt.Sprintf("/user/note/%d/tag", p.No)
+
+	e.expect(e.do("POST", path, token, map[string]string{"Tag": "  Dynamic   Programming "}), http.StatusOK, nil)
+	e.expect(e.do("POST", path, token, map[string]string{"Tag": "dynamic programming"}), http.StatusOK, nil)
+
+	var resp struct {
+		Tags []string
+	}
+	e.expect(e.do("GET", path, token, nil), http.StatusOK, &resp)
+	if fmt.Sprint(resp.Tags) != "[dynamic programming]" {
+		t.Errorf("Tags = %q, want [dynamic programming]", resp.Tags)
+	}
+}
+
+func TestTagBulk(t *testing.T) {
+	e := newTestEnv(t)
+	token := e.login("alice")
+	p1 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_a"})
+	p2 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_b"})
+	p3 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_c"})
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d/tag", p1.No), token, map[string]string{"Tag": "wip"}), http.StatusOK, nil)
+
+	type result struct {
+		ProblemNo int
+		Added     int
+		Removed   int
+		Error     string
+	}
+	var resp struct {
+		Results []result
+	}
+
+	// 不正な操作が含まれていれば何も変更しない
+	body := map[string]interface{}{
+		"Operations": []map[string]interface{}{
+			{"ProblemNo": p1.No, "Add": []string{"dp"}},
+			{"ProblemNo": 9999, "Add": []string{"dp"}},
+			{"ProblemNo": p2.No, "Add": []string{"a/b"}},
+		},
+	}
+	e.expect(e.do("POST", "/user/tags/bulk", token, body), http.StatusBadRequest, &resp)
+	if len(resp.Results) != 3 || resp.Results[0].Error != "" || resp.Results[1].Error == "" || resp.Results[2].Error == "" {
+		t.Errorf("unexpected results: %+v", resp.Results)
+	}
+	var tags tagListResp
+	e.expect(e.do("GET", "/user/tags", token, nil), http.StatusOK, &tags)
+	if got := fmt.Sprint(tags.Tags); got != "[{wip 1}]" {
+		t.Errorf("Tags = %s, want [{wip 1}]", got)
+	}
+
+	body = map[string]interface{}{
+		"Operations": []map[string]interface{}{
+			{"ProblemNo": p1.No, "Add": []string{"dp", "graph", "dp"}, "Remove": []string{"wip"}},
+			{"ProblemNo": p2.No, "Add": []string{"dp"}},
+			{"ProblemNo": p3.No, "Remove": []string{"wip"}},
+		},
+	}
+	resp.Results = nil
+	e.expect(e.do("POST", "/user/tags/bulk", token, body), http.StatusOK, &resp)
+	want := []result{
+		{ProblemNo: p1.No, Added: 2, Removed: 1},
+		{ProblemNo: p2.No, Added: 1},
+		{ProblemNo: p3.No},
+	}
+	if fmt.Sprint(resp.Results) != fmt.Sprint(want) {
+		t.Errorf("Results = %+v, want %+v", resp.Results, want)
+	}
+	e.expect(e.do("GET", "/user/tags", token, nil), http.StatusOK, &tags)
+	if got := fmt.Sprint(tags.Tags); got != "[{dp 2} {graph 1}]" {
+		t.Errorf("Tags = %s, want [{dp 2} {graph 1}]", got)
+	}
+	// 外すだけの操作ではノートを作らない
+	e.expect(e.do("GET", fmt.Sprintf("/user/note/%d", p3.No), token, nil), http.StatusNotFound, nil)
+
+	e.expect(e.do("POST", "/user/tags/bulk", token, map[string]interface{}{"Operations": []interface{}{}}), http.StatusBadRequest, nil)
+}
+
+func TestAccessToken(t *testing.T) {
+	e := newTestEnv(t)
+	token := e.login("alice")
+
+	e.expect(e.do("POST", "/user/tokens", token, map[string]string{"Name": "cli", "Scope": "admin"}), http.StatusBadRequest, nil)
+	e.expect(e.do("POST", "/user/tokens", token, map[string]string{"Name": " ", "Scope": scopeRead}), http.StatusBadRequest, nil)
+
+	var read, write struct {
+		AccessToken
+		Token string
+	}
+	e.expect(e.do("POST", "/user/tokens", token, map[string]string{"Name": "read", "Scope": scopeRead}), http.StatusOK, &read)
+	e.expect(e.do("POST", "/user/tokens", token, map[string]string{"Name": "write", "Scope": scopeReadWrite}), http.StatusOK, &write)
+	if !strings.HasPrefix(read.Token, accessTokenPrefix) {
+		t.Errorf("Token = %q, want prefix %s", read.Token, accessTokenPrefix)
+	}
+
+	var detail UserDetail
+	e.expect(e.do("GET", "/user/setting", read.Token, nil), http.StatusOK, &detail)
+	if detail.UserID != "alice" {
+		t.Errorf("UserID = %q, want alice", detail.UserID)
+	}
+	e.expect(e.do("POST", "/user/setting", read.Token, map[string]string{}), http.StatusForbidden, nil)
+	e.expect(e.do("POST", "/user/setting", write.Token, map[string]string{}), http.StatusOK, nil)
+	e.expect(e.do("GET", "/user/tokens", write.Token, nil), http.StatusForbidden, nil)
+
+	var list struct {
+		Tokens []AccessToken
+	}
+	e.expect(e.do("GET", "/user/tokens", token, nil), http.StatusOK, &list)
+	if len(list.Tokens) != 2 || list.Tokens[0].LastUsedAt == nil {
+		t.Errorf("unexpected tokens: %+v", list.Tokens)
+	}
+
+	bob := e.login("bob")
+	e.expect(e.do("DELETE", fmt.Sprintf("/user/tokens/%d", read.No), bob, nil), http.StatusBadRequest, nil)
+	e.expect(e.do("DELETE", fmt.Sprintf("/user/tokens/%d", read.No), token, nil), http.StatusOK, nil)
+	e.expect(e.do("GET", "/user/setting", read.Token, nil), http.StatusUnauthorized, nil)
+}
+
+func TestAccessTokenLimit(t *testing.T) {
+	e := newTestEnv(t)
+	token := e.login("alice")
+
+	for i := 0; i < maxAccessTokens; i++ {
+		e.expect(e.do("POST", "/user/tokens", token, map[string]string{"Name": "t", "Scope": scopeRead}), http.StatusOK, nil)
+	}
+	e.expect(e.do("POST", "/user/tokens", token, map[string]string{"Name": "t", "Scope": scopeRead}), http.StatusBadRequest, nil)
+}
+
+func TestFollow(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.login("alice")
+	bob := e.login("bob")
+	carol := e.login("carol")
+	e.expect(e.do("POST", "/user/name", alice, map[string]string{"Name": "alice"}), http.StatusOK, nil)
+	e.expect(e.do("POST", "/user/name", bob, map[string]string{"Name": "bob"}), http.StatusOK, nil)
+	e.expect(e.do("POST", "/user/name", carol, map[string]string{"Name": "carol"}), http.StatusOK, nil)
+
+	e.expect(e.do("POST", "/user/follow/bob", alice, nil), http.StatusOK, nil)
+	e.expect(e.do("POST", "/user/follow/bob", alice, nil), http.StatusOK, nil)
+	e.expect(e.do("POST", "/user/follow/carol", alice, nil), http.StatusOK, nil)
+	e.expect(e.do("POST", "/user/follow/bob", carol, nil), http.StatusOK, nil)
+	e.expect(e.do("POST", "/user/follow/alice", alice, nil), http.StatusBadRequest, nil)
+	e.expect(e.do("POST", "/user/follow/nobody", alice, nil), http.StatusNotFound, nil)
+	e.expect(e.do("POST", "/user/follow/bob", "", nil), http.StatusUnauthorized, nil)
+
+	type usersResp struct {
+		Users []User
+	}
+	names := func(users []User) string {
+		var s []string
+		for _, v := range users {
+			s = append(s, v.Name)
+		}
+		return strings.Join(s, ",")
+	}
+	var resp usersResp
+	e.expect(e.do("GET", "/user/following", alice, nil), http.StatusOK, &resp)
+	if got := names(resp.Users); got != "carol,bob" {
+		t.Errorf("following = %s, want carol,bob", got)
+	}
+	e.expect(e.do("GET", "/user/followers", bob, nil), http.StatusOK, &resp)
+	if got := names(resp.Users); got != "carol,alice" {
+		t.Errorf("followers = %s, want carol,alice", got)
+	}
+
+	e.expect(e.do("DELETE", "/user/follow/carol", alice, nil), http.StatusOK, nil)
+	e.expect(e.do("DELETE", "/user/follow/carol", alice, nil), http.StatusBadRequest, nil)
+	e.expect(e.do("GET", "/user/following", alice, nil), http.StatusOK, &resp)
+	if got := names(resp.Users); got != "bob" {
+		t.Errorf("following = %s, want bob", got)
+	}
+	e.expect(e.do("GET", "/user/followers", alice, nil), http.StatusOK, &resp)
+	if resp.Users == nil || len(resp.Users) != 0 {
+		t.Errorf("followers = %v, want []", resp.Users)
+	}
+}
+
+func TestFeed(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.login("alice")
+	bob := e.login("bob")
+	carol := e.login("carol")
+	e.expect(e.do("POST", "/user/name", bob, map[string]string{"Name": "bob"}), http.StatusOK, nil)
+
+	for i := 0; i < 3; i++ {
+		p := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: fmt.Sprintf("abc001_%d", i)})
+		path := fmt.Sprintf("/user/note/%d", p.No)
+		e.expect(e.do("POST", path, bob, map[string]interface{}{"Text": "public", "Public": true}), http.StatusOK, nil)
+		e.expect(e.do("POST", path, carol, map[string]interface{}{"Text": "public", "Public": true}), http.StatusOK, nil)
+	}
+	p := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc002_a"})
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p.No), bob, map[string]interface{}{"Text": "private", "Public": false}), http.StatusOK, nil)
+
+	type feedResp struct {
+		Notes      []Note
+		NextCursor string
+	}
+	var resp feedResp
+	e.expect(e.do("GET", "/user/feed", alice, nil), http.StatusOK, &resp)
+	if resp.Notes == nil || len(resp.Notes) != 0 || resp.NextCursor != "" {
+		t.Errorf("feed = %+v, want empty", resp)
+	}
+
+	e.expect(e.do("POST", "/user/follow/bob", alice, nil), http.StatusOK, nil)
+
+	// 2件ずつたどって、bobの公開ノートが重複なく新しい順に返る
+	var notes []Note
+	cursor := ""
+	for i := 0; i < 3; i++ {
+		resp = feedResp{}
+		e.expect(e.do("GET", "/user/feed?limit=2&cursor="+cursor, alice, nil), http.StatusOK, &resp)
+		notes = append(notes, resp.Notes...)
+		if cursor = resp.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if len(notes) != 3 || cursor != "" {
+		t.Fatalf("got %d notes, cursor %q, want 3 notes", len(notes), cursor)
+	}
+	seen := make(map[string]bool)
+	for i, v := range notes {
+		if v.User.Name != "bob" || v.Public != 2 || seen[v.ID] {
+			t.Errorf("notes[%d] = %+v", i, v)
+		}
+		seen[v.ID] = true
+		if i > 0 && v.UpdatedAt.After(notes[i-1].UpdatedAt) {
+			t.Errorf("notes are not ordered by updated_at")
+		}
+	}
+
+	e.expect(e.do("GET", "/user/feed?cursor=invalid", alice, nil), http.StatusBadRequest, nil)
+	e.expect(e.do("GET", "/user/feed", "", nil), http.StatusUnauthorized, nil)
+}
+
+func TestStar(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.login("alice")
+	bob := e.login("bob")
+	carol := e.login("carol")
+	p1 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_a"})
+	p2 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_b"})
+	p3 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_c"})
+
+	var n1, n2, n3, private Note
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p1.No), alice, map[string]interface{}{"Text": "a", "Public": true}), http.StatusOK, &n1)
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p2.No), alice, map[string]interface{}{"Text": "b", "Public": true}), http.StatusOK, &n2)
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p3.No), alice, map[string]interface{}{"Text": "c", "Public": true}), http.StatusOK, &n3)
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p1.No), bob, map[string]interface{}{"Text": "private"}), http.StatusOK, &private)
+	// 更新しても作成日時の順は変わらない
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p1.No), alice, map[string]interface{}{"Text": "a2", "Public": true}), http.StatusOK, nil)
+
+	type starResp struct {
+		StarCount int
+	}
+	star := func(method, token, noteID string, code, want int) {
+		t.Helper()
+		var resp starResp
+		if code != http.StatusOK {
+			e.expect(e.do(method, "/note/"+noteID+"/star", token, nil), code, nil)
+			return
+		}
+		e.expect(e.do(method, "/note/"+noteID+"/star", token, nil), http.StatusOK, &resp)
+		if resp.StarCount != want {
+			t.Errorf("%s %s: StarCount = %d, want %d", method, noteID, resp.StarCount, want)
+		}
+	}
+	star("POST", bob, n2.ID, http.StatusOK, 1)
+	star("POST", bob, n2.ID, http.StatusOK, 1)
+	star("POST", carol, n2.ID, http.StatusOK, 2)
+	star("POST", carol, n3.ID, http.StatusOK, 1)
+	star("POST", carol, private.ID, http.StatusNotFound, 0)
+	star("POST", carol, "unknown", http.StatusNotFound, 0)
+	star("POST", "", n1.ID, http.StatusUnauthorized, 0)
+	star("DELETE", bob, n3.ID, http.StatusBadRequest, 0)
+
+	var note Note
+	e.expect(e.do("GET", "/note?noteId="+n2.ID, "", nil), http.StatusOK, &note)
+	if note.StarCount != 2 || !note.UpdatedAt.Equal(n2.UpdatedAt) {
+		t.Errorf("note = %+v, want 2 stars and unchanged UpdatedAt", note)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"?order=-stars", []string{n2.ID, n3.ID, n1.ID}},
+		{"?order=-created", []string{n3.ID, n2.ID, n1.ID}},
+		{"?order=-updated", []string{n1.ID, n3.ID, n2.ID}},
+	}
+	for _, tt := range tests {
+		var resp noteListResp
+		e.expect(e.do("GET", "/notes"+tt.query, "", nil), http.StatusOK, &resp)
+		var got []string
+		for _, v := range resp.Notes {
+			got = append(got, v.ID)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: notes = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	var resp noteListResp
+	e.expect(e.do("GET", "/user/starred", carol, nil), http.StatusOK, &resp)
+	if resp.Count != 2 || len(resp.Notes) != 2 || resp.Notes[0].ID != n3.ID || resp.Notes[1].ID != n2.ID {
+		t.Errorf("starred = %+v, want [%s %s]", resp.Notes, n3.ID, n2.ID)
+	}
+
+	star("DELETE", carol, n2.ID, http.StatusOK, 1)
+	e.expect(e.do("GET", "/user/starred?limit=10", carol, nil), http.StatusOK, &resp)
+	if resp.Count != 1 || resp.Notes[0].ID != n3.ID {
+		t.Errorf("starred = %+v, want [%s]", resp.Notes, n3.ID)
+	}
+
+	// 非公開にしたノートは一覧に出ない
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p3.No), alice, map[string]interface{}{"Text": "c"}), http.StatusOK, nil)
+	e.expect(e.do("GET", "/user/starred", carol, nil), http.StatusOK, &resp)
+	if resp.Count != 0 || resp.Notes == nil || len(resp.Notes) != 0 {
+		t.Errorf("starred = %+v, want empty", resp)
+	}
+}
+
+func TestNoteComment(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.login("alice")
+	bob := e.login("bob")
+	carol := e.login("carol")
+	p1 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_a"})
+	p2 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_b"})
+
+	var note, private Note
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p1.No), alice, map[string]interface{}{"Text": "a", "Public": true}), http.StatusOK, &note)
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p2.No), alice, map[string]interface{}{"Text": "b"}), http.StatusOK, &private)
+	path := "/user/note/" + note.ID + "/comments"
+
+	var c1, c2, c3 NoteComment
+	e.expect(e.do("POST", path, bob, map[string]interface{}{"Text": " nice "}), http.StatusOK, &c1)
+	if c1.Text != "nice" || c1.ParentNo != nil || c1.User.UserID != "bob" {
+		t.Errorf("comment = %+v", c1)
+	}
+	e.expect(e.do("POST", path, alice, map[string]interface{}{"Text": "thanks", "ParentNo": c1.No}), http.StatusOK, &c2)
+	e.expect(e.do("POST", path, carol, map[string]interface{}{"Text": "question"}), http.StatusOK, &c3)
+	e.expect(e.do("POST", path, carol, map[string]interface{}{"Text": "reply", "ParentNo": 12345}), http.StatusBadRequest, nil)
+	e.expect(e.do("POST", path, carol, map[string]interface{}{"Text": " "}), http.StatusBadRequest, nil)
+	e.expect(e.do("POST", "/user/note/"+private.ID+"/comments", bob, map[string]interface{}{"Text": "hi"}), http.StatusNotFound, nil)
+	e.expect(e.do("POST", path, "", map[string]interface{}{"Text": "hi"}), http.StatusUnauthorized, nil)
+
+	var resp noteCommentsResp
+	e.expect(e.do("GET", "/note/"+note.ID+"/comments", "", nil), http.StatusOK, &resp)
+	if len(resp.Comments) != 2 || resp.Comments[0].No != c1.No || resp.Comments[1].No != c3.No ||
+		len(resp.Comments[0].Replies) != 1 || resp.Comments[0].Replies[0].No != c2.No {
+		t.Errorf("comments = %+v", resp.Comments)
+	}
+	e.expect(e.do("GET", "/note/"+private.ID+"/comments", "", nil), http.StatusNotFound, nil)
+
+	// 他人のコメントは削除できないが、ノートの作者は削除できる
+	e.expect(e.do("DELETE", fmt.Sprintf("%s/%d", path, c1.No), carol, nil), http.StatusForbidden, nil)
+	e.expect(e.do("DELETE", fmt.Sprintf("%s/%d", path, c3.No), carol, nil), http.StatusOK, nil)
+	e.expect(e.do("DELETE", fmt.Sprintf("%s/%d", path, c3.No), carol, nil), http.StatusNotFound, nil)
+	e.expect(e.do("DELETE", fmt.Sprintf("%s/%d", path, c1.No), alice, nil), http.StatusOK, nil)
+	resp = noteCommentsResp{}
+	e.expect(e.do("GET", "/note/"+note.ID+"/comments", "", nil), http.StatusOK, &resp)
+	if resp.Comments == nil || len(resp.Comments) != 0 {
+		t.Errorf("comments = %+v, want replies to be deleted too", resp.Comments)
+	}
+
+	e.expect(e.do("POST", path+"/setting", bob, map[string]bool{"Disabled": true}), http.StatusNotFound, nil)
+	e.expect(e.do("POST", path+"/setting", alice, map[string]bool{"Disabled": true}), http.StatusOK, nil)
+	e.expect(e.do("POST", path, bob, map[string]interface{}{"Text": "hi"}), http.StatusForbidden, nil)
+	e.expect(e.do("GET", "/note/"+note.ID+"/comments", "", nil), http.StatusOK, &resp)
+	if !resp.CommentsDisabled {
+		t.Error("CommentsDisabled = false, want true")
+	}
+	e.expect(e.do("POST", path+"/setting", alice, map[string]bool{"Disabled": false}), http.StatusOK, nil)
+	e.expect(e.do("POST", path, bob, map[string]interface{}{"Text": "hi"}), http.StatusOK, nil)
+
+	// ノートを削除するとコメントも消える
+	e.expect(e.do("DELETE", fmt.Sprintf("/user/note/%d", p1.No), alice, nil), http.StatusOK, nil)
+	if comments, _ := e.store.ListNoteComments(note.ID); len(comments) != 0 {
+		t.Errorf("comments = %+v, want none", comments)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.login("alice")
+	bob := e.login("bob")
+	e.expect(e.do("POST", "/user/name", alice, map[string]string{"Name": "alice"}), http.StatusOK, nil)
+	e.expect(e.do("POST", "/user/name", bob, map[string]string{"Name": "bob"}), http.StatusOK, nil)
+	e.expect(e.do("POST", "/user/setting", alice, map[string]string{"AtCoderID": "alice"}), http.StatusOK, nil)
+	p1 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_a"})
+	e.store.AddSubmission(Submission{UserID: "alice", Domain: "atcoder", ProblemNo: p1.No, Result: "AC"})
+
+	var aliceNote, bobNote Note
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p1.No), alice, map[string]interface{}{"Text": "a", "Public": true}), http.StatusOK, &aliceNote)
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p1.No), bob, map[string]interface{}{"Text": "b", "Public": true}), http.StatusOK, &bobNote)
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d/tag", p1.No), alice, map[string]string{"Tag": "dp"}), http.StatusOK, nil)
+	e.expect(e.do("POST", "/note/"+bobNote.ID+"/star", alice, nil), http.StatusOK, nil)
+	e.expect(e.do("POST", "/user/note/"+bobNote.ID+"/comments", alice, map[string]string{"Text": "hi"}), http.StatusOK, nil)
+	e.expect(e.do("POST", "/user/note/"+aliceNote.ID+"/comments", bob, map[string]string{"Text": "hi"}), http.StatusOK, nil)
+	e.expect(e.do("POST", "/user/follow/bob", alice, nil), http.StatusOK, nil)
+	e.expect(e.do("POST", "/user/follow/alice", bob, nil), http.StatusOK, nil)
+
+	var token struct {
+		Token string
+	}
+	e.expect(e.do("POST", "/user/tokens", alice, map[string]string{"Name": "cli", "Scope": scopeReadWrite}), http.StatusOK, &token)
+	e.expect(e.do("DELETE", "/user", token.Token, nil), http.StatusForbidden, nil)
+
+	e.expect(e.do("DELETE", "/user", alice, nil), http.StatusOK, nil)
+	e.expect(e.do("DELETE", "/user", alice, nil), http.StatusBadRequest, nil)
+
+	if _, err := e.store.GetUser("alice"); err != store.ErrNotFound {
+		t.Errorf("GetUser: err = %v, want ErrNotFound", err)
+	}
+	if _, err := e.store.GetNote(aliceNote.ID); err != store.ErrNotFound {
+		t.Errorf("GetNote: err = %v, want ErrNotFound", err)
+	}
+	if detail, _ := e.store.GetUserDetail("alice"); detail.AtCoderID != "" {
+		t.Errorf("detail = %+v, want empty", detail)
+	}
+	if solved, _ := e.store.SolvedProblemNos("alice", ""); len(solved) != 0 {
+		t.Errorf("solved = %v, want none", solved)
+	}
+	if n, _ := e.store.CountAccessTokens("alice"); n != 0 {
+		t.Errorf("CountAccessTokens = %d, want 0", n)
+	}
+
+	var note Note
+	e.expect(e.do("GET", "/note?noteId="+bobNote.ID, "", nil), http.StatusOK, &note)
+	if note.StarCount != 0 {
+		t.Errorf("StarCount = %d, want 0", note.StarCount)
+	}
+	var comments noteCommentsResp
+	e.expect(e.do("GET", "/note/"+bobNote.ID+"/comments", "", nil), http.StatusOK, &comments)
+	if len(comments.Comments) != 0 {
+		t.Errorf("comments = %+v, want none", comments.Comments)
+	}
+	var users struct {
+		Users []User
+	}
+	e.expect(e.do("GET", "/user/followers", bob, nil), http.StatusOK, &users)
+	if len(users.Users) != 0 {
+		t.Errorf("followers = %+v, want none", users.Users)
+	}
+	var tags tagListResp
+	e.expect(e.do("GET", "/tags/popular", "", nil), http.StatusOK, &tags)
+	if len(tags.Tags) != 0 {
+		t.Errorf("tags = %+v, want none", tags.Tags)
+	}
+}
+
+func TestExport(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.login("alice")
+	e.expect(e.do("POST", "/user/setting", alice, map[string]string{"AtCoderID": "alice_ac"}), http.StatusOK, nil)
+	p1 := e.store.AddProblem(Problem{Domain: "atcoder", ContestID: "abc001", ProblemID: "abc001_a", Title: "A \"quoted\""})
+	p2 := e.store.AddProblem(Problem{Domain: "leetcode", ProblemID: "two-sum", Title: "Two Sum"})
+
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p1.No), alice, map[string]interface{}{"Text": "# memo\nuse dp", "Public": true}), http.StatusOK, nil)
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p2.No), alice, map[string]interface{}{"Text": "hash map"}), http.StatusOK, nil)
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d/tag", p1.No), alice, map[string]string{"Tag": "dp"}), http.StatusOK, nil)
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d/tag", p1.No), alice, map[string]string{"Tag": "easy one"}), http.StatusOK, nil)
+
+	rec := e.do("GET", "/user/export", alice, nil)
+	e.expect(rec, http.StatusOK, nil)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/zip" {
+		t.Errorf("Content-Type = %q, want application/zip", ct)
+	}
+	body := rec.Body.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(b)
+	}
+
+	for _, name := range []string{"profile.json", "settings.json", "manifest.json", "notes/atcoder/abc001/abc001_a.md", "notes/leetcode/two-sum.md"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("%s is not exported", name)
+		}
+	}
+	if !strings.Contains(files["settings.json"], `"AtCoderID": "alice_ac"`) {
+		t.Errorf("settings.json = %s", files["settings.json"])
+	}
+	md := files["notes/atcoder/abc001/abc001_a.md"]
+	for _, want := range []string{"---\nproblemNo: ", "domain: \"atcoder\"\n", "title: \"A \\\"quoted\\\"\"\n", "tags: [\"dp\", \"easy one\"]\n", "public: true\n", "---\n# memo\nuse dp"} {
+		if !strings.Contains(md, want) {
+			t.Errorf("markdown does not contain %q:\n%s", want, md)
+		}
+	}
+	if !strings.Contains(files["notes/leetcode/two-sum.md"], "public: false\n") {
+		t.Errorf("markdown = %s", files["notes/leetcode/two-sum.md"])
+	}
+
+	var manifest exportManifest
+	if err := json.Unmarshal([]byte(files["manifest.json"]), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.User.UserID != "alice" || len(manifest.Notes) != 2 || manifest.Notes[0].Path != "notes/atcoder/abc001/abc001_a.md" {
+		t.Errorf("manifest = %+v", manifest)
+	}
+}
+
+// name と内容の組からZIPを作る
+func newZip(t *testing.T, files ...string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i+1 < len(files); i += 2 {
+		f, err := zw.Create(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(files[i+1]))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImport(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.login("alice")
+	p1 := e.store.AddProblem(Problem{Domain: "atcoder", ContestID: "abc001", ProblemID: "abc001_a"})
+	p2 := e.store.AddProblem(Problem{Domain: "codeforces", ContestID: "1", ProblemID: "A"})
+	p3 := e.store.AddProblem(Problem{Domain: "leetcode", ContestID: "algorithms", ProblemID: "1", Slug: "two-sum"})
+	e.store.AddProblem(Problem{Domain: "aoj", ContestID: "ITP1", ProblemID: "A"})
+	e.store.AddProblem(Problem{Domain: "aoj", ContestID: "ALDS1", ProblemID: "A"})
+	p4 := e.store.AddProblem(Problem{Domain: "atcoder", ContestID: "arc058", ProblemID: "arc058_a"})
+
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p2.No), alice, map[string]interface{}{"Text": "existing"}), http.StatusOK, nil)
+
+	archive := newZip(t,
+		"a.md", "---\ndomain: atcoder\nproblemId: \"abc001_a\"\ntags: [dp, \"two words\"]\npublic: true\n---\n# abc001 a\n",
+		"notes/b.md", "---\r\nurl: https://codeforces.com/problemset/problem/1/a\r\ntags:\r\n  - greedy\r\n---\r\nnew text",
+		"c.markdown", "---\nurl: 'https://leetcode.com/problems/two-sum/'\n---\nhash map",
+		"d.md", "---\ndomain: aoj\nproblemId: A\n---\nambiguous",
+		"e.md", "---\nurl: https://example.com/problems/1\n---\nunknown",
+		"f.md", "no front-matter",
+		"g.md", "---\ndomain: atcoder\nproblemId: abc999_a\n---\nmissing",
+		"h.md", "---\ndomain: atcoder\nproblemId: abc001_a\ntags: [\"<b>\"]\n---\ninvalid tag",
+		"i.md", "---\nurl: https://atcoder.jp/contests/abc042/tasks/arc058_a\n---\nshared task",
+		"manifest.json", "{}",
+	)
+	var resp importResp
+	e.expect(e.do("POST", "/user/import", alice, archive), http.StatusOK, &resp)
+	if resp.Imported != 3 || resp.Merged != 1 || resp.Skipped != 3 || resp.Unmatched != 3 {
+		t.Errorf("resp = %+v", resp)
+	}
+	want := []string{
+		"a.md imported", "notes/b.md merged", "c.markdown imported", "d.md unmatched", "e.md unmatched",
+		"f.md skipped", "g.md unmatched", "h.md skipped", "i.md imported", "manifest.json skipped",
+	}
+	for i, v := range resp.Files {
+		if i >= len(want) || v.Path+" "+v.Status != want[i] {
+			t.Errorf("Files[%d] = %+v, want %s", i, v, want[i])
+		}
+	}
+
+	note, err := e.store.GetUserNote("alice", p1.No)
+	if err != nil || note.Text != "# abc001 a\n" || note.Public != 2 {
+		t.Errorf("note = %+v, %v", note, err)
+	}
+	if tags, _ := e.store.ListNoteTags(note.ID); fmt.Sprint(tags) != "[dp two words]" {
+		t.Errorf("tags = %v", tags)
+	}
+	if note, _ := e.store.GetUserNote("alice", p3.No); note.Text != "hash map" || note.Public != 1 {
+		t.Errorf("note = %+v", note)
+	}
+	if note, _ := e.store.GetUserNote("alice", p4.No); note.Text != "shared task" {
+		t.Errorf("note = %+v", note)
+	}
+
+	// 既存のノートは overwrite=true のときだけ本文を置き換え、タグは追加する
+	note, _ = e.store.GetUserNote("alice", p2.No)
+	if tags, _ := e.store.ListNoteTags(note.ID); note.Text != "existing" || fmt.Sprint(tags) != "[greedy]" {
+		t.Errorf("note = %+v, tags = %v", note, tags)
+	}
+	e.expect(e.do("POST", "/user/import?overwrite=true", alice, newZip(t, "b.md", "---\ndomain: codeforces\ncontestId: 1\nproblemId: A\n---\nnew text")), http.StatusOK, &resp)
+	if note, _ := e.store.GetUserNote("alice", p2.No); resp.Merged != 1 || note.Text != "new text" {
+		t.Errorf("resp = %+v, note = %+v", resp, note)
+	}
+
+	e.expect(e.do("POST", "/user/import", alice, []byte("not a zip")), http.StatusBadRequest, nil)
+	e.expect(e.do("POST", "/user/import", "", archive), http.StatusUnauthorized, nil)
+}
+
+func TestExportImport(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.login("alice")
+	bob := e.login("bob")
+	p1 := e.store.AddProblem(Problem{Domain: "atcoder", ContestID: "abc001", ProblemID: "abc001_a", Title: "A"})
+	p2 := e.store.AddProblem(Problem{Domain: "yukicoder", ProblemID: "100", FrontendID: "1", Title: "B"})
+
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p1.No), alice, map[string]interface{}{"Text": "line1\n---\nline2", "Public": true}), http.StatusOK, nil)
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p2.No), alice, map[string]interface{}{"Text": "memo"}), http.StatusOK, nil)
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d/tag", p1.No), alice, map[string]string{"Tag": "a, b"}), http.StatusOK, nil)
+
+	rec := e.do("GET", "/user/export", alice, nil)
+	e.expect(rec, http.StatusOK, nil)
+	var resp importResp
+	e.expect(e.do("POST", "/user/import", bob, rec.Body.Bytes()), http.StatusOK, &resp)
+	if resp.Imported != 2 || resp.Skipped != 3 {
+		t.Errorf("resp = %+v", resp)
+	}
+
+	for _, no := range []int{p1.No, p2.No} {
+		want, _ := e.store.GetUserNote("alice", no)
+		got, err := e.store.GetUserNote("bob", no)
+		if err != nil || got.Text != want.Text || got.Public != want.Public {
+			t.Errorf("note = %+v, %v, want %+v", got, err, want)
+		}
+	}
+	note, _ := e.store.GetUserNote("bob", p1.No)
+	if tags, _ := e.store.ListNoteTags(note.ID); fmt.Sprint(tags) != "[a, b]" {
+		t.Errorf("tags = %q, want [a, b]", tags)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	_ "github.com/lib/pq"
+	. "github.com/tsushiy/codernote-backend/db"
+)
+
+const usage = `usage: migrate <command>
+
+commands:
+  up        apply all pending migrations
+  down [n]  roll back the last n migrations (default: 1)
+  status    show applied and pending migrations`
+
+// example: go run ./cmd/migrate up
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	db := DbConnect(false)
+	defer db.Close()
+
+	switch os.Args[1] {
+	case "up":
+		applied, err := MigrateUp(db)
+		for _, v := range applied {
+			log.Printf("Applied %d_%s", v.Version, v.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(applied) == 0 {
+			log.Println("No pending migrations")
+		}
+	case "down":
+		steps := 1
+		if len(os.Args) > 2 {
+			n, err := strconv.Atoi(os.Args[2])
+			if err != nil || n < 1 {
+				log.Fatalf("invalid number of steps: %s", os.Args[2])
+			}
+			steps = n
+		}
+		reverted, err := MigrateDown(db, steps)
+		for _, v := range reverted {
+			log.Printf("Rolled back %d_%s", v.Version, v.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "status":
+		statuses, err := MigrationStatuses(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, v := range statuses {
+			applied := "pending"
+			if v.AppliedAt != nil {
+				applied = v.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%4d  %-45s %s\n", v.Version, v.Name, applied)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
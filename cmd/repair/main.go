@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	_ "github.com/lib/pq"
+	. "github.com/tsushiy/codernote-backend/db"
+)
+
+// 外部キーを追加するマイグレーションの前に一度だけ実行する (マイグレーション7, 8は修復が必要な行が残っていると失敗する)
+// example: go run ./cmd/repair -dry-run
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report the number of rows to fix without changing anything")
+	flag.Parse()
+
+	db := DbConnect(false)
+	defer db.Close()
+
+	results, err := Repair(db, *dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, v := range results {
+		fmt.Printf("%-40s %d\n", v.Name, v.Rows)
+	}
+	if *dryRun {
+		fmt.Println("dry run: no changes were made")
+	}
+}
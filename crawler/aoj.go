@@ -2,10 +2,9 @@ package crawler
 
 import (
 	"encoding/json"
-	"log"
+	"net/url"
 	"strconv"
 
-	"github.com/jinzhu/gorm"
 	. "github.com/tsushiy/codernote-backend/db"
 )
 
@@ -13,9 +12,9 @@ const (
 	aojDomain                  = "aoj"
 	aojFilterURL               = "https://judgeapi.u-aizu.ac.jp/problems/filters"
 	aojCoursesURL              = "https://judgeapi.u-aizu.ac.jp/courses"
-	aojProblemsURL             = "https://judgeapi.u-aizu.ac.jp/problems?page=0&size=20000"
 	aojCategoryProblemsBaseURL = "https://judgeapi.u-aizu.ac.jp/problems/cl/"
 	aojCourseProblemsBaseURL   = "https://judgeapi.u-aizu.ac.jp/problems/courses/"
+	aojSolutionsBaseURL        = "https://judgeapi.u-aizu.ac.jp/solutions/users/"
 )
 
 type aojFilter struct {
@@ -41,25 +40,6 @@ type aojCourse struct {
 	} `json:"courses"`
 }
 
-type aojProblem struct {
-	ID                 string  `json:"id"`
-	Available          int     `json:"available"`
-	Doctype            int     `json:"doctype"`
-	Name               string  `json:"name"`
-	ProblemTimeLimit   int     `json:"problemTimeLimit"`
-	ProblemMemoryLimit int     `json:"problemMemoryLimit"`
-	MaxScore           int     `json:"maxScore"`
-	SolvedUser         int     `json:"solvedUser"`
-	Submissions        int     `json:"submissions"`
-	Recommendations    int     `json:"recommendations"`
-	IsSolved           bool    `json:"isSolved"`
-	Bookmark           bool    `json:"bookmark"`
-	Recommend          bool    `json:"recommend"`
-	SuccessRate        float64 `json:"successRate"`
-	Score              float64 `json:"score"`
-	UserScore          int     `json:"userScore"`
-}
-
 type aojCategoryProblems struct {
 	Progress         float64 `json:"progress"`
 	NumberOfProblems int     `json:"numberOfProblems"`
@@ -136,155 +116,137 @@ func getAOJCourses() ([]string, error) {
 	return courses, nil
 }
 
-// 使ってない
-func updateAOJProblems(db *gorm.DB) error {
-	log.Println("Start updating aoj problem info")
-	body, err := fetchAPI(aojProblemsURL)
-	if err != nil {
-		return err
-	}
-	var problems []aojProblem
-	if err := json.Unmarshal(body, &problems); err != nil {
-		return err
-	}
+type aojJudge struct {
+	contestIDs        []string
+	contestProblemIDs map[string][]string
+}
 
-	for _, v := range problems {
-		var problem Problem
-		if err := db.
-			Where(Problem{
-				Domain:    aojDomain,
-				ProblemID: v.ID,
-			}).
-			Assign(Problem{
-				Domain:     aojDomain,
-				ProblemID:  v.ID,
-				Title:      v.Name,
-				Difficulty: strconv.Itoa(v.SolvedUser),
-			}).
-			FirstOrCreate(&problem).Error; err != nil {
-			return err
-		}
-	}
+func init() {
+	RegisterJudge(&aojJudge{})
+}
 
-	return nil
+func (j *aojJudge) Domain() string {
+	return aojDomain
 }
 
-func updateAOJContests(db *gorm.DB) error {
-	log.Println("Start updating aoj contest info")
+// AOJにはコンテストがないので、カテゴリとコースをコンテストとして扱う
+func (j *aojJudge) FetchProblems() ([]Problem, error) {
+	j.contestIDs = nil
+	j.contestProblemIDs = make(map[string][]string)
+
 	categories, err := getAOJCategories()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	courses, err := getAOJCourses()
+	if err != nil {
+		return nil, err
 	}
 
+	var problems []Problem
 	for _, v := range categories {
-		url := aojCategoryProblemsBaseURL + v
-		body, err := fetchAPI(url)
+		body, err := fetchAPI(aojCategoryProblemsBaseURL + v)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		var ret aojCategoryProblems
 		if err := json.Unmarshal(body, &ret); err != nil {
-			return err
+			return nil, err
 		}
-		problems := ret.Problems
-
-		var problemNoList []int64
-		for _, p := range problems {
-			var problem Problem
-			if err := db.
-				Where(Problem{
-					Domain:    aojDomain,
-					ProblemID: p.ID,
-				}).
-				Assign(Problem{
-					Domain:     aojDomain,
-					ProblemID:  p.ID,
-					ContestID:  v,
-					Title:      p.Name,
-					Difficulty: strconv.Itoa(p.SolvedUser),
-				}).
-				FirstOrCreate(&problem).Error; err != nil {
-				return err
+		j.contestIDs = append(j.contestIDs, v)
+		for _, p := range ret.Problems {
+			problem := Problem{
+				Domain:     aojDomain,
+				ProblemID:  p.ID,
+				ContestID:  v,
+				Title:      p.Name,
+				Difficulty: strconv.Itoa(p.SolvedUser),
 			}
-			problemNoList = append(problemNoList, int64(problem.No))
-		}
-
-		if err := db.
-			Where(Contest{
-				Domain:    aojDomain,
-				ContestID: v,
-			}).
-			Assign(Contest{
-				Domain:        aojDomain,
-				ContestID:     v,
-				Title:         v,
-				ProblemNoList: problemNoList,
-			}).
-			FirstOrCreate(&Contest{}).Error; err != nil {
-			return err
+			setDifficulty(&problem, DifficultyAOJSolved, float64(p.SolvedUser))
+			problems = append(problems, problem)
+			j.contestProblemIDs[v] = append(j.contestProblemIDs[v], p.ID)
 		}
 	}
-
-	courses, err := getAOJCourses()
-	if err != nil {
-		return err
-	}
-
 	for _, v := range courses {
-		url := aojCourseProblemsBaseURL + v
-		body, err := fetchAPI(url)
+		body, err := fetchAPI(aojCourseProblemsBaseURL + v)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		var ret aojCourseProblems
 		if err := json.Unmarshal(body, &ret); err != nil {
-			return err
+			return nil, err
 		}
-		problems := ret.Problems
-
-		var problemNoList []int64
-		for _, p := range problems {
-			var problem Problem
-			if err := db.
-				Where(Problem{
-					Domain:    aojDomain,
-					ProblemID: p.ID,
-				}).
-				Assign(Problem{
-					Domain:     aojDomain,
-					ProblemID:  p.ID,
-					ContestID:  v,
-					Title:      p.Name,
-					Difficulty: strconv.Itoa(p.SolvedUser),
-				}).
-				FirstOrCreate(&problem).Error; err != nil {
-				return err
+		j.contestIDs = append(j.contestIDs, v)
+		for _, p := range ret.Problems {
+			problem := Problem{
+				Domain:     aojDomain,
+				ProblemID:  p.ID,
+				ContestID:  v,
+				Title:      p.Name,
+				Difficulty: strconv.Itoa(p.SolvedUser),
 			}
-			problemNoList = append(problemNoList, int64(problem.No))
+			setDifficulty(&problem, DifficultyAOJSolved, float64(p.SolvedUser))
+			problems = append(problems, problem)
+			j.contestProblemIDs[v] = append(j.contestProblemIDs[v], p.ID)
 		}
+	}
+
+	return problems, nil
+}
+
+func (j *aojJudge) FetchContests(problems []Problem) ([]Contest, error) {
+	problemNos := problemNoMap(problems)
 
-		if err := db.
-			Where(Contest{
-				Domain:    aojDomain,
-				ContestID: v,
-			}).
-			Assign(Contest{
-				Domain:        aojDomain,
-				ContestID:     v,
-				Title:         v,
-				ProblemNoList: problemNoList,
-			}).
-			FirstOrCreate(&Contest{}).Error; err != nil {
-			return err
+	var contests []Contest
+	for _, v := range j.contestIDs {
+		var problemNoList []int64
+		for _, id := range j.contestProblemIDs[v] {
+			problemNoList = append(problemNoList, int64(problemNos[id]))
 		}
+		contests = append(contests, Contest{
+			Domain:        aojDomain,
+			ContestID:     v,
+			Title:         v,
+			ProblemNoList: problemNoList,
+			Status:        ContestFinished,
+		})
 	}
 
-	return nil
+	return contests, nil
+}
+
+type aojSolution struct {
+	JudgeID        int    `json:"judgeId"`
+	UserID         string `json:"userId"`
+	ProblemID      string `json:"problemId"`
+	Language       string `json:"language"`
+	Version        string `json:"version"`
+	SubmissionDate int64  `json:"submissionDate"`
+	JudgeDate      int64  `json:"judgeDate"`
+	CPUTime        int    `json:"cpuTime"`
+	Memory         int    `json:"memory"`
+	CodeSize       int    `json:"codeSize"`
 }
 
-func updateAOJ(db *gorm.DB) error {
-	if err := updateAOJContests(db); err != nil {
-		return err
+// solutions APIはACした提出のみを返す
+func fetchAOJSubmissions(account string) ([]userSubmission, error) {
+	body, err := fetchAPI(aojSolutionsBaseURL + url.PathEscape(account) + "?page=0&size=100000")
+	if err != nil {
+		return nil, err
+	}
+	var ret []aojSolution
+	if err := json.Unmarshal(body, &ret); err != nil {
+		return nil, err
+	}
+
+	var submissions []userSubmission
+	for _, v := range ret {
+		submissions = append(submissions, userSubmission{
+			SubmissionID: strconv.Itoa(v.JudgeID),
+			ProblemID:    v.ProblemID,
+			Result:       resultAccepted,
+			EpochSecond:  int(v.SubmissionDate / 1000),
+		})
 	}
-	return nil
+	return submissions, nil
 }
@@ -4,10 +4,10 @@ import (
 	"encoding/json"
 	"log"
 	"math"
+	"net/url"
 	"sort"
 	"strconv"
 
-	"github.com/jinzhu/gorm"
 	. "github.com/tsushiy/codernote-backend/db"
 )
 
@@ -17,10 +17,9 @@ const (
 	atcoderContestsURL       = "https://kenkoooo.com/atcoder/resources/contests.json"
 	atcoderContestProblemURL = "https://kenkoooo.com/atcoder/resources/contest-problem.json"
 	atcoderDifficultyURL     = "https://kenkoooo.com/atcoder/resources/problem-models.json"
+	atcoderSubmissionsURL    = "https://kenkoooo.com/atcoder/atcoder-api/results?user="
 )
 
-var atcoderContestProblemMap map[string][]Problem
-
 type atcoderProblem struct {
 	ProblemID            string      `json:"id"`
 	ContestID            string      `json:"contest_id"`
@@ -68,143 +67,163 @@ type atcoderDifficulty struct {
 	IsExperimental   bool    `json:"is_experimental"`
 }
 
-func updateAtcoderProblems(db *gorm.DB) error {
-	log.Println("Start updating AtCoder problem info")
-	var problems []atcoderProblem
+type atcoderJudge struct{}
+
+func init() {
+	RegisterJudge(&atcoderJudge{})
+}
+
+func (j *atcoderJudge) Domain() string {
+	return atcoderDomain
+}
+
+func (j *atcoderJudge) FetchProblems() ([]Problem, error) {
+	var ret []atcoderProblem
 	{
 		body, err := fetchAPI(atcoderProblemsURL)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if err := json.Unmarshal(body, &problems); err != nil {
-			return err
+		if err := json.Unmarshal(body, &ret); err != nil {
+			return nil, err
 		}
 	}
 	var difficulties map[string]atcoderDifficulty
 	{
 		body, err := fetchAPI(atcoderDifficultyURL)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if err := json.Unmarshal(body, &difficulties); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	for _, v := range problems {
-		var problem Problem
-		difficulty := "-"
-		if d, ok := difficulties[v.ProblemID]; ok {
-			if d.Difficulty == 0 {
-				difficulty = "-"
-			} else if d.Difficulty >= 400 {
-				difficulty = strconv.Itoa(int(d.Difficulty))
-			} else {
-				difficulty = strconv.Itoa(int(400 / math.Exp(1.0-d.Difficulty/400)))
-			}
+	var problems []Problem
+	for _, v := range ret {
+		problem := Problem{
+			Domain:     atcoderDomain,
+			ProblemID:  v.ProblemID,
+			ContestID:  v.ContestID,
+			Title:      v.Title,
+			Difficulty: "-",
 		}
-		if err := db.
-			Where(Problem{
-				Domain:    atcoderDomain,
-				ProblemID: v.ProblemID,
-			}).
-			Assign(Problem{
-				Domain:     atcoderDomain,
-				ProblemID:  v.ProblemID,
-				ContestID:  v.ContestID,
-				Title:      v.Title,
-				Difficulty: difficulty,
-			}).
-			FirstOrCreate(&problem).Error; err != nil {
-			return err
+		if d, ok := difficulties[v.ProblemID]; ok && d.Difficulty != 0 {
+			difficulty := d.Difficulty
+			if difficulty < 400 {
+				difficulty = 400 / math.Exp(1.0-difficulty/400)
+			}
+			problem.Difficulty = strconv.Itoa(int(difficulty))
+			setDifficulty(&problem, DifficultyAtCoderIRT, difficulty)
 		}
-		atcoderContestProblemMap[v.ContestID] = append(atcoderContestProblemMap[v.ContestID], problem)
+		problems = append(problems, problem)
 	}
 
-	return nil
+	return problems, nil
 }
 
-func fetchAtcoderContestProblem(db *gorm.DB) error {
-	log.Println("Start fetching AtCoder contest-problem pair")
-	body, err := fetchAPI(atcoderContestProblemURL)
-	if err != nil {
-		return err
+func (j *atcoderJudge) FetchContests(problems []Problem) ([]Contest, error) {
+	problemMap := make(map[string]Problem)
+	contestProblemMap := make(map[string][]Problem)
+	for _, v := range problems {
+		problemMap[v.ProblemID] = v
+		contestProblemMap[v.ContestID] = append(contestProblemMap[v.ContestID], v)
 	}
+
+	// 複数のコンテストで出題された問題や、merged-problemsにない問題を含むコンテストがある
 	var pairs []atcoderContestProblem
-	if err := json.Unmarshal(body, &pairs); err != nil {
-		return err
+	{
+		body, err := fetchAPI(atcoderContestProblemURL)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(body, &pairs); err != nil {
+			return nil, err
+		}
 	}
 	for _, v := range pairs {
-		var problem Problem
-		if err := db.
-			Where(Problem{
-				Domain:    atcoderDomain,
-				ProblemID: v.ProblemID,
-			}).
-			Take(&problem).Error; err != nil {
-			return err
+		problem, ok := problemMap[v.ProblemID]
+		if !ok {
+			log.Printf("Unknown AtCoder problem. contestID: %s, problemID: %s", v.ContestID, v.ProblemID)
+			continue
 		}
 		if v.ContestID != problem.ContestID {
-			atcoderContestProblemMap[v.ContestID] = append(atcoderContestProblemMap[v.ContestID], problem)
+			contestProblemMap[v.ContestID] = append(contestProblemMap[v.ContestID], problem)
 		}
 	}
-	for _, v := range atcoderContestProblemMap {
+	for _, v := range contestProblemMap {
 		sort.Slice(v, func(i, j int) bool { return v[i].ProblemID < v[j].ProblemID })
 	}
-	return nil
-}
 
-func updateAtcoderContests(db *gorm.DB) error {
-	log.Println("Start updating AtCoder contest info")
-	body, err := fetchAPI(atcoderContestsURL)
-	if err != nil {
-		return err
-	}
-	var contests []atcoderContest
-	if err := json.Unmarshal(body, &contests); err != nil {
-		return err
+	var ret []atcoderContest
+	{
+		body, err := fetchAPI(atcoderContestsURL)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(body, &ret); err != nil {
+			return nil, err
+		}
 	}
 
-	for _, v := range contests {
+	var contests []Contest
+	for _, v := range ret {
 		var problemNoList []int64
-		for _, problem := range atcoderContestProblemMap[v.ContestID] {
+		for _, problem := range contestProblemMap[v.ContestID] {
 			problemNoList = append(problemNoList, int64(problem.No))
 		}
-		if len(problemNoList) == 0 {
+		// 終了前のコンテストは問題が公開されていないので、問題がなくても保存する
+		status := contestStatus(v.StartEpochSecond, v.DurationSecond)
+		if len(problemNoList) == 0 && status == ContestFinished {
 			continue
 		}
-		if err := db.
-			Where(Contest{
-				Domain:    atcoderDomain,
-				ContestID: v.ContestID,
-			}).
-			Assign(Contest{
-				Domain:           atcoderDomain,
-				ContestID:        v.ContestID,
-				Title:            v.Title,
-				StartTimeSeconds: v.StartEpochSecond,
-				DurationSeconds:  v.DurationSecond,
-				Rated:            v.RateChange,
-				ProblemNoList:    problemNoList,
-			}).
-			FirstOrCreate(&Contest{}).Error; err != nil {
-			return err
-		}
+		contests = append(contests, Contest{
+			Domain:           atcoderDomain,
+			ContestID:        v.ContestID,
+			Title:            v.Title,
+			StartTimeSeconds: v.StartEpochSecond,
+			DurationSeconds:  v.DurationSecond,
+			Rated:            v.RateChange,
+			ProblemNoList:    problemNoList,
+			Status:           status,
+		})
 	}
 
-	return nil
+	return contests, nil
 }
 
-func updateAtcoder(db *gorm.DB) error {
-	atcoderContestProblemMap = make(map[string][]Problem)
-	if err := updateAtcoderProblems(db); err != nil {
-		return err
+type atcoderSubmission struct {
+	ID            int     `json:"id"`
+	EpochSecond   int     `json:"epoch_second"`
+	ProblemID     string  `json:"problem_id"`
+	ContestID     string  `json:"contest_id"`
+	UserID        string  `json:"user_id"`
+	Language      string  `json:"language"`
+	Point         float64 `json:"point"`
+	Length        int     `json:"length"`
+	Result        string  `json:"result"`
+	ExecutionTime int     `json:"execution_time"`
+}
+
+func fetchAtcoderSubmissions(account string) ([]userSubmission, error) {
+	body, err := fetchAPI(atcoderSubmissionsURL + url.QueryEscape(account))
+	if err != nil {
+		return nil, err
 	}
-	if err := fetchAtcoderContestProblem(db); err != nil {
-		return err
+	var ret []atcoderSubmission
+	if err := json.Unmarshal(body, &ret); err != nil {
+		return nil, err
 	}
-	if err := updateAtcoderContests(db); err != nil {
-		return err
+
+	var submissions []userSubmission
+	for _, v := range ret {
+		submissions = append(submissions, userSubmission{
+			SubmissionID: strconv.Itoa(v.ID),
+			ProblemID:    v.ProblemID,
+			ContestID:    v.ContestID,
+			Result:       v.Result,
+			EpochSecond:  v.EpochSecond,
+		})
 	}
-	return nil
+	return submissions, nil
 }
@@ -1,9 +1,19 @@
 package main
 
 import (
+	"os"
+
 	"github.com/tsushiy/codernote-backend/crawler"
 )
 
+// 引数にドメインを指定するとそのジャッジだけをクロールする
+// example: go run cmd/main.go atcoder codeforces
 func main() {
-	crawler.CrawlAll(nil, crawler.PubSubMessage{})
+	if len(os.Args) < 2 {
+		crawler.CrawlAll(nil, crawler.PubSubMessage{})
+		return
+	}
+	for _, domain := range os.Args[1:] {
+		crawler.CrawlJudge(nil, crawler.PubSubMessage{Data: []byte(domain)})
+	}
 }
@@ -3,23 +3,26 @@ package crawler
 import (
 	"encoding/json"
 	"log"
+	"net/url"
 	"regexp"
 	"sort"
 	"strconv"
 	"time"
 
-	"github.com/jinzhu/gorm"
 	. "github.com/tsushiy/codernote-backend/db"
 )
 
 const (
-	codeforcesDomain      = "codeforces"
-	codeforcesProblemsURL = "https://codeforces.com/api/problemset.problems"
-	codeforcesContestsURL = "https://codeforces.com/api/contest.list?gym=false"
-)
+	codeforcesDomain        = "codeforces"
+	codeforcesProblemsURL   = "https://codeforces.com/api/problemset.problems"
+	codeforcesContestsURL   = "https://codeforces.com/api/contest.list?gym=false"
+	codeforcesUserStatusURL = "https://codeforces.com/api/user.status?handle="
 
-var codeforcesProblems codeforcesProblem
-var codeforcesContestProblemMap map[string][]Problem
+	codeforcesGymDomain      = "codeforces-gym"
+	codeforcesGymContestsURL = "https://codeforces.com/api/contest.list?gym=true"
+	// Cloud Functionsのタイムアウトに収まるように、1回のクロールで取得するコンテスト数を制限する
+	codeforcesGymMaxContests = 300
+)
 
 type codeforcesProblem struct {
 	Status string `json:"status"`
@@ -124,139 +127,229 @@ type codeforcesContestType struct {
 	RelativeTimeSeconds int    `json:"relativeTimeSeconds"`
 }
 
-func updateCodeforcesProblems(db *gorm.DB) error {
-	log.Println("Start updating codeforces problem info")
+type codeforcesJudge struct {
+	problems codeforcesProblem
+}
+
+func init() {
+	RegisterJudge(&codeforcesJudge{})
+}
+
+func (j *codeforcesJudge) Domain() string {
+	return codeforcesDomain
+}
+
+func (j *codeforcesJudge) FetchProblems() ([]Problem, error) {
+	j.problems = codeforcesProblem{}
 	body, err := fetchAPI(codeforcesProblemsURL)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if err := json.Unmarshal(body, &codeforcesProblems); err != nil {
-		return err
+	if err := json.Unmarshal(body, &j.problems); err != nil {
+		return nil, err
 	}
-	problems := codeforcesProblems
 
-	for _, v := range problems.Result.Problems {
-		var problem Problem
-		contestID := strconv.Itoa(v.ContestID)
-		difficulty := "-"
-		if v.Rating != 0 {
-			difficulty = strconv.Itoa(v.Rating)
+	var problems []Problem
+	for _, v := range j.problems.Result.Problems {
+		problem := Problem{
+			Domain:     codeforcesDomain,
+			ProblemID:  v.Index,
+			ContestID:  strconv.Itoa(v.ContestID),
+			Title:      v.Name,
+			Difficulty: "-",
 		}
-		if err := db.
-			Where(Problem{
-				Domain:    codeforcesDomain,
-				ProblemID: v.Index,
-				ContestID: contestID,
-			}).
-			Assign(Problem{
-				Domain:     codeforcesDomain,
-				ProblemID:  v.Index,
-				ContestID:  contestID,
-				Title:      v.Name,
-				Difficulty: difficulty,
-			}).
-			FirstOrCreate(&problem).Error; err != nil {
-			return err
+		if v.Rating != 0 {
+			problem.Difficulty = strconv.Itoa(v.Rating)
+			setDifficulty(&problem, DifficultyCodeforcesRating, float64(v.Rating))
 		}
-		codeforcesContestProblemMap[contestID] = append(codeforcesContestProblemMap[contestID], problem)
+		problems = append(problems, problem)
 	}
 
-	for _, v := range codeforcesContestProblemMap {
+	return problems, nil
+}
+
+func (j *codeforcesJudge) FetchContests(problems []Problem) ([]Contest, error) {
+	problemNos := problemNoMap(problems)
+	contestProblemMap := make(map[string][]Problem)
+	for _, v := range problems {
+		contestProblemMap[v.ContestID] = append(contestProblemMap[v.ContestID], v)
+	}
+	for _, v := range contestProblemMap {
 		sort.Slice(v, func(i, j int) bool { return v[i].ProblemID < v[j].ProblemID })
 	}
 
-	return nil
-}
-
-func updateCodeforcesContests(db *gorm.DB) error {
-	log.Println("Start updating codeforces contest info")
 	body, err := fetchAPI(codeforcesContestsURL)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	var ret codeforcesContest
 	if err := json.Unmarshal(body, &ret); err != nil {
-		return err
+		return nil, err
 	}
-	contests := ret.Result
 
 	var contestMap = make(map[string]codeforcesContestType)
-	for _, v := range contests {
+	for _, v := range ret.Result {
 		contestMap[strconv.Itoa(v.ID)] = v
 	}
 
+	var contests []Contest
 	count := 0
-	for _, v := range contests {
+contestLoop:
+	for _, v := range ret.Result {
+		contestID := strconv.Itoa(v.ID)
+		// 終了前のコンテストは問題が公開されていないので、コンテストの情報だけを保存する
 		if v.Phase != "FINISHED" {
+			status := ContestRunning
+			if v.Phase == "BEFORE" {
+				status = ContestUpcoming
+			}
+			contests = append(contests, Contest{
+				Domain:           codeforcesDomain,
+				ContestID:        contestID,
+				Title:            v.Name,
+				StartTimeSeconds: v.StartTimeSeconds,
+				DurationSeconds:  v.DurationSeconds,
+				Rated:            codeforcesRated(v.Name),
+				Status:           status,
+			})
 			continue
 		}
-		contestID := strconv.Itoa(v.ID)
 		var problemNoList []int64
-		for _, problem := range codeforcesContestProblemMap[contestID] {
+		for _, problem := range contestProblemMap[contestID] {
 			problemNoList = append(problemNoList, int64(problem.No))
 		}
 		count++
 		if count%5 == 0 {
 			time.Sleep(1 * time.Second)
 		}
-		problems, err := getProblemsFromContest(contestID)
+		contestProblems, err := getProblemsFromContest(contestID)
 		if err != nil {
 			continue
 		}
-		if len(problems) != len(problemNoList) {
+		// Div.1とDiv.2の同時開催では、もう一方のコンテストの問題として登録されていることがある
+		if len(contestProblems) != len(problemNoList) {
 			var newProblemNoList []int64
-			for _, p1 := range problems {
-				for _, p2 := range codeforcesProblems.Result.Problems {
+			for _, p1 := range contestProblems {
+				for _, p2 := range j.problems.Result.Problems {
 					c1 := contestMap[strconv.Itoa(p1.ContestID)]
 					c2 := contestMap[strconv.Itoa(p2.ContestID)]
 					if p1.Name == p2.Name && c1.StartTimeSeconds == c2.StartTimeSeconds {
-						var problem Problem
-						if err := db.
-							Where(Problem{
-								Domain:    codeforcesDomain,
-								ProblemID: p2.Index,
-								ContestID: strconv.Itoa(p2.ContestID),
-								Title:     p2.Name,
-							}).
-							First(&problem).Error; err != nil {
-							return err
+						problemNo, ok := problemNos[problemKey(codeforcesDomain, strconv.Itoa(p2.ContestID), p2.Index)]
+						// 1つのコンテストのために、ほかのコンテストの更新まで止めない
+						if !ok {
+							log.Printf("Unknown Codeforces problem. contestID: %d, index: %s", p2.ContestID, p2.Index)
+							continue contestLoop
 						}
-						newProblemNoList = append(newProblemNoList, int64(problem.No))
+						newProblemNoList = append(newProblemNoList, int64(problemNo))
 					}
 				}
 			}
 			problemNoList = newProblemNoList[:]
 		}
-		rated := "-"
-		if isDiv1(v.Name) && isDiv2(v.Name) {
-			rated = "12"
-		} else if isDiv1(v.Name) {
-			rated = "1"
-		} else if isDiv2(v.Name) {
-			rated = "2"
-		} else if isDiv3(v.Name) {
-			rated = "3"
+		contests = append(contests, Contest{
+			Domain:           codeforcesDomain,
+			ContestID:        contestID,
+			Title:            v.Name,
+			StartTimeSeconds: v.StartTimeSeconds,
+			DurationSeconds:  v.DurationSeconds,
+			Rated:            codeforcesRated(v.Name),
+			ProblemNoList:    problemNoList,
+			Status:           ContestFinished,
+		})
+	}
+
+	return contests, nil
+}
+
+// Gymの問題はproblemset.problemsに含まれないので、コンテストごとにstandingsから取得する
+type codeforcesGymJudge struct {
+	known    map[string]bool
+	contests []codeforcesContestType
+	problems map[string]codeforcesProblemsType
+}
+
+func init() {
+	RegisterJudge(&codeforcesGymJudge{})
+}
+
+func (j *codeforcesGymJudge) Domain() string {
+	return codeforcesGymDomain
+}
+
+func (j *codeforcesGymJudge) SetKnownContests(contestIDs map[string]bool) {
+	j.known = contestIDs
+}
+
+func (j *codeforcesGymJudge) FetchProblems() ([]Problem, error) {
+	j.contests = nil
+	j.problems = make(map[string]codeforcesProblemsType)
+
+	body, err := fetchAPI(codeforcesGymContestsURL)
+	if err != nil {
+		return nil, err
+	}
+	var ret codeforcesContest
+	if err := json.Unmarshal(body, &ret); err != nil {
+		return nil, err
+	}
+
+	var problems []Problem
+	count := 0
+	for _, v := range ret.Result {
+		if count >= codeforcesGymMaxContests {
+			break
+		}
+		contestID := strconv.Itoa(v.ID)
+		if v.Phase != "FINISHED" || j.known[contestID] {
+			continue
 		}
-		if err := db.
-			Where(Contest{
-				Domain:    codeforcesDomain,
-				ContestID: contestID,
-			}).
-			Assign(Contest{
-				Domain:           codeforcesDomain,
-				ContestID:        contestID,
-				Title:            v.Name,
-				StartTimeSeconds: v.StartTimeSeconds,
-				DurationSeconds:  v.DurationSeconds,
-				Rated:            rated,
-				ProblemNoList:    problemNoList,
-			}).
-			FirstOrCreate(&Contest{}).Error; err != nil {
-			return err
+		count++
+		if count%5 == 0 {
+			time.Sleep(1 * time.Second)
+		}
+		contestProblems, err := getProblemsFromContest(contestID)
+		if err != nil || len(contestProblems) == 0 {
+			continue
+		}
+		j.contests = append(j.contests, v)
+		j.problems[contestID] = contestProblems
+		for _, p := range contestProblems {
+			problems = append(problems, Problem{
+				Domain:     codeforcesGymDomain,
+				ProblemID:  p.Index,
+				ContestID:  contestID,
+				Title:      p.Name,
+				Difficulty: "-",
+			})
 		}
 	}
 
-	return nil
+	return problems, nil
+}
+
+func (j *codeforcesGymJudge) FetchContests(problems []Problem) ([]Contest, error) {
+	problemNos := problemNoMap(problems)
+
+	var contests []Contest
+	for _, v := range j.contests {
+		contestID := strconv.Itoa(v.ID)
+		var problemNoList []int64
+		for _, p := range j.problems[contestID] {
+			problemNoList = append(problemNoList, int64(problemNos[problemKey(codeforcesGymDomain, contestID, p.Index)]))
+		}
+		contests = append(contests, Contest{
+			Domain:           codeforcesGymDomain,
+			ContestID:        contestID,
+			Title:            v.Name,
+			StartTimeSeconds: v.StartTimeSeconds,
+			DurationSeconds:  v.DurationSeconds,
+			Rated:            "-",
+			ProblemNoList:    problemNoList,
+			Status:           ContestFinished,
+		})
+	}
+
+	return contests, nil
 }
 
 func getProblemsFromContest(contestID string) (codeforcesProblemsType, error) {
@@ -279,6 +372,19 @@ func getProblemsFromContest(contestID string) (codeforcesProblemsType, error) {
 	return problems, nil
 }
 
+func codeforcesRated(title string) string {
+	if isDiv1(title) && isDiv2(title) {
+		return "12"
+	} else if isDiv1(title) {
+		return "1"
+	} else if isDiv2(title) {
+		return "2"
+	} else if isDiv3(title) {
+		return "3"
+	}
+	return "-"
+}
+
 func isDiv1(title string) bool {
 	return regexp.MustCompile("Div.( ?)1").MatchString(title)
 }
@@ -291,14 +397,49 @@ func isDiv3(title string) bool {
 	return regexp.MustCompile("Div.( ?)3").MatchString(title)
 }
 
-func updateCodeforces(db *gorm.DB) error {
-	codeforcesProblems = codeforcesProblem{}
-	codeforcesContestProblemMap = make(map[string][]Problem)
-	if err := updateCodeforcesProblems(db); err != nil {
-		return err
+type codeforcesUserStatus struct {
+	Status string `json:"status"`
+	Result []struct {
+		ID                  int    `json:"id"`
+		ContestID           int    `json:"contestId"`
+		CreationTimeSeconds int    `json:"creationTimeSeconds"`
+		RelativeTimeSeconds int    `json:"relativeTimeSeconds"`
+		ProgrammingLanguage string `json:"programmingLanguage"`
+		Verdict             string `json:"verdict"`
+		Problem             struct {
+			ContestID int    `json:"contestId"`
+			Index     string `json:"index"`
+			Name      string `json:"name"`
+		} `json:"problem"`
+	} `json:"result"`
+}
+
+func fetchCodeforcesSubmissions(account string) ([]userSubmission, error) {
+	body, err := fetchAPI(codeforcesUserStatusURL + url.QueryEscape(account))
+	if err != nil {
+		return nil, err
 	}
-	if err := updateCodeforcesContests(db); err != nil {
-		return err
+	var ret codeforcesUserStatus
+	if err := json.Unmarshal(body, &ret); err != nil {
+		return nil, err
+	}
+
+	var submissions []userSubmission
+	for _, v := range ret.Result {
+		if v.Verdict == "" || v.Verdict == "TESTING" {
+			continue
+		}
+		result := v.Verdict
+		if v.Verdict == "OK" {
+			result = resultAccepted
+		}
+		submissions = append(submissions, userSubmission{
+			SubmissionID: strconv.Itoa(v.ID),
+			ProblemID:    v.Problem.Index,
+			ContestID:    strconv.Itoa(v.Problem.ContestID),
+			Result:       result,
+			EpochSecond:  v.CreationTimeSeconds,
+		})
 	}
-	return nil
+	return submissions, nil
 }
@@ -3,6 +3,7 @@ package crawler
 import (
 	"context"
 	"log"
+	"strings"
 
 	_ "github.com/lib/pq"
 	. "github.com/tsushiy/codernote-backend/db"
@@ -17,64 +18,42 @@ func CrawlAll(ctx context.Context, m PubSubMessage) error {
 	defer db.Close()
 	// db.LogMode(true)
 
-	if err := updateAtcoder(db); err != nil {
-		log.Println(err)
-	}
-	if err := updateCodeforces(db); err != nil {
-		log.Println(err)
-	}
-	if err := updateYukicoder(db); err != nil {
-		log.Println(err)
-	}
-	if err := updateAOJ(db); err != nil {
-		log.Println(err)
+	for _, j := range Judges() {
+		if err := updateJudge(db, j); err != nil {
+			log.Println(err)
+		}
 	}
-	if err := updateLeetcode(db); err != nil {
+	if err := updateSubmissions(db); err != nil {
 		log.Println(err)
 	}
 	return nil
 }
 
-func CrawlAtcoder(ctx context.Context, m PubSubMessage) error {
-	db := DbConnect(true)
-	defer db.Close()
-	if err := updateAtcoder(db); err != nil {
-		log.Println(err)
-	}
-	return nil
+// CrawlJudge はメッセージのdataに書かれたドメインのジャッジをクロールする
+// ジャッジごとの関数は作らず、ジャッジを追加したら RegisterJudge で登録するだけでよい
+func CrawlJudge(ctx context.Context, m PubSubMessage) error {
+	return crawlDomain(strings.TrimSpace(string(m.Data)))
 }
 
-func CrawlCodeforces(ctx context.Context, m PubSubMessage) error {
+func CrawlSubmissions(ctx context.Context, m PubSubMessage) error {
 	db := DbConnect(true)
 	defer db.Close()
-	if err := updateCodeforces(db); err != nil {
+	if err := updateSubmissions(db); err != nil {
 		log.Println(err)
 	}
 	return nil
 }
 
-func CrawlYukicoder(ctx context.Context, m PubSubMessage) error {
-	db := DbConnect(true)
-	defer db.Close()
-	if err := updateYukicoder(db); err != nil {
+func crawlDomain(domain string) error {
+	j, err := findJudge(domain)
+	if err != nil {
 		log.Println(err)
+		return nil
 	}
-	return nil
-}
-
-func CrawlAOJ(ctx context.Context, m PubSubMessage) error {
-	db := DbConnect(true)
-	defer db.Close()
-	if err := updateAOJ(db); err != nil {
-		log.Println(err)
-	}
-	return nil
-}
 
-func CrawlLeetcode(ctx context.Context, m PubSubMessage) error {
 	db := DbConnect(true)
 	defer db.Close()
-	if err := updateLeetcode(db); err != nil {
+	if err := updateJudge(db, j); err != nil {
 		log.Println(err)
 	}
 	return nil
@@ -0,0 +1,69 @@
+package crawler
+
+import (
+	"math"
+
+	. "github.com/tsushiy/codernote-backend/db"
+)
+
+// difficultyPoint は各ジャッジの難易度と、それに相当する AtCoder の難易度の組
+type difficultyPoint struct {
+	value    float64
+	estimate float64
+}
+
+// 異なるジャッジの問題を並べて比べられるように、おおよその対応を線形補間で求める
+// 表の範囲外は両端の値にする。AOJ は正解したユーザー数の常用対数で引く
+var difficultyScales = map[string][]difficultyPoint{
+	DifficultyCodeforcesRating: {
+		{800, 200}, {1200, 700}, {1600, 1300}, {2000, 1900}, {2400, 2500}, {3000, 3300}, {3500, 3900},
+	},
+	DifficultyYukicoderLevel: {
+		{1, 100}, {2, 500}, {3, 1200}, {4, 2000}, {5, 2800}, {6, 3600},
+	},
+	DifficultyAOJSolved: {
+		{0, 3600}, {1, 2800}, {2, 2000}, {3, 1200}, {4, 400}, {5, 100},
+	},
+	DifficultyLeetCodeLevel: {
+		{1, 600}, {2, 1400}, {3, 2200},
+	},
+}
+
+func interpolateDifficulty(points []difficultyPoint, value float64) float64 {
+	if value <= points[0].value {
+		return points[0].estimate
+	}
+	for i := 1; i < len(points); i++ {
+		a, b := points[i-1], points[i]
+		if value <= b.value {
+			return a.estimate + (b.estimate-a.estimate)*(value-a.value)/(b.value-a.value)
+		}
+	}
+	return points[len(points)-1].estimate
+}
+
+// 推定できなければ nil を返す
+func estimateDifficulty(kind string, value float64) *float64 {
+	if kind == DifficultyAtCoderIRT {
+		return &value
+	}
+	if kind == DifficultyAOJSolved {
+		if value <= 0 {
+			return nil
+		}
+		value = math.Log10(value)
+	}
+	points, ok := difficultyScales[kind]
+	if !ok {
+		return nil
+	}
+	estimate := interpolateDifficulty(points, value)
+	return &estimate
+}
+
+// setDifficulty は数値の難易度とその種類、推定値を設定する
+func setDifficulty(p *Problem, kind string, value float64) {
+	p.DifficultyValue = &value
+	p.DifficultyKind = kind
+	p.DifficultyEstimate = estimateDifficulty(kind, value)
+}
@@ -7,3 +7,5 @@ require (
 	github.com/lib/pq v1.3.0
 	github.com/tsushiy/codernote-backend v1.1.0
 )
+
+replace github.com/tsushiy/codernote-backend => ../
@@ -0,0 +1,168 @@
+package crawler
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	. "github.com/tsushiy/codernote-backend/db"
+)
+
+// Judge は問題とコンテストを取得する対象のオンラインジャッジ
+// FetchContests には保存済みの問題が渡されるので、ProblemNoList を Problem.No で埋められる
+// 途中で失敗したときは、それまでに取得できたコンテストをエラーと一緒に返してよい
+type Judge interface {
+	Domain() string
+	FetchProblems() ([]Problem, error)
+	FetchContests(problems []Problem) ([]Contest, error)
+}
+
+// 保存済みのコンテストを再取得しないジャッジが実装する
+// updateJudge が FetchProblems の前に保存済みのコンテストIDを渡す
+type knownContestsSetter interface {
+	SetKnownContests(contestIDs map[string]bool)
+}
+
+var judges []Judge
+
+// RegisterJudge はクロール対象のジャッジを登録する
+func RegisterJudge(j Judge) {
+	for _, v := range judges {
+		if v.Domain() == j.Domain() {
+			panic("crawler: RegisterJudge called twice for domain " + j.Domain())
+		}
+	}
+	judges = append(judges, j)
+}
+
+// Judges は登録されているジャッジをドメイン順に返す
+func Judges() []Judge {
+	ret := append([]Judge{}, judges...)
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Domain() < ret[j].Domain() })
+	return ret
+}
+
+func findJudge(domain string) (Judge, error) {
+	for _, v := range judges {
+		if v.Domain() == domain {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("judge %s is not registered", domain)
+}
+
+// Codeforcesは問題IDがコンテスト内でしか一意にならない
+var contestScopedDomains = map[string]bool{
+	codeforcesDomain:    true,
+	codeforcesGymDomain: true,
+}
+
+func problemKey(domain, contestID, problemID string) string {
+	if contestScopedDomains[domain] {
+		return contestID + "/" + problemID
+	}
+	return problemID
+}
+
+func problemNoMap(problems []Problem) map[string]int {
+	ret := make(map[string]int)
+	for _, v := range problems {
+		ret[problemKey(v.Domain, v.ContestID, v.ProblemID)] = v.No
+	}
+	return ret
+}
+
+func contestStatus(startTimeSeconds, durationSeconds int) string {
+	now := int(time.Now().Unix())
+	if now < startTimeSeconds {
+		return ContestUpcoming
+	} else if now < startTimeSeconds+durationSeconds {
+		return ContestRunning
+	}
+	return ContestFinished
+}
+
+func saveProblems(db *gorm.DB, problems []Problem) ([]Problem, error) {
+	var saved []Problem
+	for _, v := range problems {
+		filter := Problem{
+			Domain:    v.Domain,
+			ProblemID: v.ProblemID,
+		}
+		if contestScopedDomains[v.Domain] {
+			filter.ContestID = v.ContestID
+		}
+		// 構造体で Assign するとゼロ値は更新されないので、難易度がなくなった場合に消せるように別に指定する
+		var problem Problem
+		if err := db.
+			Where(filter).
+			Assign(v).
+			Assign(map[string]interface{}{
+				"difficulty_value":    v.DifficultyValue,
+				"difficulty_kind":     v.DifficultyKind,
+				"difficulty_estimate": v.DifficultyEstimate,
+			}).
+			FirstOrCreate(&problem).Error; err != nil {
+			return nil, err
+		}
+		saved = append(saved, problem)
+	}
+	return saved, nil
+}
+
+func saveContests(db *gorm.DB, contests []Contest) error {
+	for _, v := range contests {
+		if err := db.
+			Where(Contest{
+				Domain:    v.Domain,
+				ContestID: v.ContestID,
+			}).
+			Assign(v).
+			Assign(map[string]interface{}{
+				"problem_no_list": v.ProblemNoList,
+			}).
+			FirstOrCreate(&Contest{}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func updateJudge(db *gorm.DB, j Judge) error {
+	if k, ok := j.(knownContestsSetter); ok {
+		var contestIDs []string
+		if err := db.
+			Model(&Contest{}).
+			Where(Contest{
+				Domain: j.Domain(),
+			}).
+			Pluck("contest_id", &contestIDs).Error; err != nil {
+			return err
+		}
+		known := make(map[string]bool)
+		for _, v := range contestIDs {
+			known[v] = true
+		}
+		k.SetKnownContests(known)
+	}
+
+	log.Printf("Start updating %s problem info", j.Domain())
+	problems, err := j.FetchProblems()
+	if err != nil {
+		return err
+	}
+	saved, err := saveProblems(db, problems)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Start updating %s contest info", j.Domain())
+	contests, err := j.FetchContests(saved)
+	// 取得に失敗しても、取得できた分は保存する
+	if err := saveContests(db, contests); err != nil {
+		return err
+	}
+	return err
+}
@@ -2,10 +2,8 @@ package crawler
 
 import (
 	"encoding/json"
-	"log"
 	"strconv"
 
-	"github.com/jinzhu/gorm"
 	. "github.com/tsushiy/codernote-backend/db"
 )
 
@@ -14,6 +12,8 @@ const (
 	leetcodeProblemsBaseURL = "https://leetcode.com/api/problems/"
 )
 
+var leetcodeCategories = []string{"algorithms", "database", "shell", "concurrency"}
+
 type leetcodeProblem struct {
 	UserName        string `json:"user_name"`
 	NumSolved       int    `json:"num_solved"`
@@ -48,65 +48,72 @@ type leetcodeProblem struct {
 	CategorySlug  string `json:"category_slug"`
 }
 
-func updateLeetcodeProblem(db *gorm.DB) error {
-	log.Println("Start updating LeetCode contest info")
-	categories := []string{"algorithms", "database", "shell", "concurrency"}
+type leetcodeJudge struct {
+	contestProblemIDs map[string][]string
+}
+
+func init() {
+	RegisterJudge(&leetcodeJudge{})
+}
+
+func (j *leetcodeJudge) Domain() string {
+	return leetcodeDomain
+}
+
+// LeetCodeにはコンテストがないので、カテゴリをコンテストとして扱う
+func (j *leetcodeJudge) FetchProblems() ([]Problem, error) {
+	j.contestProblemIDs = make(map[string][]string)
 
-	for _, category := range categories {
+	var problems []Problem
+	for _, category := range leetcodeCategories {
 		url := leetcodeProblemsBaseURL + category
 		body, err := fetchAPI(url)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		var ret leetcodeProblem
 		if err := json.Unmarshal(body, &ret); err != nil {
-			return err
+			return nil, err
 		}
-		var problemNoList []int64
 		for _, p := range ret.StatStatusPairs {
-			var problem Problem
-			if err := db.
-				Where(Problem{
-					Domain:    leetcodeDomain,
-					ProblemID: strconv.Itoa(p.Stat.QuestionID),
-				}).
-				Assign(Problem{
-					Domain:     leetcodeDomain,
-					ProblemID:  strconv.Itoa(p.Stat.QuestionID),
-					ContestID:  category,
-					Title:      p.Stat.QuestionTitle,
-					Slug:       p.Stat.QuestionTitleSlug,
-					FrontendID: strconv.Itoa(p.Stat.FrontendQuestionID),
-					Difficulty: strconv.Itoa(p.Difficulty.Level),
-				}).
-				FirstOrCreate(&problem).Error; err != nil {
-				return err
+			problemID := strconv.Itoa(p.Stat.QuestionID)
+			problem := Problem{
+				Domain:     leetcodeDomain,
+				ProblemID:  problemID,
+				ContestID:  category,
+				Title:      p.Stat.QuestionTitle,
+				Slug:       p.Stat.QuestionTitleSlug,
+				FrontendID: strconv.Itoa(p.Stat.FrontendQuestionID),
+				Difficulty: strconv.Itoa(p.Difficulty.Level),
 			}
-			problemNoList = append(problemNoList, int64(problem.No))
-		}
-
-		if err := db.
-			Where(Contest{
-				Domain:    leetcodeDomain,
-				ContestID: category,
-			}).
-			Assign(Contest{
-				Domain:        leetcodeDomain,
-				ContestID:     category,
-				Title:         category,
-				ProblemNoList: problemNoList,
-			}).
-			FirstOrCreate(&Contest{}).Error; err != nil {
-			return err
+			if p.Difficulty.Level != 0 {
+				setDifficulty(&problem, DifficultyLeetCodeLevel, float64(p.Difficulty.Level))
+			}
+			problems = append(problems, problem)
+			j.contestProblemIDs[category] = append(j.contestProblemIDs[category], problemID)
 		}
 	}
 
-	return nil
+	return problems, nil
 }
 
-func updateLeetcode(db *gorm.DB) error {
-	if err := updateLeetcodeProblem(db); err != nil {
-		return err
+func (j *leetcodeJudge) FetchContests(problems []Problem) ([]Contest, error) {
+	problemNos := problemNoMap(problems)
+
+	var contests []Contest
+	for _, category := range leetcodeCategories {
+		var problemNoList []int64
+		for _, id := range j.contestProblemIDs[category] {
+			problemNoList = append(problemNoList, int64(problemNos[id]))
+		}
+		contests = append(contests, Contest{
+			Domain:        leetcodeDomain,
+			ContestID:     category,
+			Title:         category,
+			ProblemNoList: problemNoList,
+			Status:        ContestFinished,
+		})
 	}
-	return nil
+
+	return contests, nil
 }
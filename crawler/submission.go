@@ -0,0 +1,144 @@
+package crawler
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	. "github.com/tsushiy/codernote-backend/db"
+)
+
+const resultAccepted = "AC"
+
+type userSubmission struct {
+	SubmissionID string
+	ProblemID    string
+	ContestID    string
+	Result       string
+	EpochSecond  int
+}
+
+type submissionSource struct {
+	domain  string
+	account func(UserDetail) string
+	fetch   func(account string) ([]userSubmission, error)
+}
+
+var submissionSources = []submissionSource{
+	{
+		domain:  atcoderDomain,
+		account: func(d UserDetail) string { return d.AtCoderID },
+		fetch:   fetchAtcoderSubmissions,
+	},
+	{
+		domain:  codeforcesDomain,
+		account: func(d UserDetail) string { return d.CodeforcesID },
+		fetch:   fetchCodeforcesSubmissions,
+	},
+	{
+		domain:  yukicoderDomain,
+		account: func(d UserDetail) string { return d.YukicoderID },
+		fetch:   fetchYukicoderSubmissions,
+	},
+	{
+		domain:  aojDomain,
+		account: func(d UserDetail) string { return d.AOJID },
+		fetch:   fetchAOJSubmissions,
+	},
+}
+
+func fetchProblemNoMap(db *gorm.DB, domain string) (map[string]int, error) {
+	var problems []Problem
+	if err := db.
+		Where(Problem{
+			Domain: domain,
+		}).
+		Find(&problems).Error; err != nil {
+		return nil, err
+	}
+	return problemNoMap(problems), nil
+}
+
+func updateUserSubmissions(db *gorm.DB, src submissionSource, uid, account string, problemNos map[string]int) error {
+	// 連携しているIDが変更された場合は古いIDの提出を削除する
+	if err := db.
+		Where("user_id = ? and domain = ? and account <> ?", uid, src.domain, account).
+		Delete(&Submission{}).Error; err != nil {
+		return err
+	}
+	if account == "" {
+		return nil
+	}
+
+	submissions, err := src.fetch(account)
+	if err != nil {
+		return err
+	}
+
+	var existing []Submission
+	if err := db.
+		Where(Submission{
+			UserID: uid,
+			Domain: src.domain,
+		}).
+		Find(&existing).Error; err != nil {
+		return err
+	}
+	known := make(map[string]bool)
+	for _, v := range existing {
+		known[fmt.Sprintf("%s/%d", v.SubmissionID, v.ProblemNo)] = true
+	}
+
+	for _, v := range submissions {
+		problemNo, ok := problemNos[problemKey(src.domain, v.ContestID, v.ProblemID)]
+		if !ok {
+			continue
+		}
+		key := fmt.Sprintf("%s/%d", v.SubmissionID, problemNo)
+		if known[key] {
+			continue
+		}
+		if err := db.
+			Create(&Submission{
+				UserID:       uid,
+				Domain:       src.domain,
+				Account:      account,
+				SubmissionID: v.SubmissionID,
+				ProblemNo:    problemNo,
+				Result:       v.Result,
+				EpochSecond:  v.EpochSecond,
+			}).Error; err != nil {
+			return err
+		}
+		known[key] = true
+	}
+
+	return nil
+}
+
+func updateSubmissions(db *gorm.DB) error {
+	log.Println("Start updating user submissions")
+	var details []UserDetail
+	if err := db.Find(&details).Error; err != nil {
+		return err
+	}
+
+	for _, src := range submissionSources {
+		problemNos, err := fetchProblemNoMap(db, src.domain)
+		if err != nil {
+			return err
+		}
+		for _, d := range details {
+			account := src.account(d)
+			if err := updateUserSubmissions(db, src, d.UserID, account, problemNos); err != nil {
+				log.Printf("Cannot update submissions. domain: %s, account: %s, error: %v", src.domain, account, err)
+			}
+			if account != "" {
+				time.Sleep(1 * time.Second)
+			}
+		}
+	}
+
+	return nil
+}
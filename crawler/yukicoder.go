@@ -2,18 +2,18 @@ package crawler
 
 import (
 	"encoding/json"
-	"log"
+	"net/url"
 	"strconv"
 	"time"
 
-	"github.com/jinzhu/gorm"
 	. "github.com/tsushiy/codernote-backend/db"
 )
 
 const (
 	yukicoderDomain      = "yukicoder"
 	yukicoderProblemsURL = "https://yukicoder.me/api/v1/problems"
-	yukicoderContestsURL = "https://yukicoder.me/api/v1/contest/past"
+	yukicoderContestsURL = "https://yukicoder.me/api/v1/contest/"
+	yukicoderSolvedURL   = "https://yukicoder.me/api/v1/solved/name/"
 )
 
 type yukicoderProblem struct {
@@ -36,98 +36,112 @@ type yukicoderContest struct {
 	ProblemIDList []int     `json:"ProblemIdList"`
 }
 
-var yukicoderProblemNoMap map[string]int
+type yukicoderJudge struct {
+	contests []yukicoderContest
+}
 
-func updateYukicoderProblems(db *gorm.DB) error {
-	log.Println("Start updating yukicoder problem info")
-	body, err := fetchAPI(yukicoderProblemsURL)
-	if err != nil {
-		return err
+func init() {
+	RegisterJudge(&yukicoderJudge{})
+}
+
+func (j *yukicoderJudge) Domain() string {
+	return yukicoderDomain
+}
+
+func (j *yukicoderJudge) FetchProblems() ([]Problem, error) {
+	var ret []yukicoderProblem
+	{
+		body, err := fetchAPI(yukicoderProblemsURL)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(body, &ret); err != nil {
+			return nil, err
+		}
 	}
-	var problems []yukicoderProblem
-	if err := json.Unmarshal(body, &problems); err != nil {
-		return err
+
+	// 問題のAPIからはコンテストがわからないので、コンテストのAPIから引く
+	j.contests = nil
+	for _, v := range []string{"past", "current", "future"} {
+		body, err := fetchAPI(yukicoderContestsURL + v)
+		if err != nil {
+			return nil, err
+		}
+		var ret []yukicoderContest
+		if err := json.Unmarshal(body, &ret); err != nil {
+			return nil, err
+		}
+		j.contests = append(j.contests, ret...)
+	}
+	contestIDMap := make(map[int]string)
+	for _, v := range j.contests {
+		for _, id := range v.ProblemIDList {
+			contestIDMap[id] = strconv.Itoa(v.ID)
+		}
 	}
 
-	for _, v := range problems {
-		var problem Problem
-		problemID := strconv.Itoa(v.ProblemID)
-		if err := db.
-			Where(Problem{
-				Domain:    yukicoderDomain,
-				ProblemID: problemID,
-			}).
-			Assign(Problem{
-				Domain:     yukicoderDomain,
-				ProblemID:  problemID,
-				Title:      v.Title,
-				FrontendID: strconv.Itoa(v.No),
-				Difficulty: strconv.FormatFloat(v.Level, 'f', -1, 64),
-			}).
-			FirstOrCreate(&problem).Error; err != nil {
-			return err
+	var problems []Problem
+	for _, v := range ret {
+		problem := Problem{
+			Domain:     yukicoderDomain,
+			ProblemID:  strconv.Itoa(v.ProblemID),
+			ContestID:  contestIDMap[v.ProblemID],
+			Title:      v.Title,
+			FrontendID: strconv.Itoa(v.No),
+			Difficulty: strconv.FormatFloat(v.Level, 'f', -1, 64),
 		}
-		yukicoderProblemNoMap[problemID] = problem.No
+		setDifficulty(&problem, DifficultyYukicoderLevel, v.Level)
+		problems = append(problems, problem)
 	}
 
-	return nil
+	return problems, nil
 }
 
-func updateYukicoderContests(db *gorm.DB) error {
-	log.Println("Start updating yukicoder contest info")
-	body, err := fetchAPI(yukicoderContestsURL)
-	if err != nil {
-		return err
-	}
-	var contests []yukicoderContest
-	if err := json.Unmarshal(body, &contests); err != nil {
-		return err
-	}
+func (j *yukicoderJudge) FetchContests(problems []Problem) ([]Contest, error) {
+	problemNos := problemNoMap(problems)
 
-	for _, v := range contests {
+	var contests []Contest
+	for _, v := range j.contests {
+		// 開催前のコンテストの問題はまだ問題一覧に含まれていない
 		var problemNoList []int64
 		for _, id := range v.ProblemIDList {
-			problemNo := yukicoderProblemNoMap[strconv.Itoa(id)]
-			problemNoList = append(problemNoList, int64(problemNo))
-			if err := db.
-				Where(Problem{
-					No: problemNo,
-				}).
-				Assign(Problem{
-					ContestID: strconv.Itoa(v.ID),
-				}).
-				FirstOrCreate(&Problem{}).Error; err != nil {
-				return err
+			if problemNo, ok := problemNos[strconv.Itoa(id)]; ok {
+				problemNoList = append(problemNoList, int64(problemNo))
 			}
 		}
-		if err := db.
-			Where(Contest{
-				Domain:    yukicoderDomain,
-				ContestID: strconv.Itoa(v.ID),
-			}).
-			Assign(Contest{
-				Domain:           yukicoderDomain,
-				ContestID:        strconv.Itoa(v.ID),
-				Title:            v.Name,
-				StartTimeSeconds: int(v.Date.Unix()),
-				DurationSeconds:  int(v.EndDate.Unix()) - int(v.Date.Unix()),
-				ProblemNoList:    problemNoList,
-			}).
-			FirstOrCreate(&Contest{}).Error; err != nil {
-			return err
-		}
+		startTimeSeconds := int(v.Date.Unix())
+		durationSeconds := int(v.EndDate.Unix()) - int(v.Date.Unix())
+		contests = append(contests, Contest{
+			Domain:           yukicoderDomain,
+			ContestID:        strconv.Itoa(v.ID),
+			Title:            v.Name,
+			StartTimeSeconds: startTimeSeconds,
+			DurationSeconds:  durationSeconds,
+			ProblemNoList:    problemNoList,
+			Status:           contestStatus(startTimeSeconds, durationSeconds),
+		})
 	}
 
-	return nil
+	return contests, nil
 }
 
-func updateYukicoder(db *gorm.DB) error {
-	yukicoderProblemNoMap = make(map[string]int)
-	if err := updateYukicoderProblems(db); err != nil {
-		return err
+// solved APIは提出単位ではなく解いた問題の一覧しか返さない
+func fetchYukicoderSubmissions(account string) ([]userSubmission, error) {
+	body, err := fetchAPI(yukicoderSolvedURL + url.PathEscape(account))
+	if err != nil {
+		return nil, err
 	}
-	if err := updateYukicoderContests(db); err != nil {
-		return err
+	var ret []yukicoderProblem
+	if err := json.Unmarshal(body, &ret); err != nil {
+		return nil, err
+	}
+
+	var submissions []userSubmission
+	for _, v := range ret {
+		submissions = append(submissions, userSubmission{
+			ProblemID: strconv.Itoa(v.ProblemID),
+			Result:    resultAccepted,
+		})
 	}
-	return nil
+	return submissions, nil
 }
@@ -18,6 +18,16 @@ type User struct {
 	UpdatedAt time.Time
 }
 
+type AccessToken struct {
+	No         int    `gorm:"primary_key"`
+	UserID     string `gorm:"index;not null" json:"-"`
+	Name       string
+	Hash       string `gorm:"unique;not null" json:"-"`
+	Scope      string
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
 type UserDetail struct {
 	UserID       string `gorm:"primary_key"`
 	AtCoderID    string
@@ -25,8 +35,16 @@ type UserDetail struct {
 	YukicoderID  string
 	AOJID        string
 	LeetCodeID   string
+	// プロフィールで各ジャッジのIDを公開するか
+	PublicJudgeIDs bool `gorm:"not null;default:false"`
 }
 
+const (
+	ContestUpcoming = "upcoming"
+	ContestRunning  = "running"
+	ContestFinished = "finished"
+)
+
 type Contest struct {
 	No               int `gorm:"primary_key"`
 	Domain           string
@@ -36,8 +54,23 @@ type Contest struct {
 	DurationSeconds  int
 	Rated            string
 	ProblemNoList    pq.Int64Array `gorm:"type:integer[]"`
+	Status           string
 }
 
+// Problem.DifficultyKind の値
+const (
+	// AtCoder Problems の推定難易度 (IRT) を、400未満を正の値にクリップしたもの
+	DifficultyAtCoderIRT = "atcoder-irt"
+	// Codeforces の問題のレーティング
+	DifficultyCodeforcesRating = "codeforces-rating"
+	// yukicoder の星の数 (0.5刻み)
+	DifficultyYukicoderLevel = "yukicoder-level"
+	// AOJ の正解したユーザー数。多いほど易しい
+	DifficultyAOJSolved = "aoj-solved"
+	// LeetCode の Easy, Medium, Hard を 1, 2, 3 にしたもの
+	DifficultyLeetCodeLevel = "leetcode-level"
+)
+
 type Problem struct {
 	No         int `gorm:"primary_key"`
 	Domain     string
@@ -46,7 +79,27 @@ type Problem struct {
 	Title      string
 	Slug       string `json:"Slug,omitempty"`
 	FrontendID string `json:"FrontendID,omitempty"`
+	// 各ジャッジでの表記のままの難易度。不明なら "-"
 	Difficulty string
+	// DifficultyValue は Difficulty を数値にしたもので、DifficultyKind によって意味が異なる
+	// DifficultyEstimate は AtCoder の難易度 (クリップ済み) の尺度に揃えた推定値
+	// どちらも不明なら nil で、そのとき DifficultyKind は空
+	DifficultyValue    *float64
+	DifficultyKind     string `gorm:"not null;default:''"`
+	DifficultyEstimate *float64
+	// DBには保存せず、読み込んだときに CanonicalURL で設定する
+	URL string `gorm:"-"`
+}
+
+type Submission struct {
+	No           int    `gorm:"primary_key" json:"-"`
+	UserID       string `gorm:"index" json:"-"`
+	Domain       string
+	Account      string
+	SubmissionID string
+	ProblemNo    int `gorm:"index"`
+	Result       string
+	EpochSecond  int
 }
 
 type Note struct {
@@ -59,6 +112,44 @@ type Note struct {
 	UserNo    int     `json:"-"`
 	User      User    `gorm:"foreignkey:UserNo"`
 	Public    int     `gorm:"default:1"`
+	StarCount int     `gorm:"not null;default:0"`
+	// 作者がコメントを受け付けないようにしているか
+	CommentsDisabled bool `gorm:"not null;default:false"`
+}
+
+type NoteRevision struct {
+	No        int    `gorm:"primary_key"`
+	NoteID    string `gorm:"index"`
+	Text      string
+	Public    int
+	CreatedAt time.Time
+}
+
+type Follow struct {
+	No         int `gorm:"primary_key" json:"-"`
+	FollowerNo int `gorm:"index"`
+	FolloweeNo int `gorm:"index"`
+	CreatedAt  time.Time
+}
+
+type Star struct {
+	No        int    `gorm:"primary_key" json:"-"`
+	UserNo    int    `gorm:"index"`
+	NoteID    string `gorm:"index"`
+	CreatedAt time.Time
+}
+
+// ParentNo は返信先のコメントで、ノートへのコメントなら nil
+// Replies はDBには保存せず、返信をツリーにして返すときに使う
+type NoteComment struct {
+	No        int    `gorm:"primary_key"`
+	NoteID    string `gorm:"index"`
+	ParentNo  *int   `gorm:"index"`
+	UserNo    int    `json:"-"`
+	User      User   `gorm:"foreignkey:UserNo"`
+	Text      string
+	CreatedAt time.Time
+	Replies   []NoteComment `gorm:"-"`
 }
 
 type Tag struct {
@@ -92,7 +183,14 @@ func DbConnect(migrate bool) *gorm.DB {
 		}
 
 		if migrate {
-			db.AutoMigrate(&User{}, &UserDetail{}, &Contest{}, &Problem{}, &Note{}, &Tag{}, &TagMap{})
+			applied, err := MigrateUp(db)
+			for _, v := range applied {
+				log.Printf("Applied migration %d_%s", v.Version, v.Name)
+			}
+			// 途中で失敗すると以降のマイグレーションが適用されず、存在しない列を参照することになるので起動しない
+			if err != nil {
+				log.Fatalf("Failed to migrate: %v", err)
+			}
 		}
 		return db
 	}
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// 複数のクローラーやコマンドが同時にマイグレーションしないようにするためのロックのキー
+const migrationLockKey = 727170
+
+type Migration struct {
+	Version int
+	Name    string
+	// nil でなければ Up の前に同じトランザクションで実行し、エラーなら適用しない
+	Check func(tx *gorm.DB) error
+	Up    []string
+	Down  []string
+}
+
+type SchemaMigration struct {
+	Version   int `gorm:"primary_key;auto_increment:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+type MigrationStatus struct {
+	Migration
+	// 未適用ならnil
+	AppliedAt *time.Time
+}
+
+func ensureSchemaMigrations(db *gorm.DB) error {
+	return db.Exec(`create table if not exists schema_migrations (
+		version integer primary key,
+		name text not null,
+		applied_at timestamp with time zone not null
+	)`).Error
+}
+
+func appliedMigrations(db *gorm.DB) (map[int]SchemaMigration, error) {
+	if err := ensureSchemaMigrations(db); err != nil {
+		return nil, err
+	}
+	var applied []SchemaMigration
+	if err := db.Find(&applied).Error; err != nil {
+		return nil, err
+	}
+	ret := make(map[int]SchemaMigration)
+	for _, v := range applied {
+		ret[v.Version] = v
+	}
+	return ret, nil
+}
+
+// MigrateUp は未適用のマイグレーションをすべて適用し、適用したものを返す
+func MigrateUp(db *gorm.DB) ([]Migration, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		ok, err := runMigration(db, m, true)
+		if err != nil {
+			return done, fmt.Errorf("migration %d_%s failed: %v", m.Version, m.Name, err)
+		}
+		if ok {
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
+
+// MigrateDown は適用済みのマイグレーションを新しいものから steps 個戻し、戻したものを返す
+func MigrateDown(db *gorm.DB, steps int) ([]Migration, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		ok, err := runMigration(db, m, false)
+		if err != nil {
+			return done, fmt.Errorf("rollback of %d_%s failed: %v", m.Version, m.Name, err)
+		}
+		if ok {
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
+
+// MigrationStatuses はすべてのマイグレーションと適用日時を返す
+func MigrationStatuses(db *gorm.DB) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []MigrationStatus
+	for _, m := range migrations {
+		status := MigrationStatus{Migration: m}
+		if v, ok := applied[m.Version]; ok {
+			t := v.AppliedAt
+			status.AppliedAt = &t
+		}
+		ret = append(ret, status)
+	}
+	return ret, nil
+}
+
+// ロックを取ってから適用済みかどうかを確認し直すので、他のプロセスが先に実行していれば何もせずfalseを返す
+func runMigration(db *gorm.DB, m Migration, up bool) (bool, error) {
+	ran := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("select pg_advisory_xact_lock(?)", migrationLockKey).Error; err != nil {
+			return err
+		}
+
+		count := 0
+		if err := tx.
+			Model(&SchemaMigration{}).
+			Where(SchemaMigration{
+				Version: m.Version,
+			}).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if (count > 0) == up {
+			return nil
+		}
+
+		if up && m.Check != nil {
+			if err := m.Check(tx); err != nil {
+				return err
+			}
+		}
+
+		queries := m.Down
+		if up {
+			queries = m.Up
+		}
+		for _, q := range queries {
+			if err := tx.Exec(q).Error; err != nil {
+				return err
+			}
+		}
+
+		if up {
+			if err := tx.
+				Create(&SchemaMigration{
+					Version:   m.Version,
+					Name:      m.Name,
+					AppliedAt: time.Now(),
+				}).Error; err != nil {
+				return err
+			}
+		} else {
+			if err := tx.
+				Where(SchemaMigration{
+					Version: m.Version,
+				}).
+				Delete(&SchemaMigration{}).Error; err != nil {
+				return err
+			}
+		}
+		ran = true
+		return nil
+	})
+	return ran, err
+}
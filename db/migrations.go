@@ -0,0 +1,326 @@
+package db
+
+// migrations はスキーマの変更履歴で、Version の昇順に並べる
+// 適用済みのマイグレーションは書き換えず、変更は新しいマイグレーションとして追加する
+// 1はAutoMigrateで作られていた既存のDBにもそのまま適用できるように if not exists で作成する
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_initial_tables",
+		Up: []string{
+			`create table if not exists users (
+				no serial primary key,
+				user_id text not null unique,
+				name text not null unique,
+				created_at timestamp with time zone,
+				updated_at timestamp with time zone
+			)`,
+			`create table if not exists user_details (
+				user_id text primary key,
+				at_coder_id text,
+				codeforces_id text,
+				yukicoder_id text,
+				aoj_id text,
+				leet_code_id text
+			)`,
+			`create table if not exists contests (
+				no serial primary key,
+				domain text,
+				contest_id text,
+				title text,
+				start_time_seconds integer,
+				duration_seconds integer,
+				rated text,
+				problem_no_list integer[]
+			)`,
+			`create table if not exists problems (
+				no serial primary key,
+				domain text,
+				problem_id text,
+				contest_id text,
+				title text,
+				slug text,
+				frontend_id text,
+				difficulty text
+			)`,
+			`create table if not exists notes (
+				id text primary key,
+				created_at timestamp with time zone,
+				updated_at timestamp with time zone,
+				text text,
+				problem_no integer,
+				user_no integer,
+				public integer default 1
+			)`,
+			`create table if not exists tags (
+				no serial primary key,
+				key text not null unique
+			)`,
+			`create table if not exists tag_maps (
+				no serial primary key,
+				note_id text,
+				tag_no integer
+			)`,
+		},
+		Down: []string{
+			"drop table if exists tag_maps",
+			"drop table if exists tags",
+			"drop table if exists notes",
+			"drop table if exists problems",
+			"drop table if exists contests",
+			"drop table if exists user_details",
+			"drop table if exists users",
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add_contest_status",
+		Up: []string{
+			"alter table contests add column if not exists status text",
+		},
+		Down: []string{
+			"alter table contests drop column if exists status",
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create_submissions",
+		Up: []string{
+			`create table if not exists submissions (
+				no serial primary key,
+				user_id text,
+				domain text,
+				account text,
+				submission_id text,
+				problem_no integer,
+				result text,
+				epoch_second integer
+			)`,
+			"create index if not exists idx_submissions_user_id on submissions (user_id)",
+			"create index if not exists idx_submissions_problem_no on submissions (problem_no)",
+		},
+		Down: []string{
+			"drop table if exists submissions",
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create_access_tokens",
+		Up: []string{
+			`create table if not exists access_tokens (
+				no serial primary key,
+				user_id text not null,
+				name text,
+				hash text not null unique,
+				scope text,
+				last_used_at timestamp with time zone,
+				created_at timestamp with time zone
+			)`,
+			"create index if not exists idx_access_tokens_user_id on access_tokens (user_id)",
+		},
+		Down: []string{
+			"drop table if exists access_tokens",
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create_note_revisions",
+		Up: []string{
+			`create table if not exists note_revisions (
+				no serial primary key,
+				note_id text,
+				text text,
+				public integer,
+				created_at timestamp with time zone
+			)`,
+			"create index if not exists idx_note_revisions_note_id on note_revisions (note_id)",
+		},
+		Down: []string{
+			"drop table if exists note_revisions",
+		},
+	},
+	{
+		Version: 6,
+		Name:    "create_search_indexes",
+		Up: []string{
+			"create index if not exists notes_text_search_idx on notes using gin (to_tsvector('simple', text))",
+			"create index if not exists problems_title_search_idx on problems using gin (to_tsvector('simple', title))",
+		},
+		Down: []string{
+			"drop index if exists problems_title_search_idx",
+			"drop index if exists notes_text_search_idx",
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_lookup_indexes_and_unique_constraints",
+		Check:   checkRepaired,
+		Up: []string{
+			// クローラーはこの組み合わせで問題とコンテストを更新している
+			"create unique index if not exists problems_domain_contest_id_problem_id_key on problems (domain, contest_id, problem_id)",
+			"create unique index if not exists contests_domain_contest_id_key on contests (domain, contest_id)",
+			"create index if not exists idx_contests_start_time_seconds on contests (start_time_seconds)",
+			"create index if not exists idx_notes_user_no on notes (user_no)",
+			"create index if not exists idx_notes_problem_no on notes (problem_no)",
+			"create index if not exists idx_notes_updated_at on notes (updated_at)",
+			"create index if not exists idx_tag_maps_note_id on tag_maps (note_id)",
+			"create index if not exists idx_tag_maps_tag_no on tag_maps (tag_no)",
+		},
+		Down: []string{
+			"drop index if exists idx_tag_maps_tag_no",
+			"drop index if exists idx_tag_maps_note_id",
+			"drop index if exists idx_notes_updated_at",
+			"drop index if exists idx_notes_problem_no",
+			"drop index if exists idx_notes_user_no",
+			"drop index if exists idx_contests_start_time_seconds",
+			"drop index if exists contests_domain_contest_id_key",
+			"drop index if exists problems_domain_contest_id_problem_id_key",
+		},
+	},
+	{
+		Version: 8,
+		Name:    "add_note_foreign_keys",
+		// 既存のDBに重複や参照先のない行があると失敗するので、残っていれば適用せずに cmd/repair の実行を促す
+		Check: checkRepaired,
+		Up: []string{
+			"create unique index if not exists notes_user_no_problem_no_key on notes (user_no, problem_no)",
+			"create unique index if not exists tag_maps_note_id_tag_no_key on tag_maps (note_id, tag_no)",
+			"alter table notes add constraint notes_user_no_fkey foreign key (user_no) references users (no) on delete cascade",
+			"alter table notes add constraint notes_problem_no_fkey foreign key (problem_no) references problems (no)",
+			"alter table tag_maps add constraint tag_maps_note_id_fkey foreign key (note_id) references notes (id) on delete cascade",
+			"alter table tag_maps add constraint tag_maps_tag_no_fkey foreign key (tag_no) references tags (no) on delete cascade",
+			"alter table note_revisions add constraint note_revisions_note_id_fkey foreign key (note_id) references notes (id) on delete cascade",
+		},
+		Down: []string{
+			"alter table note_revisions drop constraint if exists note_revisions_note_id_fkey",
+			"alter table tag_maps drop constraint if exists tag_maps_tag_no_fkey",
+			"alter table tag_maps drop constraint if exists tag_maps_note_id_fkey",
+			"alter table notes drop constraint if exists notes_problem_no_fkey",
+			"alter table notes drop constraint if exists notes_user_no_fkey",
+			"drop index if exists tag_maps_note_id_tag_no_key",
+			"drop index if exists notes_user_no_problem_no_key",
+		},
+	},
+	{
+		Version: 9,
+		Name:    "add_tag_prefix_index",
+		Up: []string{
+			// GET /tags の前方一致検索用
+			"create index if not exists tags_lower_key_idx on tags (lower(key) text_pattern_ops)",
+		},
+		Down: []string{
+			"drop index if exists tags_lower_key_idx",
+		},
+	},
+	{
+		Version: 10,
+		Name:    "create_follows",
+		Up: []string{
+			`create table if not exists follows (
+				no serial primary key,
+				follower_no integer not null references users (no) on delete cascade,
+				followee_no integer not null references users (no) on delete cascade,
+				created_at timestamp with time zone,
+				unique (follower_no, followee_no)
+			)`,
+			"create index if not exists idx_follows_followee_no on follows (followee_no)",
+		},
+		Down: []string{
+			"drop table if exists follows",
+		},
+	},
+	{
+		Version: 11,
+		Name:    "create_stars",
+		Up: []string{
+			// 並べ替えのためにノートごとの数を notes に持つ
+			"alter table notes add column if not exists star_count integer not null default 0",
+			`create table if not exists stars (
+				no serial primary key,
+				user_no integer not null references users (no) on delete cascade,
+				note_id text not null references notes (id) on delete cascade,
+				created_at timestamp with time zone,
+				unique (user_no, note_id)
+			)`,
+			"create index if not exists idx_stars_note_id on stars (note_id)",
+			"create index if not exists idx_notes_star_count on notes (star_count)",
+		},
+		Down: []string{
+			"drop index if exists idx_notes_star_count",
+			"drop table if exists stars",
+			"alter table notes drop column if exists star_count",
+		},
+	},
+	{
+		Version: 12,
+		Name:    "create_note_comments",
+		Up: []string{
+			"alter table notes add column if not exists comments_disabled boolean not null default false",
+			`create table if not exists note_comments (
+				no serial primary key,
+				note_id text not null references notes (id) on delete cascade,
+				parent_no integer references note_comments (no) on delete cascade,
+				user_no integer not null references users (no) on delete cascade,
+				text text,
+				created_at timestamp with time zone
+			)`,
+			"create index if not exists idx_note_comments_note_id on note_comments (note_id)",
+			"create index if not exists idx_note_comments_parent_no on note_comments (parent_no)",
+		},
+		Down: []string{
+			"drop table if exists note_comments",
+			"alter table notes drop column if exists comments_disabled",
+		},
+	},
+	{
+		Version: 13,
+		Name:    "add_user_details_public_judge_ids",
+		Up: []string{
+			"alter table user_details add column if not exists public_judge_ids boolean not null default false",
+		},
+		Down: []string{
+			"alter table user_details drop column if exists public_judge_ids",
+		},
+	},
+	{
+		Version: 14,
+		Name:    "add_problem_difficulty_values",
+		Up: []string{
+			"alter table problems add column if not exists difficulty_value double precision",
+			"alter table problems add column if not exists difficulty_kind text not null default ''",
+			"alter table problems add column if not exists difficulty_estimate double precision",
+			// 推定値は次のクロールで設定されるので、ここでは数値と種類だけ埋める
+			`update problems set difficulty_value = difficulty::double precision
+				where difficulty ~ '^-{0,1}[0-9]+(\.[0-9]+){0,1}$'`,
+			`update problems set difficulty_kind = case domain
+					when 'atcoder' then 'atcoder-irt'
+					when 'codeforces' then 'codeforces-rating'
+					when 'yukicoder' then 'yukicoder-level'
+					when 'aoj' then 'aoj-solved'
+					when 'leetcode' then 'leetcode-level'
+					else '' end
+				where difficulty_value is not null`,
+			"create index if not exists idx_problems_difficulty_value on problems (difficulty_value)",
+			"create index if not exists idx_problems_difficulty_estimate on problems (difficulty_estimate)",
+		},
+		Down: []string{
+			"drop index if exists idx_problems_difficulty_estimate",
+			"drop index if exists idx_problems_difficulty_value",
+			"alter table problems drop column if exists difficulty_estimate",
+			"alter table problems drop column if exists difficulty_kind",
+			"alter table problems drop column if exists difficulty_value",
+		},
+	},
+	{
+		Version: 15,
+		Name:    "backfill_contest_status",
+		Up: []string{
+			// status を追加する前は、終了したコンテストだけを保存していた
+			"update contests set status = 'finished' where status is null or status = ''",
+		},
+		Down: []string{
+			// 元に戻す必要はない
+			"select 1",
+		},
+	},
+}
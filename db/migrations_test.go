@@ -0,0 +1,25 @@
+package db
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	nameRegexp := regexp.MustCompile(`^[a-z0-9_]+$`)
+	names := make(map[string]bool)
+	prev := 0
+	for _, m := range migrations {
+		if m.Version <= prev {
+			t.Errorf("migration %d is not in ascending order", m.Version)
+		}
+		prev = m.Version
+		if !nameRegexp.MatchString(m.Name) || names[m.Name] {
+			t.Errorf("migration %d has invalid or duplicate name %q", m.Version, m.Name)
+		}
+		names[m.Name] = true
+		if len(m.Up) == 0 || len(m.Down) == 0 {
+			t.Errorf("migration %d_%s must have both up and down queries", m.Version, m.Name)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// 同じユーザーと問題のノートのうち、最後に更新されたものを残す
+const duplicateNotes = `with ranked as (
+	select id, first_value(id) over (partition by user_no, problem_no order by updated_at desc nulls last, created_at desc nulls last, id) as keep_id
+	from notes
+)`
+
+// 同じ (domain, contest_id, problem_id) の問題のうち、番号の最も小さいものを残す
+// クローラーは番号の最も小さいものを更新している
+const duplicateProblems = `with ranked as (
+	select no, first_value(no) over (partition by domain, contest_id, problem_id order by no) as keep_no
+	from problems
+)`
+
+type repairStep struct {
+	name  string
+	query string
+}
+
+// 後のステップが前のステップで作られた重複や孤立した行も片付けるように並べている
+var repairSteps = []repairStep{
+	{
+		name:  "notes moved from duplicate problems",
+		query: duplicateProblems + " update notes set problem_no = ranked.keep_no from ranked where notes.problem_no = ranked.no and ranked.no <> ranked.keep_no",
+	},
+	{
+		name:  "submissions moved from duplicate problems",
+		query: duplicateProblems + " update submissions set problem_no = ranked.keep_no from ranked where submissions.problem_no = ranked.no and ranked.no <> ranked.keep_no",
+	},
+	{
+		name: "contest problem lists with duplicate problems",
+		query: duplicateProblems + ` update contests set problem_no_list = (
+			select array_agg(coalesce(ranked.keep_no, u.no) order by u.ord)
+			from unnest(contests.problem_no_list) with ordinality as u(no, ord)
+			left join ranked on ranked.no = u.no and ranked.no <> ranked.keep_no
+		) where problem_no_list && (select array_agg(no) from ranked where no <> keep_no)`,
+	},
+	{
+		name:  "duplicate problems",
+		query: duplicateProblems + " delete from problems using ranked where problems.no = ranked.no and ranked.no <> ranked.keep_no",
+	},
+	{
+		name:  "duplicate contests",
+		query: "delete from contests a using contests b where a.domain = b.domain and a.contest_id = b.contest_id and a.no > b.no",
+	},
+	{
+		name:  "notes without user or problem",
+		query: "delete from notes where user_no not in (select no from users) or problem_no not in (select no from problems)",
+	},
+	{
+		name:  "tag maps moved from duplicate notes",
+		query: duplicateNotes + " update tag_maps set note_id = ranked.keep_id from ranked where tag_maps.note_id = ranked.id and ranked.id <> ranked.keep_id",
+	},
+	{
+		name:  "revisions moved from duplicate notes",
+		query: duplicateNotes + " update note_revisions set note_id = ranked.keep_id from ranked where note_revisions.note_id = ranked.id and ranked.id <> ranked.keep_id",
+	},
+	{
+		name:  "duplicate notes",
+		query: duplicateNotes + " delete from notes using ranked where notes.id = ranked.id and ranked.id <> ranked.keep_id",
+	},
+	{
+		name:  "tag maps without note or tag",
+		query: "delete from tag_maps where note_id not in (select id from notes) or tag_no not in (select no from tags)",
+	},
+	{
+		name:  "duplicate tag maps",
+		query: "delete from tag_maps a using tag_maps b where a.note_id = b.note_id and a.tag_no = b.tag_no and a.no > b.no",
+	},
+	{
+		name:  "revisions without note",
+		query: "delete from note_revisions where note_id not in (select id from notes)",
+	},
+}
+
+type RepairResult struct {
+	Name string
+	Rows int64
+}
+
+var errDryRun = errors.New("dry run")
+
+func runRepairSteps(tx *gorm.DB) ([]RepairResult, error) {
+	var results []RepairResult
+	for _, v := range repairSteps {
+		res := tx.Exec(v.query)
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		results = append(results, RepairResult{
+			Name: v.name,
+			Rows: res.RowsAffected,
+		})
+	}
+	return results, nil
+}
+
+// Repair はマイグレーション7, 8で一意制約と外部キーを張る前に、重複している行と参照先のない行を片付ける
+// すべて1つのトランザクションで行い、dryRun ならロールバックして件数だけを返す
+func Repair(db *gorm.DB, dryRun bool) ([]RepairResult, error) {
+	var results []RepairResult
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if results, err = runRepairSteps(tx); err != nil {
+			return err
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && err != errDryRun {
+		return nil, err
+	}
+	return results, nil
+}
+
+// checkRepaired はマイグレーションのトランザクションの中で修復を試して取り消し、修復が必要な行があればエラーを返す
+// 勝手にノートを消さないように、修復は cmd/repair で確認してから行う
+func checkRepaired(tx *gorm.DB) error {
+	if err := tx.Exec("savepoint repair_check").Error; err != nil {
+		return err
+	}
+	results, err := runRepairSteps(tx)
+	if err != nil {
+		return err
+	}
+	if err := tx.Exec("rollback to savepoint repair_check").Error; err != nil {
+		return err
+	}
+
+	var found []string
+	for _, v := range results {
+		if v.Rows > 0 {
+			found = append(found, fmt.Sprintf("%s: %d", v.Name, v.Rows))
+		}
+	}
+	if len(found) > 0 {
+		return fmt.Errorf("rows that violate the new constraints remain (%s); check them with `go run ./cmd/repair -dry-run`, fix them with `go run ./cmd/repair` and migrate again", strings.Join(found, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"net/url"
+)
+
+const (
+	atcoderDomain       = "atcoder"
+	codeforcesDomain    = "codeforces"
+	codeforcesGymDomain = "codeforces-gym"
+	yukicoderDomain     = "yukicoder"
+	aojDomain           = "aoj"
+	leetcodeDomain      = "leetcode"
+)
+
+// URL はコンテストページのURLを返す。わからなければ空文字列
+func (c Contest) URL() string {
+	switch c.Domain {
+	case atcoderDomain:
+		return "https://atcoder.jp/contests/" + url.PathEscape(c.ContestID)
+	case codeforcesDomain:
+		return "https://codeforces.com/contest/" + url.PathEscape(c.ContestID)
+	case codeforcesGymDomain:
+		return "https://codeforces.com/gym/" + url.PathEscape(c.ContestID)
+	case yukicoderDomain:
+		return "https://yukicoder.me/contests/" + url.PathEscape(c.ContestID)
+	}
+	return ""
+}
+
+// CanonicalURL は問題ページの正規のURLを返す。わからなければ空文字列
+func (p Problem) CanonicalURL() string {
+	switch p.Domain {
+	case atcoderDomain:
+		return "https://atcoder.jp/contests/" + url.PathEscape(p.ContestID) + "/tasks/" + url.PathEscape(p.ProblemID)
+	case codeforcesDomain:
+		return "https://codeforces.com/contest/" + url.PathEscape(p.ContestID) + "/problem/" + url.PathEscape(p.ProblemID)
+	case codeforcesGymDomain:
+		return "https://codeforces.com/gym/" + url.PathEscape(p.ContestID) + "/problem/" + url.PathEscape(p.ProblemID)
+	case yukicoderDomain:
+		if p.FrontendID != "" {
+			return "https://yukicoder.me/problems/no/" + url.PathEscape(p.FrontendID)
+		}
+		return "https://yukicoder.me/problems/" + url.PathEscape(p.ProblemID)
+	case aojDomain:
+		return "https://onlinejudge.u-aizu.ac.jp/problems/" + url.PathEscape(p.ProblemID)
+	case leetcodeDomain:
+		if p.Slug != "" {
+			return "https://leetcode.com/problems/" + url.PathEscape(p.Slug) + "/"
+		}
+	}
+	return ""
+}
+
+// AfterFind はDBから読み込んだ問題に URL を設定する
+func (p *Problem) AfterFind() error {
+	p.URL = p.CanonicalURL()
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import "strings"
+
+const (
+	diffEqual  = "equal"
+	diffInsert = "insert"
+	diffDelete = "delete"
+
+	// LCSテーブルが大きくなりすぎる場合は全行の削除と追加として扱う
+	// ログインしていれば何度でも呼べるので、1回あたりのメモリを1MB程度に抑える
+	maxDiffCells = 250000
+)
+
+type diffLine struct {
+	Type string
+	Text string
+}
+
+func lineDiff(a, b string) []diffLine {
+	x := strings.Split(a, "\n")
+	y := strings.Split(b, "\n")
+
+	var head, tail []diffLine
+	for len(x) > 0 && len(y) > 0 && x[0] == y[0] {
+		head = append(head, diffLine{Type: diffEqual, Text: x[0]})
+		x, y = x[1:], y[1:]
+	}
+	for len(x) > 0 && len(y) > 0 && x[len(x)-1] == y[len(y)-1] {
+		tail = append([]diffLine{{Type: diffEqual, Text: x[len(x)-1]}}, tail...)
+		x, y = x[:len(x)-1], y[:len(y)-1]
+	}
+
+	lines := head
+	if len(x)*len(y) > maxDiffCells {
+		for _, v := range x {
+			lines = append(lines, diffLine{Type: diffDelete, Text: v})
+		}
+		for _, v := range y {
+			lines = append(lines, diffLine{Type: diffInsert, Text: v})
+		}
+		return append(lines, tail...)
+	}
+
+	// lcs[i*w+j] は x[i:] と y[j:] のLCSの長さ
+	n, m := len(x), len(y)
+	w := m + 1
+	lcs := make([]int32, (n+1)*w)
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+			} else if lcs[(i+1)*w+j] >= lcs[i*w+j+1] {
+				lcs[i*w+j] = lcs[(i+1)*w+j]
+			} else {
+				lcs[i*w+j] = lcs[i*w+j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n && j < m {
+		if x[i] == y[j] {
+			lines = append(lines, diffLine{Type: diffEqual, Text: x[i]})
+			i++
+			j++
+		} else if lcs[(i+1)*w+j] >= lcs[i*w+j+1] {
+			lines = append(lines, diffLine{Type: diffDelete, Text: x[i]})
+			i++
+		} else {
+			lines = append(lines, diffLine{Type: diffInsert, Text: y[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		lines = append(lines, diffLine{Type: diffDelete, Text: x[i]})
+	}
+	for ; j < m; j++ {
+		lines = append(lines, diffLine{Type: diffInsert, Text: y[j]})
+	}
+
+	return append(lines, tail...)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// 差分を "=a -b +c" の形にする
+func formatDiff(lines []diffLine) string {
+	var s []string
+	for _, v := range lines {
+		switch v.Type {
+		case diffEqual:
+			s = append(s, "="+v.Text)
+		case diffInsert:
+			s = append(s, "+"+v.Text)
+		case diffDelete:
+			s = append(s, "-"+v.Text)
+		}
+	}
+	return strings.Join(s, " ")
+}
+
+func TestLineDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"unchanged", "a\nb\nc", "a\nb\nc", "=a =b =c"},
+		{"insert", "a\nc", "a\nb\nc", "=a +b =c"},
+		{"insert at end", "a", "a\nb", "=a +b"},
+		{"delete", "a\nb\nc", "a\nc", "=a -b =c"},
+		{"delete at start", "a\nb", "b", "-a =b"},
+		{"replace", "a\nb\nc", "a\nx\nc", "=a -b +x =c"},
+		{"keep common lines", "a\nb\nc\nd", "b\nx\nd\ny", "-a =b -c +x =d +y"},
+		{"from empty", "", "a", "- +a"},
+	}
+	for _, tt := range tests {
+		if got := formatDiff(lineDiff(tt.a, tt.b)); got != tt.want {
+			t.Errorf("%s: lineDiff() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLineDiffTooLarge(t *testing.T) {
+	// 共通の先頭と末尾を除いた行数の積が maxDiffCells をわずかに超える
+	n := int(math.Sqrt(maxDiffCells)) + 1
+	var a, b []string
+	for i := 0; i < n; i++ {
+		a = append(a, fmt.Sprintf("a%d", i))
+		b = append(b, fmt.Sprintf("b%d", i))
+	}
+	// 途中に共通の行があっても、LCSは計算せずにすべて削除と追加にする
+	a[n/2], b[n/2] = "common", "common"
+	lines := lineDiff("head\n"+strings.Join(a, "\n")+"\ntail", "head\n"+strings.Join(b, "\n")+"\ntail")
+
+	if len(lines) != 2+len(a)+len(b) {
+		t.Fatalf("len(lines) = %d, want %d", len(lines), 2+len(a)+len(b))
+	}
+	if lines[0] != (diffLine{Type: diffEqual, Text: "head"}) || lines[len(lines)-1] != (diffLine{Type: diffEqual, Text: "tail"}) {
+		t.Errorf("common head and tail are not kept: %v, %v", lines[0], lines[len(lines)-1])
+	}
+	for i, v := range lines[1 : len(lines)-1] {
+		var want diffLine
+		if i < len(a) {
+			want = diffLine{Type: diffDelete, Text: a[i]}
+		} else {
+			want = diffLine{Type: diffInsert, Text: b[i-len(a)]}
+		}
+		if v != want {
+			t.Fatalf("lines[%d] = %v, want %v", i+1, v, want)
+		}
+	}
+}
+
+func TestLineDiffLimit(t *testing.T) {
+	// maxDiffCells 以内なら共通の行を残す
+	n := int(math.Sqrt(maxDiffCells))
+	var a, b []string
+	for i := 0; i < n; i++ {
+		a = append(a, fmt.Sprintf("a%d", i))
+		b = append(b, fmt.Sprintf("b%d", i))
+	}
+	a[n/2], b[n/2] = "common", "common"
+	equal := 0
+	for _, v := range lineDiff(strings.Join(a, "\n"), strings.Join(b, "\n")) {
+		if v.Type == diffEqual {
+			equal++
+		}
+	}
+	if equal != 1 {
+		t.Errorf("equal lines = %d, want 1", equal)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/tsushiy/codernote-backend/db"
+)
+
+const exportVersion = 1
+
+// exportNote はマニフェストに載せるノートの情報
+type exportNote struct {
+	Path      string
+	ID        string
+	ProblemNo int
+	Domain    string
+	ContestID string
+	ProblemID string
+	Title     string
+	URL       string
+	Tags      []string
+	Public    bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type exportManifest struct {
+	Version    int
+	ExportedAt time.Time
+	User       User
+	Notes      []exportNote
+}
+
+// ノートはMarkdownのフロントマターに問題、タグ、公開設定、日時を書き、本文をそのまま続ける
+// 文字列はダブルクォートで囲むので、YAMLとしても読める
+func writeNoteMarkdown(w io.Writer, n exportNote, text string) error {
+	var b strings.Builder
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "problemNo: %d\n", n.ProblemNo)
+	fmt.Fprintf(&b, "domain: %s\n", strconv.Quote(n.Domain))
+	fmt.Fprintf(&b, "contestId: %s\n", strconv.Quote(n.ContestID))
+	fmt.Fprintf(&b, "problemId: %s\n", strconv.Quote(n.ProblemID))
+	fmt.Fprintf(&b, "title: %s\n", strconv.Quote(n.Title))
+	fmt.Fprintf(&b, "url: %s\n", strconv.Quote(n.URL))
+	tags := make([]string, len(n.Tags))
+	for i, v := range n.Tags {
+		tags[i] = strconv.Quote(v)
+	}
+	fmt.Fprintf(&b, "tags: [%s]\n", strings.Join(tags, ", "))
+	fmt.Fprintf(&b, "public: %t\n", n.Public)
+	fmt.Fprintf(&b, "createdAt: %s\n", n.CreatedAt.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "updatedAt: %s\n", n.UpdatedAt.UTC().Format(time.RFC3339))
+	b.WriteString("---\n")
+	b.WriteString(text)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var unsafePathChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+func safePathElem(s string) string {
+	s = unsafePathChars.ReplaceAllString(s, "_")
+	if s == "" || strings.Trim(s, ".") == "" {
+		return "_"
+	}
+	return s
+}
+
+// notes/{domain}/{contestId}/{problemId}.md にする。重ならないようにノートIDを付けることがある
+func exportNotePath(n exportNote, used map[string]bool) string {
+	elems := []string{"notes", safePathElem(n.Domain)}
+	if n.ContestID != "" {
+		elems = append(elems, safePathElem(n.ContestID))
+	}
+	name := safePathElem(n.ProblemID)
+	p := path.Join(append(elems, name+".md")...)
+	if used[p] {
+		p = path.Join(append(elems, name+"_"+n.ID+".md")...)
+	}
+	used[p] = true
+	return p
+}
+
+func writeZipJSON(zw *zip.Writer, name string, v interface{}) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func (s *server) exportGetHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(uidKey).(string)
+
+	user, err := s.store.GetUser(uid)
+	if err != nil {
+		http.Error(w, "user is not registered", http.StatusBadRequest)
+		return
+	}
+	detail, err := s.store.GetUserDetail(uid)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to export", http.StatusInternalServerError)
+		return
+	}
+	notes, err := s.store.ListUserNotes(user.No)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to export", http.StatusInternalServerError)
+		return
+	}
+	tags, err := s.store.ListUserNoteTags(user.No)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to export", http.StatusInternalServerError)
+		return
+	}
+
+	manifest := exportManifest{
+		Version:    exportVersion,
+		ExportedAt: time.Now().UTC(),
+		User:       user,
+		Notes:      []exportNote{},
+	}
+
+	// ここから先はレスポンスを書き始めているので、エラーはログに残すだけにする
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="codernote-export.zip"`)
+	zw := zip.NewWriter(w)
+	if err := writeZipJSON(zw, "profile.json", user); err != nil {
+		log.Println(err)
+		return
+	}
+	if err := writeZipJSON(zw, "settings.json", detail); err != nil {
+		log.Println(err)
+		return
+	}
+
+	used := make(map[string]bool)
+	for _, v := range notes {
+		n := exportNote{
+			ID:        v.ID,
+			ProblemNo: v.ProblemNo,
+			Domain:    v.Problem.Domain,
+			ContestID: v.Problem.ContestID,
+			ProblemID: v.Problem.ProblemID,
+			Title:     v.Problem.Title,
+			URL:       v.Problem.URL,
+			Tags:      append([]string{}, tags[v.ID]...),
+			Public:    v.Public == 2,
+			CreatedAt: v.CreatedAt,
+			UpdatedAt: v.UpdatedAt,
+		}
+		n.Path = exportNotePath(n, used)
+		f, err := zw.Create(n.Path)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if err := writeNoteMarkdown(f, n, v.Text); err != nil {
+			log.Println(err)
+			return
+		}
+		manifest.Notes = append(manifest.Notes, n)
+	}
+
+	if err := writeZipJSON(zw, "manifest.json", manifest); err != nil {
+		log.Println(err)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		log.Println(err)
+	}
+}
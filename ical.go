@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	. "github.com/tsushiy/codernote-backend/db"
+)
+
+const icalTimeFormat = "20060102T150405Z"
+
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`, "\r", "")
+
+// RFC 5545 に従って75オクテットごとに折り返す
+func icalLine(buf *bytes.Buffer, name, value string) {
+	line := name + ":" + value
+	for len(line) > 75 {
+		n := 75
+		for !utf8.RuneStart(line[n]) {
+			n--
+		}
+		buf.WriteString(line[:n] + "\r\n ")
+		line = line[n:]
+	}
+	buf.WriteString(line + "\r\n")
+}
+
+func writeContestsICal(w io.Writer, contests []Contest) error {
+	now := time.Now().UTC().Format(icalTimeFormat)
+
+	var buf bytes.Buffer
+	icalLine(&buf, "BEGIN", "VCALENDAR")
+	icalLine(&buf, "VERSION", "2.0")
+	icalLine(&buf, "PRODID", "-//codernote//contests//EN")
+	icalLine(&buf, "CALSCALE", "GREGORIAN")
+	icalLine(&buf, "X-WR-CALNAME", "codernote contests")
+	for _, v := range contests {
+		start := time.Unix(int64(v.StartTimeSeconds), 0).UTC()
+		end := start.Add(time.Duration(v.DurationSeconds) * time.Second)
+		icalLine(&buf, "BEGIN", "VEVENT")
+		icalLine(&buf, "UID", fmt.Sprintf("contest-%d@codernote", v.No))
+		icalLine(&buf, "DTSTAMP", now)
+		icalLine(&buf, "DTSTART", start.Format(icalTimeFormat))
+		icalLine(&buf, "DTEND", end.Format(icalTimeFormat))
+		icalLine(&buf, "SUMMARY", icalEscaper.Replace(v.Title))
+		icalLine(&buf, "CATEGORIES", icalEscaper.Replace(v.Domain))
+		if u := v.URL(); u != "" {
+			icalLine(&buf, "URL", u)
+			icalLine(&buf, "DESCRIPTION", icalEscaper.Replace(u))
+		}
+		icalLine(&buf, "END", "VEVENT")
+	}
+	icalLine(&buf, "END", "VCALENDAR")
+
+	_, err := buf.WriteTo(w)
+	return err
+}
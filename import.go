@@ -0,0 +1,325 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	. "github.com/tsushiy/codernote-backend/db"
+	"github.com/tsushiy/codernote-backend/store"
+)
+
+const (
+	maxImportSize  = 32 * 1024 * 1024
+	maxImportFiles = 1000
+	maxNoteSize    = 1024 * 1024
+)
+
+const (
+	importImported  = "imported"
+	importMerged    = "merged"
+	importSkipped   = "skipped"
+	importUnmatched = "unmatched"
+)
+
+// noteFrontMatter はインポートするMarkdownのフロントマターのうち、使う項目
+// 問題は Domain と ProblemID (必要なら ContestID)、または URL で指定する
+type noteFrontMatter struct {
+	Domain    string
+	ContestID string
+	ProblemID string
+	URL       string
+	Tags      []string
+	Public    bool
+}
+
+// parseFrontMatter は "---" で囲まれたフロントマターと本文を分ける
+// YAMLのうち、"key: value" の行と、タグのリスト ([a, b] または "- a" の行) だけを読む
+func parseFrontMatter(content string) (noteFrontMatter, string, error) {
+	content = strings.TrimPrefix(content, "\ufeff")
+	content = strings.Replace(content, "\r\n", "\n", -1)
+	if !strings.HasPrefix(content, "---\n") {
+		return noteFrontMatter{}, "", errors.New("no front-matter")
+	}
+	lines := strings.SplitAfter(content[len("---\n"):], "\n")
+
+	var fm noteFrontMatter
+	key := ""
+	for i, line := range lines {
+		l := strings.TrimSpace(line)
+		if l == "---" || l == "..." {
+			return fm, strings.Join(lines[i+1:], ""), nil
+		}
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		if strings.HasPrefix(l, "- ") {
+			if key == "tags" {
+				fm.Tags = append(fm.Tags, parseFrontMatterScalar(l[2:]))
+			}
+			continue
+		}
+		kv := strings.SplitN(l, ":", 2)
+		if len(kv) != 2 {
+			return fm, "", errors.New("invalid front-matter")
+		}
+		key = strings.ToLower(strings.Replace(strings.TrimSpace(kv[0]), "_", "", -1))
+		value := strings.TrimSpace(kv[1])
+		switch key {
+		case "domain":
+			fm.Domain = parseFrontMatterScalar(value)
+		case "contestid", "contest":
+			fm.ContestID = parseFrontMatterScalar(value)
+		case "problemid", "problem":
+			fm.ProblemID = parseFrontMatterScalar(value)
+		case "url":
+			fm.URL = parseFrontMatterScalar(value)
+		case "tags":
+			fm.Tags = append(fm.Tags, parseFrontMatterList(value)...)
+		case "public":
+			switch strings.ToLower(parseFrontMatterScalar(value)) {
+			case "true", "yes", "on":
+				fm.Public = true
+			}
+		}
+	}
+	return fm, "", errors.New("front-matter is not closed")
+}
+
+func parseFrontMatterScalar(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		if v, err := strconv.Unquote(s); err == nil {
+			return v
+		}
+	}
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1)
+	}
+	return s
+}
+
+// "[a, "b, c"]" のようなリストか、カンマ区切りの文字列を分ける
+func parseFrontMatterList(s string) []string {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		s = s[1 : len(s)-1]
+	}
+	var items []string
+	var quote byte
+	start := 0
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) && quote != 0 {
+			if s[i] == '\\' && quote == '"' {
+				i++
+			} else if s[i] == quote {
+				quote = 0
+			}
+			continue
+		}
+		if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+			quote = s[i]
+			continue
+		}
+		if i == len(s) || s[i] == ',' {
+			if v := parseFrontMatterScalar(s[start:i]); v != "" {
+				items = append(items, v)
+			}
+			start = i + 1
+		}
+	}
+	return items
+}
+
+type importFileResult struct {
+	Path      string
+	Status    string
+	ProblemNo int    `json:",omitempty"`
+	Reason    string `json:",omitempty"`
+}
+
+type importResp struct {
+	Imported  int
+	Merged    int
+	Skipped   int
+	Unmatched int
+	Files     []importFileResult
+}
+
+func (s *server) importPostHandler(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(uidKey).(string)
+
+	overwrite := r.URL.Query().Get("overwrite") == "true"
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		http.Error(w, "too large archive", http.StatusBadRequest)
+		return
+	}
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		http.Error(w, "invalid zip archive", http.StatusBadRequest)
+		return
+	}
+	if len(zr.File) > maxImportFiles {
+		http.Error(w, "too many files", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.store.GetUser(uid)
+	if err != nil {
+		http.Error(w, "user is not registered", http.StatusBadRequest)
+		return
+	}
+
+	resp := importResp{Files: []importFileResult{}}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		res, err := s.importNote(user, f, overwrite)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "failed to import notes", http.StatusInternalServerError)
+			return
+		}
+		switch res.Status {
+		case importImported:
+			resp.Imported++
+		case importMerged:
+			resp.Merged++
+		case importSkipped:
+			resp.Skipped++
+		case importUnmatched:
+			resp.Unmatched++
+		}
+		resp.Files = append(resp.Files, res)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// importNote は1つのファイルを取り込む。取り込めないファイルは error ではなく結果で返す
+// ノートがすでにあれば本文は overwrite のときだけ置き換え、タグは追加する
+func (s *server) importNote(user User, f *zip.File, overwrite bool) (importFileResult, error) {
+	res := importFileResult{Path: f.Name}
+	skip := func(reason string) (importFileResult, error) {
+		res.Status = importSkipped
+		res.Reason = reason
+		return res, nil
+	}
+
+	switch strings.ToLower(path.Ext(f.Name)) {
+	case ".md", ".markdown":
+	default:
+		return skip("not a markdown file")
+	}
+	// フロントマターの分だけ余裕を持たせる
+	if f.UncompressedSize64 > maxNoteSize*2 {
+		return skip("too large file")
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return skip("failed to read file")
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(rc, maxNoteSize*2+1))
+	rc.Close()
+	if err != nil {
+		return skip("failed to read file")
+	}
+	if len(content) > maxNoteSize*2 {
+		return skip("too large file")
+	}
+
+	fm, text, err := parseFrontMatter(string(content))
+	if err != nil {
+		return skip(err.Error())
+	}
+	if len(text) > maxNoteSize {
+		return skip("too large text")
+	}
+
+	var tags []string
+	seen := make(map[string]bool)
+	for _, v := range fm.Tags {
+		key := s.normalizeTag(v)
+		if err := validateTag(key); err != nil {
+			return skip(err.Error())
+		}
+		if !seen[key] {
+			seen[key] = true
+			tags = append(tags, key)
+		}
+	}
+	if len(tags) > maxBulkTags {
+		return skip("too many tags")
+	}
+
+	var filter Problem
+	if fm.Domain != "" && fm.ProblemID != "" {
+		filter = Problem{Domain: fm.Domain, ContestID: fm.ContestID, ProblemID: fm.ProblemID}
+	} else if fm.URL != "" {
+		var ok bool
+		if filter, ok = parseProblemURL(fm.URL); !ok {
+			res.Status = importUnmatched
+			res.Reason = "unsupported url"
+			return res, nil
+		}
+	} else {
+		return skip("problem is not specified")
+	}
+	// ContestID を省略して複数の問題に一致する場合は、どれか決められない
+	problems, err := s.store.FindProblems(filter, 2)
+	if err != nil {
+		return res, err
+	}
+	if len(problems) != 1 {
+		res.Status = importUnmatched
+		res.Reason = "no problem matched"
+		if len(problems) > 1 {
+			res.Reason = "multiple problems matched"
+		}
+		return res, nil
+	}
+	res.ProblemNo = problems[0].No
+
+	public := 1
+	if fm.Public {
+		public = 2
+	}
+	note, err := s.store.GetUserNote(user.UserID, res.ProblemNo)
+	switch {
+	case err == store.ErrNotFound:
+		if strings.TrimSpace(text) == "" {
+			return skip("empty text")
+		}
+		if _, err := s.store.SaveNote(user.No, res.ProblemNo, text, public); err != nil {
+			return res, err
+		}
+		res.Status = importImported
+	case err != nil:
+		return res, err
+	default:
+		if overwrite && strings.TrimSpace(text) != "" && (text != note.Text || public != note.Public) {
+			if _, err := s.store.SaveNote(user.No, res.ProblemNo, text, public); err != nil {
+				return res, err
+			}
+		}
+		res.Status = importMerged
+	}
+
+	if len(tags) > 0 {
+		if _, err := s.store.BulkUpdateNoteTags(user.No, []store.NoteTagOp{{ProblemNo: res.ProblemNo, Add: tags}}); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	googlePublicKeyURL = "https://www.googleapis.com/robot/v1/metadata/x509/securetoken@system.gserviceaccount.com"
+
+	// Cache-Controlにmax-ageがない場合の有効期間
+	defaultKeyTTL = time.Hour
+	// 有効期限のこの時間前にバックグラウンドで更新する
+	keyRefreshMargin = 5 * time.Minute
+	// 更新に失敗した場合、有効期限からこの時間までは古い鍵を使い続ける
+	maxKeyStaleness = 6 * time.Hour
+	// 未知のkidによる再取得の最小間隔
+	minKeyRefetchInterval = time.Minute
+	keyRetryInterval      = 30 * time.Second
+)
+
+var maxAgeRegexp = regexp.MustCompile(`max-age=(\d+)`)
+
+type publicKeyMap map[string]*rsa.PublicKey
+
+// keyStore はGoogleの公開鍵をCache-Controlのmax-ageの間キャッシュする
+type keyStore struct {
+	url    string
+	client *http.Client
+
+	mu        sync.RWMutex
+	keys      publicKeyMap
+	expiresAt time.Time
+
+	// 取得は同時に1つだけ行う
+	fetchMu     sync.Mutex
+	attemptedAt time.Time
+}
+
+func newKeyStore(url string) *keyStore {
+	return &keyStore{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (k *keyStore) Key(kid string) (*rsa.PublicKey, error) {
+	now := time.Now()
+
+	k.mu.RLock()
+	key, ok := k.keys[kid]
+	staleUntil := k.expiresAt.Add(maxKeyStaleness)
+	fresh := now.Before(k.expiresAt)
+	k.mu.RUnlock()
+
+	var err error
+	switch {
+	case ok && fresh:
+		return key, nil
+	case ok && now.Before(staleUntil):
+		// 期限切れでも上限までは古い鍵を返し、更新はバックグラウンドで行う
+		go func() {
+			if err := k.refresh(keyRetryInterval); err != nil {
+				log.Println(err)
+			}
+		}()
+		return key, nil
+	case now.Before(staleUntil):
+		// 鍵のローテーションで新しいkidが使われている可能性がある
+		err = k.refresh(minKeyRefetchInterval)
+	default:
+		err = k.refresh(keyRetryInterval)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+	return k.lookup(kid)
+}
+
+func (k *keyStore) lookup(kid string) (*rsa.PublicKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if len(k.keys) == 0 || time.Now().After(k.expiresAt.Add(maxKeyStaleness)) {
+		return nil, &keyFetchError{errors.New("no valid public key available")}
+	}
+	if key, ok := k.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, errors.New("invalid public key id")
+}
+
+// 直近 interval 以内に取得を試みていれば何もしない
+func (k *keyStore) refresh(interval time.Duration) error {
+	start := time.Now()
+	k.fetchMu.Lock()
+	defer k.fetchMu.Unlock()
+
+	// 待っている間に他のgoroutineが取得していれば、それを使う
+	if !k.attemptedAt.Before(start) || time.Since(k.attemptedAt) < interval {
+		return nil
+	}
+	k.attemptedAt = time.Now()
+
+	keys, ttl, err := k.fetch()
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.expiresAt = time.Now().Add(ttl)
+	k.mu.Unlock()
+	return nil
+}
+
+func (k *keyStore) fetch() (publicKeyMap, time.Duration, error) {
+	resp, err := k.client.Get(k.url)
+	if err != nil {
+		return nil, 0, errors.New("error fetching public key: " + err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("error fetching public key: bad response status code %d", resp.StatusCode)
+	}
+
+	d := make(map[string]string)
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, 0, errors.New("error decoding public key http response body: " + err.Error())
+	}
+
+	keyMap := make(publicKeyMap)
+	for k, v := range d {
+		key, err := jwt.ParseRSAPublicKeyFromPEM([]byte(v))
+		if err != nil {
+			return nil, 0, errors.New("error parsing public key: " + err.Error())
+		}
+		keyMap[k] = key
+	}
+
+	ttl := defaultKeyTTL
+	if m := maxAgeRegexp.FindStringSubmatch(resp.Header.Get("Cache-Control")); m != nil {
+		if sec, err := strconv.Atoi(m[1]); err == nil {
+			ttl = time.Duration(sec) * time.Second
+		}
+	}
+
+	return keyMap, ttl, nil
+}
+
+// 有効期限が切れる前にバックグラウンドで鍵を更新し続ける
+func (k *keyStore) run(stop <-chan struct{}) {
+	for {
+		wait := keyRetryInterval
+		if err := k.refresh(0); err != nil {
+			log.Println(err)
+		} else {
+			k.mu.RLock()
+			wait = time.Until(k.expiresAt) - keyRefreshMargin
+			k.mu.RUnlock()
+			if wait < keyRetryInterval {
+				wait = keyRetryInterval
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
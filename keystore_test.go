@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testKeyServer は公開鍵を返すサーバで、取得された回数を数える
+// 使い終わったら Close する
+type testKeyServer struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	keys         map[string]string
+	cacheControl string
+	fail         bool
+	fetches      int
+}
+
+func newTestKeyServer(t *testing.T, kids ...string) *testKeyServer {
+	s := &testKeyServer{
+		keys:         make(map[string]string),
+		cacheControl: "public, max-age=3600, must-revalidate, no-transform",
+	}
+	for _, kid := range kids {
+		s.addKey(t, kid)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.fetches++
+		if s.fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if s.cacheControl != "" {
+			w.Header().Set("Cache-Control", s.cacheControl)
+		}
+		json.NewEncoder(w).Encode(s.keys)
+	}))
+	return s
+}
+
+func (s *testKeyServer) addKey(t *testing.T, kid string) {
+	pem := encodePublicKeyPEM(t, &newTestRSAKey(t).PublicKey)
+	s.mu.Lock()
+	s.keys[kid] = pem
+	s.mu.Unlock()
+}
+
+func (s *testKeyServer) setFail(fail bool) {
+	s.mu.Lock()
+	s.fail = fail
+	s.mu.Unlock()
+}
+
+func (s *testKeyServer) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+// waitFetchCount はバックグラウンドでの取得を待つ
+func (s *testKeyServer) waitFetchCount(t *testing.T, want int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for s.fetchCount() < want && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := s.fetchCount(); got != want {
+		t.Fatalf("fetch count = %d, want %d", got, want)
+	}
+}
+
+// expire は鍵を取得してから経過した時間を進める
+// 取得を試みた時刻も戻すので、再取得の間隔の制限にはかからない
+func expire(k *keyStore, elapsed time.Duration) {
+	k.fetchMu.Lock()
+	k.attemptedAt = k.attemptedAt.Add(-elapsed)
+	k.fetchMu.Unlock()
+	k.mu.Lock()
+	k.expiresAt = k.expiresAt.Add(-elapsed)
+	k.mu.Unlock()
+}
+
+func expiresIn(k *keyStore) time.Duration {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return time.Until(k.expiresAt)
+}
+
+func TestKeyStoreMaxAge(t *testing.T) {
+	s := newTestKeyServer(t, "k1")
+	defer s.Close()
+	k := newKeyStore(s.URL)
+
+	if _, err := k.Key("k1"); err != nil {
+		t.Fatal(err)
+	}
+	if d := expiresIn(k); d < 3590*time.Second || 3600*time.Second < d {
+		t.Errorf("keys expire in %v, want max-age=3600", d)
+	}
+	// 有効期間内は取得しない
+	for i := 0; i < 3; i++ {
+		if _, err := k.Key("k1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.waitFetchCount(t, 1)
+
+	// 期限が切れたら、古い鍵を返しつつバックグラウンドで取得する
+	expire(k, 2*time.Hour)
+	if _, err := k.Key("k1"); err != nil {
+		t.Fatal(err)
+	}
+	s.waitFetchCount(t, 2)
+	if d := expiresIn(k); d < 3590*time.Second {
+		t.Errorf("keys expire in %v after refresh", d)
+	}
+
+	// max-age がなければデフォルトの有効期間にする
+	s.mu.Lock()
+	s.cacheControl = ""
+	s.mu.Unlock()
+	if err := k.refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if d := expiresIn(k); d < defaultKeyTTL-10*time.Second || defaultKeyTTL < d {
+		t.Errorf("keys expire in %v, want %v", d, defaultKeyTTL)
+	}
+}
+
+func TestKeyStoreStaleness(t *testing.T) {
+	s := newTestKeyServer(t, "k1")
+	defer s.Close()
+	k := newKeyStore(s.URL)
+	if _, err := k.Key("k1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 取得に失敗しても、期限切れから maxKeyStaleness までは古い鍵を使う
+	s.setFail(true)
+	expire(k, time.Hour+5*time.Hour)
+	if _, err := k.Key("k1"); err != nil {
+		t.Fatalf("stale key is not used: %v", err)
+	}
+	s.waitFetchCount(t, 2)
+
+	// 上限を過ぎたら使わない
+	expire(k, 2*time.Hour)
+	_, err := k.Key("k1")
+	if _, ok := err.(*keyFetchError); !ok {
+		t.Fatalf("err = %v, want keyFetchError", err)
+	}
+	s.waitFetchCount(t, 3)
+
+	// 直後の呼び出しでは再取得しない
+	if _, err := k.Key("k1"); err == nil {
+		t.Fatal("expired key is used")
+	}
+	if got := s.fetchCount(); got != 3 {
+		t.Errorf("fetch count = %d, want 3", got)
+	}
+
+	// 取得できるようになれば元に戻る
+	s.setFail(false)
+	expire(k, keyRetryInterval)
+	if _, err := k.Key("k1"); err != nil {
+		t.Fatal(err)
+	}
+	s.waitFetchCount(t, 4)
+}
+
+func TestKeyStoreUnknownKid(t *testing.T) {
+	s := newTestKeyServer(t, "k1")
+	defer s.Close()
+	k := newKeyStore(s.URL)
+	if _, err := k.Key("k1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// ローテーションされた鍵は、有効期間内でも取得し直す
+	s.addKey(t, "k2")
+	expire(k, minKeyRefetchInterval)
+	if _, err := k.Key("k2"); err != nil {
+		t.Fatalf("rotated key is not fetched: %v", err)
+	}
+	s.waitFetchCount(t, 2)
+
+	// 未知のkidが続いても、minKeyRefetchInterval の間は取得しない
+	for i := 0; i < 3; i++ {
+		if _, err := k.Key("unknown"); err == nil {
+			t.Fatal("unknown kid is accepted")
+		} else if _, ok := err.(*keyFetchError); ok {
+			t.Fatalf("err = %v, want invalid key id", err)
+		}
+	}
+	if got := s.fetchCount(); got != 2 {
+		t.Errorf("fetch count = %d, want 2", got)
+	}
+	if _, err := k.Key("k1"); err != nil {
+		t.Fatal(err)
+	}
+}
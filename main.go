@@ -7,65 +7,117 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/jinzhu/gorm"
 	_ "github.com/lib/pq"
 	"github.com/rs/cors"
 	. "github.com/tsushiy/codernote-backend/db"
+	"github.com/tsushiy/codernote-backend/store"
 )
 
 type server struct {
-	db *gorm.DB
+	store    store.Store
+	verifier TokenVerifier
+	// タグを小文字にして空白をまとめてから保存する
+	normalizeTags bool
 }
 
 func main() {
+	verifier, err := newTokenVerifierFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db := DbConnect(false)
+	defer db.Close()
+	// db.LogMode(true)
+
 	s := &server{}
-	s.db = DbConnect(false)
-	defer s.db.Close()
-	// s.db.LogMode(true)
+	s.store = store.NewGormStore(db)
+	s.verifier = verifier
+	s.normalizeTags = os.Getenv("NORMALIZE_TAGS") == "true"
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	c := cors.New(cors.Options{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodDelete},
+		AllowedHeaders: []string{"*"},
+		ExposedHeaders: []string{"X-Total-Count"},
+	})
+
+	srv := &http.Server{
+		Handler:      c.Handler(s.newRouter()),
+		Addr:         ":" + port,
+		WriteTimeout: 30 * time.Second,
+		ReadTimeout:  30 * time.Second,
+	}
+
+	log.Println("Listen Server ....")
+	log.Fatal(srv.ListenAndServe())
+}
 
+func (s *server) newRouter() http.Handler {
 	router := mux.NewRouter()
 	router.Use(loggerMiddleware)
 
 	nonAuthRouter := router.NewRoute().Subrouter()
 	nonAuthRouter.HandleFunc("/healthcheck", s.healthcheckHandler).Methods("GET")
-	nonAuthRouter.HandleFunc("/problems", s.problemsGetHandler).Methods("GET")
+	nonAuthRouter.HandleFunc("/problems/resolve", s.problemResolveGetHandler).Methods("GET")
 	nonAuthRouter.HandleFunc("/contests", s.contestsGetHandler).Methods("GET")
+	nonAuthRouter.HandleFunc("/contests/upcoming", s.upcomingContestsGetHandler).Methods("GET")
+	nonAuthRouter.HandleFunc("/contests.ics", s.contestsICalGetHandler).Methods("GET")
 	nonAuthRouter.HandleFunc("/note", s.publicNoteGetHandler).Methods("GET")
 	nonAuthRouter.HandleFunc("/notes", s.publicNoteListGetHandler).Methods("GET")
+	nonAuthRouter.HandleFunc("/note/{noteID}/comments", s.noteCommentsGetHandler).Methods("GET")
+	nonAuthRouter.HandleFunc("/users/{userName:[a-zA-Z0-9_]+}", s.userProfileGetHandler).Methods("GET")
+	nonAuthRouter.HandleFunc("/tags", s.tagsGetHandler).Methods("GET")
+	nonAuthRouter.HandleFunc("/tags/popular", s.popularTagsGetHandler).Methods("GET")
+
+	optionalAuthRouter := router.NewRoute().Subrouter()
+	optionalAuthRouter.Use(s.optionalAuthMiddleware)
+	optionalAuthRouter.HandleFunc("/problems", s.problemsGetHandler).Methods("GET")
 
 	authRouter := router.NewRoute().Subrouter()
-	authRouter.Use(authMiddleware)
+	authRouter.Use(s.authMiddleware)
 	authRouter.HandleFunc("/login", s.loginPostHandler).Methods("POST")
+	authRouter.HandleFunc("/user", s.userDeleteHandler).Methods("DELETE")
+	authRouter.HandleFunc("/user/export", s.exportGetHandler).Methods("GET")
+	authRouter.HandleFunc("/user/import", s.importPostHandler).Methods("POST")
 	authRouter.HandleFunc("/user/name", s.userNamePostHandler).Methods("POST")
 	authRouter.HandleFunc("/user/setting", s.userSettingGetHandler).Methods("GET")
 	authRouter.HandleFunc("/user/setting", s.userSettingPostHandler).Methods("POST")
+	authRouter.HandleFunc("/user/solved", s.solvedGetHandler).Methods("GET")
+	authRouter.HandleFunc("/user/tokens", s.accessTokenListGetHandler).Methods("GET")
+	authRouter.HandleFunc("/user/tokens", s.accessTokenPostHandler).Methods("POST")
+	authRouter.HandleFunc("/user/tokens/{tokenNo:[0-9]+}", s.accessTokenDeleteHandler).Methods("DELETE")
 	authRouter.HandleFunc("/user/note", s.authNoteGetHandler).Methods("GET")
 	authRouter.HandleFunc("/user/note/{problemNo:[0-9]+}", s.myNoteGetHandler).Methods("GET")
 	authRouter.HandleFunc("/user/note/{problemNo:[0-9]+}", s.myNotePostHandler).Methods("POST")
 	authRouter.HandleFunc("/user/note/{problemNo:[0-9]+}", s.myNoteDeleteHandler).Methods("DELETE")
 	authRouter.HandleFunc("/user/notes", s.myNoteListGetHandler).Methods("GET")
+	authRouter.HandleFunc("/user/tags", s.myTagsGetHandler).Methods("GET")
+	authRouter.HandleFunc("/user/tags/rename", s.tagRenamePostHandler).Methods("POST")
+	authRouter.HandleFunc("/user/tags/merge", s.tagMergePostHandler).Methods("POST")
+	authRouter.HandleFunc("/user/tags/bulk", s.tagBulkPostHandler).Methods("POST")
+	authRouter.HandleFunc("/user/note/{problemNo:[0-9]+}/revisions", s.noteRevisionListGetHandler).Methods("GET")
+	authRouter.HandleFunc("/user/note/{problemNo:[0-9]+}/revisions/diff", s.noteRevisionDiffGetHandler).Methods("GET")
+	authRouter.HandleFunc("/user/note/{problemNo:[0-9]+}/revisions/{revisionNo:[0-9]+}/restore", s.noteRevisionRestorePostHandler).Methods("POST")
 	authRouter.HandleFunc("/user/note/{problemNo:[0-9]+}/tag", s.tagGetHandler).Methods("GET")
 	authRouter.HandleFunc("/user/note/{problemNo:[0-9]+}/tag", s.tagPostHandler).Methods("POST")
 	authRouter.HandleFunc("/user/note/{problemNo:[0-9]+}/tag", s.tagDeleteHandler).Methods("DELETE")
+	authRouter.HandleFunc("/user/follow/{userName:[a-zA-Z0-9_]+}", s.followPostHandler).Methods("POST")
+	authRouter.HandleFunc("/user/follow/{userName:[a-zA-Z0-9_]+}", s.followDeleteHandler).Methods("DELETE")
+	authRouter.HandleFunc("/user/followers", s.followersGetHandler).Methods("GET")
+	authRouter.HandleFunc("/user/following", s.followingGetHandler).Methods("GET")
+	authRouter.HandleFunc("/user/feed", s.feedGetHandler).Methods("GET")
+	authRouter.HandleFunc("/note/{noteID}/star", s.starPostHandler).Methods("POST")
+	authRouter.HandleFunc("/note/{noteID}/star", s.starDeleteHandler).Methods("DELETE")
+	authRouter.HandleFunc("/user/starred", s.starredNoteListGetHandler).Methods("GET")
+	authRouter.HandleFunc("/user/note/{noteID}/comments", s.noteCommentPostHandler).Methods("POST")
+	authRouter.HandleFunc("/user/note/{noteID}/comments/setting", s.noteCommentSettingPostHandler).Methods("POST")
+	authRouter.HandleFunc("/user/note/{noteID}/comments/{commentNo:[0-9]+}", s.noteCommentDeleteHandler).Methods("DELETE")
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-
-	c := cors.New(cors.Options{
-		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodDelete},
-		AllowedHeaders: []string{"*"},
-	})
-
-	srv := &http.Server{
-		Handler:      c.Handler(router),
-		Addr:         ":" + port,
-		WriteTimeout: 30 * time.Second,
-		ReadTimeout:  30 * time.Second,
-	}
-
-	log.Println("Listen Server ....")
-	log.Fatal(srv.ListenAndServe())
+	return router
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/tsushiy/codernote-backend/store"
+)
+
+const (
+	testSecret   = "test-secret"
+	testAudience = "codernote-test"
+	testIssuer   = "codernote-test"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+type testEnv struct {
+	t       *testing.T
+	server  *server
+	store   *store.MemoryStore
+	handler http.Handler
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	s := &server{
+		store:    store.NewMemoryStore(),
+		verifier: newHMACVerifier([]byte(testSecret), testAudience, testIssuer),
+	}
+	return &testEnv{
+		t:       t,
+		server:  s,
+		store:   s.store.(*store.MemoryStore),
+		handler: s.newRouter(),
+	}
+}
+
+// uid をsubに持つ、ローカル検証器で有効なトークンを返す
+func (e *testEnv) token(uid string) string {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": uid,
+		"aud": testAudience,
+		"iss": testIssuer,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	})
+	raw, err := token.SignedString([]byte(testSecret))
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	return raw
+}
+
+// login してユーザーを作成し、そのトークンを返す
+func (e *testEnv) login(uid string) string {
+	token := e.token(uid)
+	if rec := e.do("POST", "/login", token, nil); rec.Code != http.StatusOK {
+		e.t.Fatalf("login failed: %d %s", rec.Code, rec.Body)
+	}
+	return token
+}
+
+// body が []byte ならそのまま、それ以外はJSONにして送る
+func (e *testEnv) do(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	var r io.Reader
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		r = bytes.NewReader(b)
+	default:
+		raw, err := json.Marshal(b)
+		if err != nil {
+			e.t.Fatal(err)
+		}
+		r = bytes.NewReader(raw)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	e.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// ステータスコードを確認し、レスポンスをvにデコードする
+func (e *testEnv) expect(rec *httptest.ResponseRecorder, code int, v interface{}) {
+	e.t.Helper()
+	if rec.Code != code {
+		e.t.Fatalf("status = %d, want %d: %s", rec.Code, code, rec.Body)
+	}
+	if v != nil {
+		if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+			e.t.Fatal(err)
+		}
+	}
+}
@@ -2,12 +2,19 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/gorilla/mux"
+
 	. "github.com/tsushiy/codernote-backend/db"
+	"github.com/tsushiy/codernote-backend/store"
 )
 
 func loggerMiddleware(next http.Handler) http.Handler {
@@ -19,27 +26,290 @@ func loggerMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+type noteListResp struct {
+	Count    int
+	Notes    []Note
+	Snippets map[string]string `json:",omitempty"`
+}
+
+// 検索語があって order が指定されていなければスコア順にする
+func parseNoteOrder(order, text string) (store.NoteOrder, bool) {
+	if order == "" && text != "" {
+		return store.OrderRelevance, true
+	}
+	switch order {
+	case "", "-updated":
+		return store.OrderUpdated, true
+	case "-created":
+		return store.OrderCreated, true
+	case "-stars":
+		return store.OrderStars, true
+	}
+	return 0, false
+}
+
+func (s *server) writeNoteList(w http.ResponseWriter, f store.NoteFilter) {
+	notes, count, err := s.store.ListNotes(f)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to fetch note list", http.StatusInternalServerError)
+		return
+	}
+
+	var snippets map[string]string
+	if f.Text != "" {
+		var ids []string
+		for _, v := range notes {
+			ids = append(ids, v.ID)
+		}
+		if snippets, err = s.store.NoteSnippets(f.Text, ids); err != nil {
+			log.Println(err)
+			http.Error(w, "failed to fetch note list", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	resp := noteListResp{
+		Count:    count,
+		Notes:    []Note{},
+		Snippets: snippets,
+	}
+	resp.Notes = append(resp.Notes, notes...)
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(resp)
+}
+
 func (s *server) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// 一覧のフィルタとページング
+// limit を省略したときは 0 になる
+type listParams struct {
+	Text           string
+	ContestID      string
+	DifficultyKind string
+	MinDifficulty  *float64
+	MaxDifficulty  *float64
+	MinEstimate    *float64
+	MaxEstimate    *float64
+	Limit          int
+	Skip           int
+	// limit か skip が指定されている
+	Paged bool
+}
+
+const maxListQueryLen = 200
+
+func parseListParams(q url.Values) (listParams, error) {
+	p := listParams{
+		Text:           strings.TrimSpace(q.Get("q")),
+		ContestID:      q.Get("contestId"),
+		DifficultyKind: q.Get("difficultyKind"),
+	}
+	if len(p.Text) > maxListQueryLen {
+		return p, errors.New("too large query")
+	}
+	var err error
+	if p.MinDifficulty, err = parseDifficulty(q.Get("minDifficulty")); err != nil {
+		return p, err
+	}
+	if p.MaxDifficulty, err = parseDifficulty(q.Get("maxDifficulty")); err != nil {
+		return p, err
+	}
+	// DifficultyValue はジャッジごとに尺度が違うので、種類を決めないと比べられない
+	if (p.MinDifficulty != nil || p.MaxDifficulty != nil) && p.DifficultyKind == "" {
+		return p, errors.New("difficultyKind is required")
+	}
+	if p.MinEstimate, err = parseDifficulty(q.Get("minEstimate")); err != nil {
+		return p, err
+	}
+	if p.MaxEstimate, err = parseDifficulty(q.Get("maxEstimate")); err != nil {
+		return p, err
+	}
+	p.Limit, _ = strconv.Atoi(q.Get("limit"))
+	p.Skip, _ = strconv.Atoi(q.Get("skip"))
+	p.Paged = q.Get("limit") != "" || q.Get("skip") != ""
+	if p.Limit < 0 {
+		p.Limit = 0
+	}
+	if p.Skip < 0 {
+		p.Skip = 0
+	}
+	return p, nil
+}
+
+// 空なら nil を返す
+func parseDifficulty(v string) (*float64, error) {
+	if v == "" {
+		return nil, nil
+	}
+	d, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(d) || math.IsInf(d, 0) {
+		return nil, errors.New("invalid difficulty")
+	}
+	return &d, nil
+}
+
+// 後方互換のためにレスポンスは配列のままにして、件数はヘッダで返す
+func setTotalCount(w http.ResponseWriter, count int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(count))
+}
+
+const (
+	defaultProblemLimit = 100
+	maxProblemLimit     = 1000
+)
+
+// ページングするときは、問題が多いので一度に返す件数を制限する
+// limit も skip も指定されていなければ、これまでどおりすべて返す
+func problemLimit(p listParams) int {
+	if !p.Paged {
+		return 0
+	}
+	if maxProblemLimit < p.Limit {
+		return maxProblemLimit
+	} else if p.Limit <= 0 {
+		return defaultProblemLimit
+	}
+	return p.Limit
+}
+
+func parseProblemOrder(order string, f *store.ProblemFilter) bool {
+	f.Ascending = !strings.HasPrefix(order, "-")
+	switch strings.TrimPrefix(order, "-") {
+	case "":
+		f.Order = store.ProblemOrderNo
+		return order == ""
+	case "difficulty":
+		f.Order = store.ProblemOrderDifficulty
+	case "estimate":
+		f.Order = store.ProblemOrderEstimate
+	case "title":
+		f.Order = store.ProblemOrderTitle
+	case "started":
+		f.Order = store.ProblemOrderStarted
+	default:
+		return false
+	}
+	return true
+}
+
 func (s *server) problemsGetHandler(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 	domain := q.Get("domain")
 
-	var problems []Problem
-	if err := s.db.
-		Where(Problem{
-			Domain: domain,
-		}).
-		Find(&problems).Error; err != nil {
+	params, err := parseListParams(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f := store.ProblemFilter{
+		Domain:         domain,
+		ContestID:      params.ContestID,
+		Text:           params.Text,
+		DifficultyKind: params.DifficultyKind,
+		MinDifficulty:  params.MinDifficulty,
+		MaxDifficulty:  params.MaxDifficulty,
+		MinEstimate:    params.MinEstimate,
+		MaxEstimate:    params.MaxEstimate,
+		Limit:          problemLimit(params),
+		Skip:           params.Skip,
+	}
+	if !parseProblemOrder(q.Get("order"), &f) {
+		http.Error(w, "invalid sort order", http.StatusBadRequest)
+		return
+	}
+
+	problems, count, err := s.store.ListProblems(f)
+	if err != nil {
 		log.Println(err)
 		http.Error(w, "failed to get problems", http.StatusInternalServerError)
 		return
 	}
+	setTotalCount(w, count)
+
+	uid, ok := r.Context().Value(uidKey).(string)
+	if !ok {
+		resp := []Problem{}
+		resp = append(resp, problems...)
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	statuses, err := s.submissionStatuses(uid, domain)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to get submission status", http.StatusInternalServerError)
+		return
+	}
+
+	type problemResp struct {
+		Problem
+		Status string
+	}
+	resp := []problemResp{}
+	for _, v := range problems {
+		status, ok := statuses[v.No]
+		if !ok {
+			status = statusUnsolved
+		}
+		resp = append(resp, problemResp{
+			Problem: v,
+			Status:  status,
+		})
+	}
 
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	json.NewEncoder(w).Encode(problems)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *server) problemResolveGetHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	filter, ok := parseProblemURL(q.Get("url"))
+	if !ok {
+		http.Error(w, "unsupported url", http.StatusBadRequest)
+		return
+	}
+	// AOJでは同じ問題が複数のカテゴリやコースに含まれるので、最初の1件を返す
+	problems, err := s.store.FindProblems(filter, 1)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to get problems", http.StatusInternalServerError)
+		return
+	}
+	if len(problems) == 0 {
+		http.Error(w, "no problem matched", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(problems[0])
+}
+
+// 省略すると、これまでどおり問題が公開されている終了したコンテストのみにする
+// "all" ならすべてのコンテスト
+func parseContestStatuses(q url.Values) ([]string, error) {
+	statuses := parseList(q, "status")
+	if len(statuses) == 0 {
+		return []string{ContestFinished}, nil
+	}
+	for _, v := range statuses {
+		switch v {
+		case "all":
+			if len(statuses) != 1 {
+				return nil, errors.New("invalid status")
+			}
+			return nil, nil
+		case ContestUpcoming, ContestRunning, ContestFinished:
+		default:
+			return nil, errors.New("invalid status")
+		}
+	}
+	return statuses, nil
 }
 
 func (s *server) contestsGetHandler(w http.ResponseWriter, r *http.Request) {
@@ -47,31 +317,208 @@ func (s *server) contestsGetHandler(w http.ResponseWriter, r *http.Request) {
 	domain := q.Get("domain")
 	order := q.Get("order")
 
-	if order == "" || order == "-started" {
-		order = "start_time_seconds desc"
-	} else if order == "started" {
-		order = "start_time_seconds asc"
-	} else {
+	params, err := parseListParams(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f := store.ContestFilter{
+		ContestID:      params.ContestID,
+		Text:           params.Text,
+		DifficultyKind: params.DifficultyKind,
+		MinDifficulty:  params.MinDifficulty,
+		MaxDifficulty:  params.MaxDifficulty,
+		MinEstimate:    params.MinEstimate,
+		MaxEstimate:    params.MaxEstimate,
+		Limit:          params.Limit,
+		Skip:           params.Skip,
+	}
+	if domain != "" {
+		f.Domains = []string{domain}
+	}
+	if f.Statuses, err = parseContestStatuses(q); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch order {
+	case "", "-started":
+		f.Order, f.Ascending = store.ContestOrderStarted, false
+	case "started":
+		f.Order, f.Ascending = store.ContestOrderStarted, true
+	case "title":
+		f.Order, f.Ascending = store.ContestOrderTitle, true
+	case "-title":
+		f.Order, f.Ascending = store.ContestOrderTitle, false
+	default:
 		http.Error(w, "invalid sort order", http.StatusBadRequest)
 		return
 	}
 
-	var contests []Contest
-	if err := s.db.
-		Order(order).
-		Where(Contest{
-			Domain: domain,
-		}).
-		Find(&contests).Error; err != nil {
+	contests, count, err := s.store.ListContests(f)
+	if err != nil {
 		log.Println(err)
 		http.Error(w, "failed to get contests", http.StatusInternalServerError)
 		return
 	}
+	setTotalCount(w, count)
+	resp := []Contest{}
+	resp = append(resp, contests...)
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// 過去のコンテストもしばらくはカレンダーに残しておく
+const icalPastWindow = 7 * 24 * time.Hour
+
+func (s *server) upcomingContestsGetHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	domains := parseDomains(q)
+
+	now := time.Now()
+	res, _, err := s.store.ListContests(store.ContestFilter{
+		Domains:   domains,
+		EndAfter:  now.Unix(),
+		Ascending: true,
+	})
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to get contests", http.StatusInternalServerError)
+		return
+	}
+	contests := []Contest{}
+	contests = append(contests, res...)
+
+	// クロールの間隔があるので、保存されている状態ではなく現在時刻から判定する
+	for i, v := range contests {
+		if now.Unix() < int64(v.StartTimeSeconds) {
+			contests[i].Status = ContestUpcoming
+		} else {
+			contests[i].Status = ContestRunning
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	json.NewEncoder(w).Encode(contests)
 }
 
+func (s *server) contestsICalGetHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	domains := parseDomains(q)
+
+	contests, _, err := s.store.ListContests(store.ContestFilter{
+		Domains:   domains,
+		EndAfter:  time.Now().Add(-icalPastWindow).Unix(),
+		Ascending: true,
+	})
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to get contests", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=UTF-8")
+	if err := writeContestsICal(w, contests); err != nil {
+		log.Println(err)
+	}
+}
+
+// domain=atcoder&domain=codeforces と domain=atcoder,codeforces のどちらでも指定できる
+func parseDomains(q url.Values) []string {
+	return parseList(q, "domain")
+}
+
+// key=a&key=b と key=a,b のどちらでも指定できる
+func parseList(q url.Values, key string) []string {
+	var list []string
+	for _, v := range q[key] {
+		for _, d := range strings.Split(v, ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				list = append(list, d)
+			}
+		}
+	}
+	return list
+}
+
+const maxFilterTags = 10
+
+// tags, tagMode, excludeTags と、1つだけ指定する従来の tag を読む
+func parseTagFilter(q url.Values, f *store.NoteFilter) error {
+	f.Tags = parseList(q, "tags")
+	if tag := strings.TrimSpace(q.Get("tag")); tag != "" {
+		f.Tags = append(f.Tags, tag)
+	}
+	f.ExcludeTags = parseList(q, "excludeTags")
+	if len(f.Tags) > maxFilterTags || len(f.ExcludeTags) > maxFilterTags {
+		return errors.New("too many tags")
+	}
+
+	switch q.Get("tagMode") {
+	case "", "all":
+		f.AnyTag = false
+	case "any":
+		f.AnyTag = true
+	default:
+		return errors.New("invalid tag mode")
+	}
+	return nil
+}
+
+const (
+	defaultTagLimit = 20
+	maxTagLimit     = 100
+)
+
+type tagListResp struct {
+	Tags []store.TagCount
+}
+
+func parseTagLimit(q url.Values) int {
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if maxTagLimit < limit {
+		limit = maxTagLimit
+	} else if limit <= 0 {
+		limit = defaultTagLimit
+	}
+	return limit
+}
+
+func writeTagList(w http.ResponseWriter, tags []store.TagCount) {
+	resp := tagListResp{Tags: []store.TagCount{}}
+	resp.Tags = append(resp.Tags, tags...)
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *server) tagsGetHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix := strings.TrimSpace(q.Get("prefix"))
+	if len(prefix) > 200 {
+		http.Error(w, "too large prefix", http.StatusBadRequest)
+		return
+	}
+
+	tags, err := s.store.SearchTags(prefix, parseTagLimit(q))
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to get tags", http.StatusInternalServerError)
+		return
+	}
+	writeTagList(w, tags)
+}
+
+func (s *server) popularTagsGetHandler(w http.ResponseWriter, r *http.Request) {
+	tags, err := s.store.PopularTags(parseTagLimit(r.URL.Query()))
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to get tags", http.StatusInternalServerError)
+		return
+	}
+	writeTagList(w, tags)
+}
+
 func (s *server) publicNoteGetHandler(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 
@@ -81,13 +528,8 @@ func (s *server) publicNoteGetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	nfilter := Note{ID: noteID}
-	var note Note
-	if err := s.db.
-		Preload("User").
-		Preload("Problem").
-		Where(&nfilter).
-		Take(&note).Error; err != nil {
+	note, err := s.store.GetNote(noteID)
+	if err != nil {
 		http.Error(w, "note not found", http.StatusNotFound)
 		return
 	}
@@ -106,8 +548,8 @@ func (s *server) publicNoteListGetHandler(w http.ResponseWriter, r *http.Request
 	domain := q.Get("domain")
 	problemNo, _ := strconv.Atoi(q.Get("problemNo"))
 	contestID := q.Get("contestId")
-	tag := q.Get("tag")
 	userName := q.Get("userName")
+	text := strings.TrimSpace(q.Get("q"))
 	limit, _ := strconv.Atoi(q.Get("limit"))
 	skip, _ := strconv.Atoi(q.Get("skip"))
 	order := q.Get("order")
@@ -118,99 +560,153 @@ func (s *server) publicNoteListGetHandler(w http.ResponseWriter, r *http.Request
 		limit = 100
 	}
 
-	if order == "" || order == "-updated" {
-		order = "updated_at desc"
-	} else {
+	noteOrder, ok := parseNoteOrder(order, text)
+	if !ok {
 		http.Error(w, "invalid sort order", http.StatusBadRequest)
 		return
 	}
 
-	pfilter := Problem{
-		Domain:    domain,
-		No:        problemNo,
-		ContestID: contestID,
+	f := store.NoteFilter{
+		Domain:     domain,
+		ProblemNo:  problemNo,
+		ContestID:  contestID,
+		UserName:   userName,
+		Text:       text,
+		PublicOnly: true,
+		Limit:      limit,
+		Skip:       skip,
+		Order:      noteOrder,
+	}
+	if err := parseTagFilter(q, &f); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	ufilter := User{Name: userName}
-	tfilter := Tag{Key: tag}
-	nfilter := Note{Public: 2}
 
-	// ここ綺麗に書きたい
-	count := 0
-	if tag == "" {
-		if err := s.db.
-			Model(&Note{}).
-			Joins("left join problems on problems.no = notes.problem_no").
-			Joins("left join users on users.no = notes.user_no").
-			Where(&pfilter).
-			Where(&ufilter).
-			Where(&nfilter).
-			Count(&count).Error; err != nil {
-			log.Println(err)
-			http.Error(w, "failed to count", http.StatusInternalServerError)
-			return
-		}
-	} else {
-		if err := s.db.
-			Model(&Note{}).
-			Joins("left join problems on problems.no = notes.problem_no").
-			Joins("left join users on users.no = notes.user_no").
-			Joins("left join tag_maps on tag_maps.note_id = notes.id").
-			Joins("left join tags on tags.no = tag_maps.tag_no").
-			Where(&pfilter).
-			Where(&ufilter).
-			Where(&tfilter).
-			Where(&nfilter).
-			Count(&count).Error; err != nil {
-			log.Println(err)
-			http.Error(w, "failed to count", http.StatusInternalServerError)
-			return
-		}
+	s.writeNoteList(w, f)
+}
+
+type noteCommentsResp struct {
+	CommentsDisabled bool
+	Comments         []NoteComment
+}
+
+func (s *server) noteCommentsGetHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	note, err := s.store.GetNote(vars["noteID"])
+	if err != nil || note.Public != 2 {
+		http.Error(w, "note not found", http.StatusNotFound)
+		return
 	}
 
-	var notes []Note
-	if tag == "" {
-		if err := s.db.
-			Limit(limit).Offset(skip).Order(order).
-			Preload("User").
-			Preload("Problem").
-			Joins("left join problems on problems.no = notes.problem_no").
-			Joins("left join users on users.no = notes.user_no").
-			Where(&pfilter).
-			Where(&ufilter).
-			Where(&nfilter).
-			Find(&notes).Error; err != nil {
-			log.Println(err)
-			http.Error(w, "failed to fetch note list", http.StatusInternalServerError)
-			return
+	comments, err := s.store.ListNoteComments(note.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to get comments", http.StatusInternalServerError)
+		return
+	}
+
+	resp := noteCommentsResp{
+		CommentsDisabled: note.CommentsDisabled,
+		Comments:         commentTree(comments, 0),
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// 作成日時の古い順に並んだコメントから、parentNo への返信をツリーにして返す
+// parentNo が0ならノートへのコメント
+func commentTree(comments []NoteComment, parentNo int) []NoteComment {
+	tree := []NoteComment{}
+	for _, v := range comments {
+		no := 0
+		if v.ParentNo != nil {
+			no = *v.ParentNo
 		}
-	} else {
-		if err := s.db.
-			Limit(limit).Offset(skip).Order(order).
-			Preload("User").
-			Preload("Problem").
-			Joins("left join problems on problems.no = notes.problem_no").
-			Joins("left join users on users.no = notes.user_no").
-			Joins("left join tag_maps on tag_maps.note_id = notes.id").
-			Joins("left join tags on tags.no = tag_maps.tag_no").
-			Where(&pfilter).
-			Where(&ufilter).
-			Where(&tfilter).
-			Where(&nfilter).
-			Find(&notes).Error; err != nil {
-			log.Println(err)
-			http.Error(w, "failed to fetch note list", http.StatusInternalServerError)
-			return
+		if no != parentNo {
+			continue
 		}
+		v.Replies = commentTree(comments, v.No)
+		tree = append(tree, v)
 	}
+	return tree
+}
+
+const (
+	profileTagLimit  = 10
+	profileNoteLimit = 5
+)
 
-	type noteListResp struct {
-		Count int
-		Notes []Note
+type judgeIDs struct {
+	AtCoderID    string
+	CodeforcesID string
+	YukicoderID  string
+	AOJID        string
+	LeetCodeID   string
+}
+
+type userProfileResp struct {
+	Name      string
+	CreatedAt time.Time
+	// ユーザーが公開している場合のみ
+	JudgeIDs    *judgeIDs `json:",omitempty"`
+	NoteCount   int
+	Domains     []store.DomainCount
+	Tags        []store.TagCount
+	RecentNotes []Note
+}
+
+func (s *server) userProfileGetHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	user, err := s.store.GetUserByName(vars["userName"])
+	if err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
 	}
-	resp := noteListResp{
-		Count: count,
-		Notes: notes,
+
+	detail, err := s.store.GetUserDetail(user.UserID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to get user", http.StatusInternalServerError)
+		return
+	}
+	stats, err := s.store.UserNoteStats(user.No, profileTagLimit)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to get user", http.StatusInternalServerError)
+		return
+	}
+	notes, _, err := s.store.ListNotes(store.NoteFilter{
+		UserID:     user.UserID,
+		PublicOnly: true,
+		Limit:      profileNoteLimit,
+	})
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "failed to get user", http.StatusInternalServerError)
+		return
+	}
+
+	resp := userProfileResp{
+		Name:        user.Name,
+		CreatedAt:   user.CreatedAt,
+		NoteCount:   stats.NoteCount,
+		Domains:     []store.DomainCount{},
+		Tags:        []store.TagCount{},
+		RecentNotes: []Note{},
+	}
+	if detail.PublicJudgeIDs {
+		resp.JudgeIDs = &judgeIDs{
+			AtCoderID:    detail.AtCoderID,
+			CodeforcesID: detail.CodeforcesID,
+			YukicoderID:  detail.YukicoderID,
+			AOJID:        detail.AOJID,
+			LeetCodeID:   detail.LeetCodeID,
+		}
 	}
+	resp.Domains = append(resp.Domains, stats.Domains...)
+	resp.Tags = append(resp.Tags, stats.Tags...)
+	resp.RecentNotes = append(resp.RecentNotes, notes...)
 
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	json.NewEncoder(w).Encode(resp)
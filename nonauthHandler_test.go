@@ -0,0 +1,460 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/tsushiy/codernote-backend/db"
+)
+
+func TestHealthcheck(t *testing.T) {
+	e := newTestEnv(t)
+	e.expect(e.do("GET", "/healthcheck", "", nil), http.StatusOK, nil)
+}
+
+func TestProblems(t *testing.T) {
+	e := newTestEnv(t)
+	p1 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_a"})
+	p2 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_b"})
+	p3 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_c"})
+	e.store.AddProblem(Problem{Domain: "codeforces", ContestID: "1", ProblemID: "A"})
+	e.store.AddSubmission(Submission{UserID: "alice", Domain: "atcoder", ProblemNo: p1.No, Result: "AC"})
+	e.store.AddSubmission(Submission{UserID: "alice", Domain: "atcoder", ProblemNo: p2.No, Result: "WA"})
+
+	var problems []Problem
+	e.expect(e.do("GET", "/problems?domain=atcoder", "", nil), http.StatusOK, &problems)
+	if len(problems) != 3 {
+		t.Errorf("len(problems) = %d, want 3", len(problems))
+	}
+
+	var resp []struct {
+		Problem
+		Status string
+	}
+	e.expect(e.do("GET", "/problems?domain=atcoder", e.token("alice"), nil), http.StatusOK, &resp)
+	want := map[int]string{p1.No: statusAccepted, p2.No: statusWrong, p3.No: statusUnsolved}
+	for _, v := range resp {
+		if v.Status != want[v.No] {
+			t.Errorf("problem %d: Status = %q, want %q", v.No, v.Status, want[v.No])
+		}
+	}
+
+	e.expect(e.do("GET", "/problems", "invalid", nil), http.StatusUnauthorized, nil)
+}
+
+func TestProblemLimit(t *testing.T) {
+	e := newTestEnv(t)
+	n := maxProblemLimit + 5
+	for i := 0; i < n; i++ {
+		e.store.AddProblem(Problem{Domain: "atcoder", ContestID: "abc001", ProblemID: fmt.Sprintf("abc001_%d", i)})
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		// ページングしなければ、これまでどおりすべて返す
+		{"", n},
+		{"&skip=0", defaultProblemLimit},
+		{"&limit=0", defaultProblemLimit},
+		{"&limit=-1", defaultProblemLimit},
+		{"&limit=10", 10},
+		{"&limit=100000", maxProblemLimit},
+		{fmt.Sprintf("&limit=%d&skip=%d", maxProblemLimit, maxProblemLimit), 5},
+	}
+	for _, tt := range tests {
+		var problems []Problem
+		rec := e.do("GET", "/problems?domain=atcoder"+tt.query, "", nil)
+		e.expect(rec, http.StatusOK, &problems)
+		if len(problems) != tt.want {
+			t.Errorf("%s: len(problems) = %d, want %d", tt.query, len(problems), tt.want)
+		}
+		if total := rec.Header().Get("X-Total-Count"); total != fmt.Sprint(n) {
+			t.Errorf("%s: X-Total-Count = %s, want %d", tt.query, total, n)
+		}
+	}
+}
+
+func float64Ptr(v float64) *float64 {
+	return &v
+}
+
+// atcoderProblem は難易度を設定したAtCoderの問題を返す
+func atcoderProblem(p Problem, difficulty float64) Problem {
+	p.Domain = "atcoder"
+	p.Difficulty = fmt.Sprint(int(difficulty))
+	p.DifficultyValue = float64Ptr(difficulty)
+	p.DifficultyKind = DifficultyAtCoderIRT
+	p.DifficultyEstimate = float64Ptr(difficulty)
+	return p
+}
+
+func TestProblemFilter(t *testing.T) {
+	e := newTestEnv(t)
+	e.store.AddContest(Contest{Domain: "atcoder", ContestID: "abc001", StartTimeSeconds: 200})
+	e.store.AddContest(Contest{Domain: "atcoder", ContestID: "abc002", StartTimeSeconds: 100})
+	p1 := e.store.AddProblem(atcoderProblem(Problem{ContestID: "abc001", ProblemID: "abc001_a", Title: "A. Sum"}, 400))
+	p2 := e.store.AddProblem(atcoderProblem(Problem{ContestID: "abc001", ProblemID: "abc001_b", Title: "B. Graph"}, 1200.5))
+	p3 := e.store.AddProblem(Problem{Domain: "atcoder", ContestID: "abc002", ProblemID: "abc002_a", Title: "A. Big sum", Difficulty: "-"})
+	p4 := e.store.AddProblem(atcoderProblem(Problem{ContestID: "arc001", ProblemID: "arc001_a", Title: "A. 100%"}, 800))
+	cf := e.store.AddProblem(Problem{Domain: "codeforces", ContestID: "1", ProblemID: "A", Title: "Sum", Difficulty: "800",
+		DifficultyValue: float64Ptr(800), DifficultyKind: DifficultyCodeforcesRating, DifficultyEstimate: float64Ptr(200)})
+
+	tests := []struct {
+		query string
+		want  []int
+		count int
+	}{
+		{"", []int{p1.No, p2.No, p3.No, p4.No}, 4},
+		{"&q=SUM", []int{p1.No, p3.No}, 2},
+		{"&q=100%25", []int{p4.No}, 1},
+		{"&contestId=abc001", []int{p1.No, p2.No}, 2},
+		{"&difficultyKind=atcoder-irt&minDifficulty=500", []int{p2.No, p4.No}, 2},
+		{"&difficultyKind=atcoder-irt&minDifficulty=400&maxDifficulty=800", []int{p1.No, p4.No}, 2},
+		{"&order=-difficulty", []int{p2.No, p4.No, p1.No, p3.No}, 4},
+		{"&order=difficulty", []int{p1.No, p4.No, p2.No, p3.No}, 4},
+		{"&order=title", []int{p4.No, p3.No, p1.No, p2.No}, 4},
+		{"&order=started", []int{p3.No, p1.No, p2.No, p4.No}, 4},
+		{"&order=-started", []int{p1.No, p2.No, p3.No, p4.No}, 4},
+		{"&order=-difficulty&limit=2", []int{p2.No, p4.No}, 4},
+		{"&order=-difficulty&limit=2&skip=2", []int{p1.No, p3.No}, 4},
+		{"&skip=10", nil, 4},
+	}
+	for _, tt := range tests {
+		var problems []Problem
+		rec := e.do("GET", "/problems?domain=atcoder"+tt.query, "", nil)
+		e.expect(rec, http.StatusOK, &problems)
+		var got []int
+		for _, v := range problems {
+			got = append(got, v.No)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: problems = %v, want %v", tt.query, got, tt.want)
+		}
+		if total := rec.Header().Get("X-Total-Count"); total != fmt.Sprint(tt.count) {
+			t.Errorf("%s: X-Total-Count = %s, want %d", tt.query, total, tt.count)
+		}
+	}
+
+	// 推定値はジャッジをまたいで比べられる
+	tests = []struct {
+		query string
+		want  []int
+		count int
+	}{
+		{"?order=-estimate", []int{p2.No, p4.No, p1.No, cf.No, p3.No}, 5},
+		{"?minEstimate=300&maxEstimate=1000&order=estimate", []int{p1.No, p4.No}, 2},
+		{"?difficultyKind=atcoder-irt&minDifficulty=800&maxDifficulty=800", []int{p4.No}, 1},
+		{"?difficultyKind=codeforces-rating&minDifficulty=800&maxDifficulty=800", []int{cf.No}, 1},
+		{"?difficultyKind=codeforces-rating", []int{cf.No}, 1},
+	}
+	for _, tt := range tests {
+		var problems []Problem
+		e.expect(e.do("GET", "/problems"+tt.query, "", nil), http.StatusOK, &problems)
+		var got []int
+		for _, v := range problems {
+			got = append(got, v.No)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: problems = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	e.expect(e.do("GET", "/problems?order=rating", "", nil), http.StatusBadRequest, nil)
+	e.expect(e.do("GET", "/problems?maxEstimate=NaN", "", nil), http.StatusBadRequest, nil)
+	e.expect(e.do("GET", "/problems?order=-", "", nil), http.StatusBadRequest, nil)
+	e.expect(e.do("GET", "/problems?difficultyKind=atcoder-irt&minDifficulty=high", "", nil), http.StatusBadRequest, nil)
+	// 種類を指定しないと、尺度の違う難易度を比べてしまう
+	e.expect(e.do("GET", "/problems?minDifficulty=800", "", nil), http.StatusBadRequest, nil)
+	e.expect(e.do("GET", "/problems?maxDifficulty=800", "", nil), http.StatusBadRequest, nil)
+	e.expect(e.do("GET", "/problems?q="+strings.Repeat("a", 201), "", nil), http.StatusBadRequest, nil)
+}
+
+func TestContests(t *testing.T) {
+	e := newTestEnv(t)
+	c1 := e.store.AddContest(Contest{Domain: "atcoder", ContestID: "abc001", Title: "Beginner Contest 001", StartTimeSeconds: 100, Status: ContestFinished})
+	c2 := e.store.AddContest(Contest{Domain: "atcoder", ContestID: "abc002", Title: "Beginner Contest 002", StartTimeSeconds: 200, Status: ContestFinished})
+	c3 := e.store.AddContest(Contest{Domain: "atcoder", ContestID: "arc001", Title: "Regular Contest 001", StartTimeSeconds: 50, Status: ContestFinished})
+	e.store.AddContest(Contest{Domain: "codeforces", ContestID: "1", StartTimeSeconds: 150, Status: ContestFinished})
+	c5 := e.store.AddContest(Contest{Domain: "atcoder", ContestID: "abc003", Title: "Beginner Contest 003", StartTimeSeconds: 300, Status: ContestUpcoming})
+	e.store.AddProblem(atcoderProblem(Problem{ContestID: "abc001", ProblemID: "abc001_a"}, 100))
+	e.store.AddProblem(Problem{Domain: "atcoder", ContestID: "abc002", ProblemID: "abc002_a", Difficulty: "-"})
+	e.store.AddProblem(atcoderProblem(Problem{ContestID: "arc001", ProblemID: "arc001_a"}, 1600))
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"?domain=atcoder", []int{c2.No, c1.No, c3.No}},
+		{"?domain=atcoder&order=started", []int{c3.No, c1.No, c2.No}},
+		{"?domain=atcoder&order=-title", []int{c3.No, c2.No, c1.No}},
+		{"?domain=atcoder&q=beginner", []int{c2.No, c1.No}},
+		{"?domain=atcoder&contestId=abc001", []int{c1.No}},
+		{"?domain=atcoder&difficultyKind=atcoder-irt&minDifficulty=1000", []int{c3.No}},
+		{"?domain=atcoder&difficultyKind=atcoder-irt&maxDifficulty=1000", []int{c1.No}},
+		{"?domain=atcoder&difficultyKind=codeforces-rating&maxDifficulty=1000", nil},
+		{"?domain=atcoder&minEstimate=50&maxEstimate=200", []int{c1.No}},
+		{"?domain=atcoder&limit=1&skip=1", []int{c1.No}},
+		{"?domain=atcoder&status=upcoming", []int{c5.No}},
+		{"?domain=atcoder&status=upcoming,finished&q=beginner", []int{c5.No, c2.No, c1.No}},
+		{"?domain=atcoder&status=all&order=started", []int{c3.No, c1.No, c2.No, c5.No}},
+	}
+	for _, tt := range tests {
+		var contests []Contest
+		e.expect(e.do("GET", "/contests"+tt.query, "", nil), http.StatusOK, &contests)
+		var got []int
+		for _, v := range contests {
+			got = append(got, v.No)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: contests = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	rec := e.do("GET", "/contests?domain=atcoder&limit=1", "", nil)
+	e.expect(rec, http.StatusOK, nil)
+	if total := rec.Header().Get("X-Total-Count"); total != "3" {
+		t.Errorf("X-Total-Count = %s, want 3", total)
+	}
+
+	e.expect(e.do("GET", "/contests?order=difficulty", "", nil), http.StatusBadRequest, nil)
+	e.expect(e.do("GET", "/contests?status=started", "", nil), http.StatusBadRequest, nil)
+	e.expect(e.do("GET", "/contests?status=all,upcoming", "", nil), http.StatusBadRequest, nil)
+	e.expect(e.do("GET", "/contests?minDifficulty=1000", "", nil), http.StatusBadRequest, nil)
+}
+
+func TestUpcomingContests(t *testing.T) {
+	e := newTestEnv(t)
+	now := int(time.Now().Unix())
+	e.store.AddContest(Contest{Domain: "atcoder", ContestID: "past", StartTimeSeconds: now - 7200, DurationSeconds: 3600})
+	running := e.store.AddContest(Contest{Domain: "atcoder", ContestID: "running", StartTimeSeconds: now - 600, DurationSeconds: 3600, Status: ContestUpcoming})
+	upcoming := e.store.AddContest(Contest{Domain: "codeforces", ContestID: "upcoming", StartTimeSeconds: now + 3600, DurationSeconds: 7200})
+	e.store.AddContest(Contest{Domain: "yukicoder", ContestID: "later", StartTimeSeconds: now + 7200, DurationSeconds: 7200})
+
+	var contests []Contest
+	e.expect(e.do("GET", "/contests/upcoming?domain=atcoder,codeforces", "", nil), http.StatusOK, &contests)
+	if len(contests) != 2 || contests[0].No != running.No || contests[1].No != upcoming.No {
+		t.Fatalf("unexpected contests: %+v", contests)
+	}
+	if contests[0].Status != ContestRunning || contests[1].Status != ContestUpcoming {
+		t.Errorf("unexpected status: %s, %s", contests[0].Status, contests[1].Status)
+	}
+
+	e.expect(e.do("GET", "/contests/upcoming", "", nil), http.StatusOK, &contests)
+	if len(contests) != 3 {
+		t.Errorf("len(contests) = %d, want 3", len(contests))
+	}
+}
+
+func TestContestsICal(t *testing.T) {
+	e := newTestEnv(t)
+	now := int(time.Now().Unix())
+	e.store.AddContest(Contest{Domain: "atcoder", ContestID: "abc001", Title: "Old Contest", StartTimeSeconds: now - 30*24*3600, DurationSeconds: 3600})
+	e.store.AddContest(Contest{Domain: "atcoder", ContestID: "abc100", Title: "Recent Contest", StartTimeSeconds: now - 24*3600, DurationSeconds: 3600})
+	e.store.AddContest(Contest{Domain: "codeforces", ContestID: "2000", Title: "Next Round", StartTimeSeconds: now + 3600, DurationSeconds: 7200})
+
+	rec := e.do("GET", "/contests.ics?domain=atcoder", "", nil)
+	e.expect(rec, http.StatusOK, nil)
+	body := rec.Body.String()
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/calendar") {
+		t.Errorf("Content-Type = %q", rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(body, "SUMMARY:Recent Contest") {
+		t.Error("recent contest is missing")
+	}
+	if strings.Contains(body, "Old Contest") || strings.Contains(body, "Next Round") {
+		t.Error("unexpected contest in calendar")
+	}
+}
+
+func TestPublicNoteList(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.login("alice")
+	bob := e.login("bob")
+	e.expect(e.do("POST", "/user/name", alice, map[string]string{"Name": "alice"}), http.StatusOK, nil)
+	p1 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_a"})
+	p2 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_b"})
+
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p1.No), alice, map[string]interface{}{"Text": "public", "Public": true}), http.StatusOK, nil)
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p2.No), alice, map[string]interface{}{"Text": "private"}), http.StatusOK, nil)
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p2.No), bob, map[string]interface{}{"Text": "public", "Public": true}), http.StatusOK, nil)
+
+	tests := []struct {
+		query string
+		count int
+	}{
+		{"", 2},
+		{"?userName=alice", 1},
+		{fmt.Sprintf("?problemNo=%d", p2.No), 1},
+		{"?q=private", 0},
+	}
+	for _, tt := range tests {
+		var resp noteListResp
+		e.expect(e.do("GET", "/notes"+tt.query, "", nil), http.StatusOK, &resp)
+		if resp.Count != tt.count || len(resp.Notes) != tt.count {
+			t.Errorf("%s: Count = %d, len(Notes) = %d, want %d", tt.query, resp.Count, len(resp.Notes), tt.count)
+		}
+		for _, v := range resp.Notes {
+			if v.Public != 2 {
+				t.Errorf("%s: private note %s is listed", tt.query, v.ID)
+			}
+		}
+	}
+
+	e.expect(e.do("GET", "/notes?order=updated", "", nil), http.StatusBadRequest, nil)
+}
+
+func TestTagSuggestion(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.login("alice")
+	bob := e.login("bob")
+	p1 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_a"})
+	p2 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_b"})
+
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p1.No), alice, map[string]interface{}{"Text": "a", "Public": true}), http.StatusOK, nil)
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p2.No), bob, map[string]interface{}{"Text": "b", "Public": true}), http.StatusOK, nil)
+	for _, v := range []struct {
+		token string
+		no    int
+		tag   string
+	}{
+		{alice, p1.No, "dp"},
+		{alice, p2.No, "dp"},
+		{bob, p2.No, "dp"},
+		{bob, p2.No, "DFS"},
+		{alice, p2.No, "dijkstra"},
+		{alice, p1.No, "graph"},
+	} {
+		e.expect(e.do("POST", fmt.Sprintf("/user/note/%d/tag", v.no), v.token, map[string]string{"Tag": v.tag}), http.StatusOK, nil)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/tags?prefix=d", "[{dp 3} {DFS 1} {dijkstra 1}]"},
+		{"/tags?prefix=D&limit=2", "[{dp 3} {DFS 1}]"},
+		{"/tags?prefix=x", "[]"},
+		// alice の abc001_b のノートは非公開
+		{"/tags/popular", "[{dp 2} {DFS 1} {graph 1}]"},
+	}
+	for _, tt := range tests {
+		var resp tagListResp
+		e.expect(e.do("GET", tt.path, "", nil), http.StatusOK, &resp)
+		if got := fmt.Sprint(resp.Tags); got != tt.want {
+			t.Errorf("%s: Tags = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestUserProfile(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.login("alice")
+	e.expect(e.do("POST", "/user/name", alice, map[string]string{"Name": "alice"}), http.StatusOK, nil)
+	e.expect(e.do("POST", "/user/setting", alice, map[string]string{"AtCoderID": "alice_ac"}), http.StatusOK, nil)
+	p1 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_a"})
+	p2 := e.store.AddProblem(Problem{Domain: "atcoder", ProblemID: "abc001_b"})
+	p3 := e.store.AddProblem(Problem{Domain: "codeforces", ProblemID: "1A"})
+	p4 := e.store.AddProblem(Problem{Domain: "codeforces", ProblemID: "1B"})
+
+	for _, no := range []int{p1.No, p2.No, p3.No} {
+		e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", no), alice, map[string]interface{}{"Text": "public", "Public": true}), http.StatusOK, nil)
+		e.expect(e.do("POST", fmt.Sprintf("/user/note/%d/tag", no), alice, map[string]string{"Tag": "dp"}), http.StatusOK, nil)
+	}
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d", p4.No), alice, map[string]interface{}{"Text": "private"}), http.StatusOK, nil)
+	e.expect(e.do("POST", fmt.Sprintf("/user/note/%d/tag", p4.No), alice, map[string]string{"Tag": "secret"}), http.StatusOK, nil)
+
+	var resp userProfileResp
+	e.expect(e.do("GET", "/users/alice", "", nil), http.StatusOK, &resp)
+	if resp.Name != "alice" || resp.CreatedAt.IsZero() || resp.JudgeIDs != nil {
+		t.Errorf("profile = %+v", resp)
+	}
+	if resp.NoteCount != 3 || len(resp.RecentNotes) != 3 {
+		t.Errorf("NoteCount = %d, len(RecentNotes) = %d, want 3", resp.NoteCount, len(resp.RecentNotes))
+	}
+	if got := fmt.Sprint(resp.Domains); got != "[{atcoder 2} {codeforces 1}]" {
+		t.Errorf("Domains = %s", got)
+	}
+	if got := fmt.Sprint(resp.Tags); got != "[{dp 3}]" {
+		t.Errorf("Tags = %s", got)
+	}
+
+	e.expect(e.do("POST", "/user/setting", alice, map[string]interface{}{"AtCoderID": "alice_ac", "PublicJudgeIDs": true}), http.StatusOK, nil)
+	resp = userProfileResp{}
+	e.expect(e.do("GET", "/users/alice", "", nil), http.StatusOK, &resp)
+	if resp.JudgeIDs == nil || resp.JudgeIDs.AtCoderID != "alice_ac" {
+		t.Errorf("JudgeIDs = %+v, want AtCoderID alice_ac", resp.JudgeIDs)
+	}
+
+	e.expect(e.do("GET", "/users/nobody", "", nil), http.StatusNotFound, nil)
+}
+
+func TestResolveProblem(t *testing.T) {
+	e := newTestEnv(t)
+	atcoder := e.store.AddProblem(Problem{Domain: "atcoder", ContestID: "abc001", ProblemID: "abc001_a"})
+	shared := e.store.AddProblem(Problem{Domain: "atcoder", ContestID: "arc058", ProblemID: "arc058_a"})
+	cf := e.store.AddProblem(Problem{Domain: "codeforces", ContestID: "1", ProblemID: "A"})
+	gym := e.store.AddProblem(Problem{Domain: "codeforces-gym", ContestID: "100001", ProblemID: "B"})
+	yuki := e.store.AddProblem(Problem{Domain: "yukicoder", ProblemID: "1234", FrontendID: "1"})
+	aoj := e.store.AddProblem(Problem{Domain: "aoj", ContestID: "ITP1", ProblemID: "ITP1_1_A"})
+	leet := e.store.AddProblem(Problem{Domain: "leetcode", ContestID: "algorithms", ProblemID: "1", Slug: "two-sum"})
+
+	tests := []struct {
+		url  string
+		want int
+	}{
+		{"https://atcoder.jp/contests/abc001/tasks/abc001_a", atcoder.No},
+		{"https://atcoder.jp/contests/abc001/tasks/abc001_a?lang=en", atcoder.No},
+		// ARCと同時開催のABCでは、ABCのURLからARCの問題を開く
+		{"https://atcoder.jp/contests/abc042/tasks/arc058_a", shared.No},
+		{"https://atcoder.jp/contests/arc058/tasks/arc058_a", shared.No},
+		{"https://codeforces.com/contest/1/problem/A", cf.No},
+		{"https://codeforces.com/problemset/problem/1/a/", cf.No},
+		{"http://www.codeforces.com/gym/100001/problem/B", gym.No},
+		{"https://yukicoder.me/problems/no/1", yuki.No},
+		{"https://yukicoder.me/problems/1234", yuki.No},
+		{"https://onlinejudge.u-aizu.ac.jp/problems/ITP1_1_A", aoj.No},
+		{"https://onlinejudge.u-aizu.ac.jp/courses/lesson/2/ITP1/1/ITP1_1_A", aoj.No},
+		{"http://judge.u-aizu.ac.jp/onlinejudge/description.jsp?id=ITP1_1_A&lang=jp", aoj.No},
+		{"https://leetcode.com/problems/two-sum/description/", 0},
+		{"https://leetcode.com/problems/two-sum/", leet.No},
+	}
+	for _, tt := range tests {
+		if tt.want == 0 {
+			e.expect(e.do("GET", "/problems/resolve?url="+url.QueryEscape(tt.url), "", nil), http.StatusBadRequest, nil)
+			continue
+		}
+		var p Problem
+		e.expect(e.do("GET", "/problems/resolve?url="+url.QueryEscape(tt.url), "", nil), http.StatusOK, &p)
+		if p.No != tt.want {
+			t.Errorf("%s: No = %d, want %d", tt.url, p.No, tt.want)
+		}
+	}
+
+	// 正規のURLから同じ問題に戻れる
+	for _, v := range []Problem{atcoder, cf, gym, yuki, aoj, leet} {
+		if v.URL == "" {
+			t.Errorf("problem %d: URL is empty", v.No)
+			continue
+		}
+		var p Problem
+		e.expect(e.do("GET", "/problems/resolve?url="+url.QueryEscape(v.URL), "", nil), http.StatusOK, &p)
+		if p.No != v.No || p.URL != v.URL {
+			t.Errorf("%s: problem = %+v", v.URL, p)
+		}
+	}
+
+	e.expect(e.do("GET", "/problems/resolve?url="+url.QueryEscape("https://atcoder.jp/contests/abc002/tasks/abc002_a"), "", nil), http.StatusNotFound, nil)
+	e.expect(e.do("GET", "/problems/resolve?url="+url.QueryEscape("https://example.com/problems/1"), "", nil), http.StatusBadRequest, nil)
+	e.expect(e.do("GET", "/problems/resolve", "", nil), http.StatusBadRequest, nil)
+
+	var problems []Problem
+	e.expect(e.do("GET", "/problems?domain=atcoder", "", nil), http.StatusOK, &problems)
+	if len(problems) != 2 || problems[0].URL != "https://atcoder.jp/contests/abc001/tasks/abc001_a" {
+		t.Errorf("problems = %+v", problems)
+	}
+}
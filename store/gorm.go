@@ -0,0 +1,1190 @@
+package store
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	. "github.com/tsushiy/codernote-backend/db"
+)
+
+// notes.text と problems.title にはそれぞれ to_tsvector('simple', ...) のGINインデックスを張っている
+const (
+	noteSearchCond   = "(to_tsvector('simple', notes.text) @@ plainto_tsquery('simple', ?) or to_tsvector('simple', problems.title) @@ plainto_tsquery('simple', ?))"
+	noteSearchRank   = "ts_rank(setweight(to_tsvector('simple', problems.title), 'A') || to_tsvector('simple', notes.text), plainto_tsquery('simple', ?)) desc, notes.updated_at desc"
+	noteSnippetQuery = "select id, ts_headline('simple', text, plainto_tsquery('simple', ?), 'MaxFragments=2, MinWords=10, MaxWords=30') as snippet from notes where id in (?)"
+)
+
+// difficultyRangeCond は problems の難易度の範囲の条件と、その引数を返す
+func difficultyRangeCond(minValue, maxValue, minEstimate, maxEstimate *float64) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	add := func(cond string, v *float64) {
+		if v != nil {
+			conds = append(conds, cond)
+			args = append(args, *v)
+		}
+	}
+	add("problems.difficulty_value >= ?", minValue)
+	add("problems.difficulty_value <= ?", maxValue)
+	add("problems.difficulty_estimate >= ?", minEstimate)
+	add("problems.difficulty_estimate <= ?", maxEstimate)
+	return strings.Join(conds, " and "), args
+}
+
+const noteTagQuery = "select 1 from tag_maps inner join tags on tags.no = tag_maps.tag_no where tag_maps.note_id = notes.id and tags.key in (?)"
+
+const resultAccepted = "AC"
+
+type GormStore struct {
+	db *gorm.DB
+}
+
+func NewGormStore(db *gorm.DB) *GormStore {
+	return &GormStore{db: db}
+}
+
+func wrapErr(err error) error {
+	if gorm.IsRecordNotFoundError(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func newID() (string, error) {
+	u, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (s *GormStore) GetUser(uid string) (User, error) {
+	var user User
+	err := s.db.
+		Where(User{
+			UserID: uid,
+		}).
+		Take(&user).Error
+	return user, wrapErr(err)
+}
+
+func (s *GormStore) GetUserByName(name string) (User, error) {
+	var user User
+	err := s.db.
+		Where(User{
+			Name: name,
+		}).
+		Take(&user).Error
+	return user, wrapErr(err)
+}
+
+func (s *GormStore) FirstOrCreateUser(uid, name string) (User, error) {
+	var user User
+	err := s.db.
+		Where(User{
+			UserID: uid,
+		}).
+		Attrs(User{
+			UserID: uid,
+			Name:   name,
+		}).
+		FirstOrCreate(&user).Error
+	return user, err
+}
+
+func (s *GormStore) UpdateUserName(uid, name string) (User, error) {
+	var user User
+	err := s.db.
+		Where(User{
+			UserID: uid,
+		}).
+		Assign(User{
+			UserID: uid,
+			Name:   name,
+		}).
+		FirstOrCreate(&user).Error
+	return user, err
+}
+
+func (s *GormStore) GetUserDetail(uid string) (UserDetail, error) {
+	var detail UserDetail
+	err := s.db.
+		Where(UserDetail{
+			UserID: uid,
+		}).
+		FirstOrInit(&detail).Error
+	return detail, err
+}
+
+func (s *GormStore) UpdateUserDetail(d UserDetail) (UserDetail, error) {
+	var detail UserDetail
+	err := s.db.
+		Where(UserDetail{
+			UserID: d.UserID,
+		}).
+		Assign(map[string]interface{}{
+			"user_id":          d.UserID,
+			"at_coder_id":      d.AtCoderID,
+			"codeforces_id":    d.CodeforcesID,
+			"yukicoder_id":     d.YukicoderID,
+			"aoj_id":           d.AOJID,
+			"leet_code_id":     d.LeetCodeID,
+			"public_judge_ids": d.PublicJudgeIDs,
+		}).
+		FirstOrCreate(&detail).Error
+	return detail, err
+}
+
+// 外部キーの on delete cascade に頼らず、DeleteNote と同じように明示的に消す
+// ユーザーが付けたスターの分だけ、ほかのユーザーのノートのスター数を減らす
+func (s *GormStore) DeleteUser(uid string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.
+			Where(User{
+				UserID: uid,
+			}).
+			Take(&user).Error; err != nil {
+			return wrapErr(err)
+		}
+
+		noteIDs := tx.
+			Model(&Note{}).
+			Select("id").
+			Where(Note{
+				UserNo: user.No,
+			}).
+			SubQuery()
+		if err := tx.
+			Model(&Note{}).
+			Where("id in (select note_id from stars where user_no = ?)", user.No).
+			UpdateColumn("star_count", gorm.Expr("star_count - 1")).Error; err != nil {
+			return err
+		}
+		if err := tx.
+			Where("user_no = ? or note_id in ?", user.No, noteIDs).
+			Delete(&Star{}).Error; err != nil {
+			return err
+		}
+		if err := tx.
+			Where("user_no = ? or note_id in ?", user.No, noteIDs).
+			Delete(&NoteComment{}).Error; err != nil {
+			return err
+		}
+		if err := tx.
+			Where("note_id in ?", noteIDs).
+			Delete(&TagMap{}).Error; err != nil {
+			return err
+		}
+		if err := tx.
+			Where("note_id in ?", noteIDs).
+			Delete(&NoteRevision{}).Error; err != nil {
+			return err
+		}
+		if err := tx.
+			Where(Note{
+				UserNo: user.No,
+			}).
+			Delete(&Note{}).Error; err != nil {
+			return err
+		}
+		if err := tx.
+			Where("follower_no = ? or followee_no = ?", user.No, user.No).
+			Delete(&Follow{}).Error; err != nil {
+			return err
+		}
+		for _, v := range []interface{}{&AccessToken{}, &Submission{}, &UserDetail{}} {
+			if err := tx.Where("user_id = ?", uid).Delete(v).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&user).Error
+	})
+}
+
+func (s *GormStore) GetProblem(no int) (Problem, error) {
+	var problem Problem
+	err := s.db.
+		Where(Problem{
+			No: no,
+		}).
+		Take(&problem).Error
+	return problem, wrapErr(err)
+}
+
+func (s *GormStore) ListProblems(f ProblemFilter) ([]Problem, int, error) {
+	query := s.db.
+		Model(&Problem{}).
+		Where(Problem{
+			Domain:    f.Domain,
+			ContestID: f.ContestID,
+		})
+	if f.Text != "" {
+		query = query.Where("problems.title ilike ?", "%"+likeEscaper.Replace(f.Text)+"%")
+	}
+	if f.DifficultyKind != "" {
+		query = query.Where("problems.difficulty_kind = ?", f.DifficultyKind)
+	}
+	if cond, args := difficultyRangeCond(f.MinDifficulty, f.MaxDifficulty, f.MinEstimate, f.MaxEstimate); cond != "" {
+		query = query.Where(cond, args...)
+	}
+
+	count := 0
+	if err := query.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+
+	dir := " desc"
+	if f.Ascending {
+		dir = " asc"
+	}
+	order := "problems.no" + dir
+	switch f.Order {
+	case ProblemOrderDifficulty:
+		order = "problems.difficulty_value" + dir + " nulls last, problems.no"
+	case ProblemOrderEstimate:
+		order = "problems.difficulty_estimate" + dir + " nulls last, problems.no"
+	case ProblemOrderTitle:
+		order = "problems.title" + dir + ", problems.no"
+	case ProblemOrderStarted:
+		// コンテストは (domain, contest_id) で一意なので、joinしても行は重複しない
+		query = query.Joins("left join contests on contests.domain = problems.domain and contests.contest_id = problems.contest_id")
+		order = "contests.start_time_seconds" + dir + " nulls last, problems.no"
+	}
+	if f.Limit > 0 {
+		query = query.Limit(f.Limit)
+	}
+
+	var problems []Problem
+	err := query.
+		Select("problems.*").
+		Offset(f.Skip).Order(order).
+		Find(&problems).Error
+	return problems, count, err
+}
+
+func (s *GormStore) FindProblems(filter Problem, limit int) ([]Problem, error) {
+	var problems []Problem
+	err := s.db.
+		Where(Problem{
+			Domain:     filter.Domain,
+			ContestID:  filter.ContestID,
+			ProblemID:  filter.ProblemID,
+			FrontendID: filter.FrontendID,
+			Slug:       filter.Slug,
+		}).
+		Order("no").
+		Limit(limit).
+		Find(&problems).Error
+	return problems, err
+}
+
+func (s *GormStore) ListContests(f ContestFilter) ([]Contest, int, error) {
+	query := s.db.Model(&Contest{})
+	if len(f.Domains) > 0 {
+		query = query.Where("domain in (?)", f.Domains)
+	}
+	if len(f.Statuses) > 0 {
+		query = query.Where("status in (?)", f.Statuses)
+	}
+	if f.ContestID != "" {
+		query = query.Where("contest_id = ?", f.ContestID)
+	}
+	if f.Text != "" {
+		query = query.Where("title ilike ?", "%"+likeEscaper.Replace(f.Text)+"%")
+	}
+	if cond, args := difficultyRangeCond(f.MinDifficulty, f.MaxDifficulty, f.MinEstimate, f.MaxEstimate); cond != "" {
+		if f.DifficultyKind != "" {
+			cond += " and problems.difficulty_kind = ?"
+			args = append(args, f.DifficultyKind)
+		}
+		query = query.Where("exists (select 1 from problems where problems.domain = contests.domain and problems.contest_id = contests.contest_id and "+cond+")", args...)
+	}
+	if f.EndAfter != 0 {
+		query = query.
+			Where("start_time_seconds > 0").
+			Where("start_time_seconds + duration_seconds > ?", f.EndAfter)
+	}
+
+	count := 0
+	if err := query.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+
+	dir := " desc"
+	if f.Ascending {
+		dir = " asc"
+	}
+	order := "start_time_seconds" + dir + ", no"
+	if f.Order == ContestOrderTitle {
+		order = "title" + dir + ", no"
+	}
+	if f.Limit > 0 {
+		query = query.Limit(f.Limit)
+	}
+
+	var contests []Contest
+	err := query.Offset(f.Skip).Order(order).Find(&contests).Error
+	return contests, count, err
+}
+
+func (s *GormStore) GetNote(id string) (Note, error) {
+	var note Note
+	err := s.db.
+		Preload("User").
+		Preload("Problem").
+		Where(Note{
+			ID: id,
+		}).
+		Take(&note).Error
+	return note, wrapErr(err)
+}
+
+func (s *GormStore) GetUserNote(uid string, problemNo int) (Note, error) {
+	pfilter := Problem{No: problemNo}
+	ufilter := User{UserID: uid}
+	var note Note
+	err := s.db.
+		Preload("User").
+		Preload("Problem").
+		Joins("left join problems on problems.no = notes.problem_no").
+		Joins("left join users on users.no = notes.user_no").
+		Where(&pfilter).
+		Where(&ufilter).
+		Take(&note).Error
+	return note, wrapErr(err)
+}
+
+func (s *GormStore) SaveNote(userNo, problemNo int, text string, public int) (Note, error) {
+	randID, err := newID()
+	if err != nil {
+		return Note{}, err
+	}
+
+	var note Note
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.
+			Where(Note{
+				ProblemNo: problemNo,
+				UserNo:    userNo,
+			}).
+			Attrs(Note{
+				ID: randID,
+			}).
+			Assign(Note{
+				Text:   text,
+				Public: public,
+			}).
+			FirstOrCreate(&note).Error; err != nil {
+			return err
+		}
+		return tx.
+			Create(&NoteRevision{
+				NoteID: note.ID,
+				Text:   note.Text,
+				Public: note.Public,
+			}).Error
+	})
+	return note, err
+}
+
+// 外部キーの on delete cascade でも消えるが、マイグレーション前のDBでも孤立した行を残さないように明示的に消す
+func (s *GormStore) DeleteNote(note Note) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.
+			Where(TagMap{
+				NoteID: note.ID,
+			}).
+			Delete(&TagMap{}).Error; err != nil {
+			return err
+		}
+		if err := tx.
+			Where(NoteRevision{
+				NoteID: note.ID,
+			}).
+			Delete(&NoteRevision{}).Error; err != nil {
+			return err
+		}
+		if err := tx.
+			Where(Star{
+				NoteID: note.ID,
+			}).
+			Delete(&Star{}).Error; err != nil {
+			return err
+		}
+		if err := tx.
+			Where(NoteComment{
+				NoteID: note.ID,
+			}).
+			Delete(&NoteComment{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&note).Error
+	})
+}
+
+func (s *GormStore) ListUserNotes(userNo int) ([]Note, error) {
+	var notes []Note
+	err := s.db.
+		Preload("Problem").
+		Where(Note{
+			UserNo: userNo,
+		}).
+		Order("created_at, id").
+		Find(&notes).Error
+	return notes, err
+}
+
+func (s *GormStore) ListNotes(f NoteFilter) ([]Note, int, error) {
+	pfilter := Problem{
+		Domain:    f.Domain,
+		No:        f.ProblemNo,
+		ContestID: f.ContestID,
+	}
+	ufilter := User{
+		UserID: f.UserID,
+		Name:   f.UserName,
+	}
+	nfilter := Note{}
+	if f.PublicOnly {
+		nfilter.Public = 2
+	}
+
+	query := s.db.
+		Model(&Note{}).
+		Joins("left join problems on problems.no = notes.problem_no").
+		Joins("left join users on users.no = notes.user_no").
+		Where(&pfilter).
+		Where(&ufilter).
+		Where(&nfilter)
+	// joinするとタグの数だけ行が重複するので、existsで絞り込む
+	if f.AnyTag && len(f.Tags) > 0 {
+		query = query.Where("exists ("+noteTagQuery+")", f.Tags)
+	} else {
+		for _, v := range f.Tags {
+			query = query.Where("exists ("+noteTagQuery+")", []string{v})
+		}
+	}
+	if len(f.ExcludeTags) > 0 {
+		query = query.Where("not exists ("+noteTagQuery+")", f.ExcludeTags)
+	}
+	if f.Text != "" {
+		query = query.Where(noteSearchCond, f.Text, f.Text)
+	}
+
+	count := 0
+	if err := query.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var order interface{} = "notes.updated_at desc"
+	switch {
+	case f.Order == OrderRelevance && f.Text != "":
+		order = gorm.Expr(noteSearchRank, f.Text)
+	case f.Order == OrderCreated:
+		order = "notes.created_at desc"
+	case f.Order == OrderStars:
+		order = "notes.star_count desc, notes.updated_at desc"
+	}
+
+	var notes []Note
+	if err := query.
+		Limit(f.Limit).Offset(f.Skip).Order(order).
+		Preload("User").
+		Preload("Problem").
+		Find(&notes).Error; err != nil {
+		return nil, 0, err
+	}
+	return notes, count, nil
+}
+
+func (s *GormStore) NoteSnippets(text string, noteIDs []string) (map[string]string, error) {
+	snippets := make(map[string]string)
+	if len(noteIDs) == 0 {
+		return snippets, nil
+	}
+
+	type result struct {
+		ID      string
+		Snippet string
+	}
+	var res []result
+	if err := s.db.
+		Raw(noteSnippetQuery, text, noteIDs).
+		Scan(&res).Error; err != nil {
+		return nil, err
+	}
+	for _, v := range res {
+		snippets[v.ID] = v.Snippet
+	}
+	return snippets, nil
+}
+
+func (s *GormStore) UserNoteStats(userNo, tagLimit int) (UserNoteStats, error) {
+	stats := UserNoteStats{}
+	if err := s.db.
+		Model(&Note{}).
+		Where(Note{
+			UserNo: userNo,
+			Public: 2,
+		}).
+		Count(&stats.NoteCount).Error; err != nil {
+		return stats, err
+	}
+	if err := s.db.
+		Table("notes").
+		Select("problems.domain, count(*) as count").
+		Joins("inner join problems on problems.no = notes.problem_no").
+		Where("notes.user_no = ? and notes.public = ?", userNo, 2).
+		Group("problems.domain").
+		Order("count desc, problems.domain asc").
+		Scan(&stats.Domains).Error; err != nil {
+		return stats, err
+	}
+	err := s.db.
+		Table("tags").
+		Select("tags.key, count(*) as count").
+		Joins("inner join tag_maps on tag_maps.tag_no = tags.no").
+		Joins("inner join notes on notes.id = tag_maps.note_id").
+		Where("notes.user_no = ? and notes.public = ?", userNo, 2).
+		Group("tags.key").
+		Order("count desc, tags.key asc").
+		Limit(tagLimit).
+		Scan(&stats.Tags).Error
+	return stats, err
+}
+
+func (s *GormStore) ListNoteRevisions(noteID string) ([]NoteRevision, error) {
+	var revisions []NoteRevision
+	err := s.db.
+		Order("created_at desc").
+		Where(NoteRevision{
+			NoteID: noteID,
+		}).
+		Find(&revisions).Error
+	return revisions, err
+}
+
+func (s *GormStore) GetNoteRevision(noteID string, no int) (NoteRevision, error) {
+	var revision NoteRevision
+	err := s.db.
+		Where(NoteRevision{
+			No:     no,
+			NoteID: noteID,
+		}).
+		Take(&revision).Error
+	return revision, wrapErr(err)
+}
+
+func (s *GormStore) RestoreNoteRevision(note Note, revision NoteRevision) (Note, error) {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.
+			Model(&note).
+			Updates(map[string]interface{}{
+				"text":   revision.Text,
+				"public": revision.Public,
+			}).Error; err != nil {
+			return err
+		}
+		return tx.
+			Create(&NoteRevision{
+				NoteID: note.ID,
+				Text:   revision.Text,
+				Public: revision.Public,
+			}).Error
+	})
+	return note, err
+}
+
+func (s *GormStore) GetTag(key string) (Tag, error) {
+	var tag Tag
+	err := s.db.
+		Where(Tag{
+			Key: key,
+		}).
+		Take(&tag).Error
+	return tag, wrapErr(err)
+}
+
+// like のワイルドカードをエスケープする
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (s *GormStore) SearchTags(prefix string, limit int) ([]TagCount, error) {
+	var tags []TagCount
+	err := s.db.
+		Table("tags").
+		Select("tags.key, count(*) as count").
+		Joins("inner join tag_maps on tag_maps.tag_no = tags.no").
+		Where("lower(tags.key) like ?", likeEscaper.Replace(strings.ToLower(prefix))+"%").
+		Group("tags.key").
+		Order("count desc, tags.key asc").
+		Limit(limit).
+		Scan(&tags).Error
+	return tags, err
+}
+
+func (s *GormStore) ListUserTags(uid string) ([]TagCount, error) {
+	var tags []TagCount
+	err := s.db.
+		Table("tags").
+		Select("tags.key, count(*) as count").
+		Joins("inner join tag_maps on tag_maps.tag_no = tags.no").
+		Joins("inner join notes on notes.id = tag_maps.note_id").
+		Joins("inner join users on users.no = notes.user_no").
+		Where("users.user_id = ?", uid).
+		Group("tags.key").
+		Order("count desc, tags.key asc").
+		Scan(&tags).Error
+	return tags, err
+}
+
+func (s *GormStore) PopularTags(limit int) ([]TagCount, error) {
+	var tags []TagCount
+	err := s.db.
+		Table("tags").
+		Select("tags.key, count(*) as count").
+		Joins("inner join tag_maps on tag_maps.tag_no = tags.no").
+		Joins("inner join notes on notes.id = tag_maps.note_id").
+		Where("notes.public = ?", 2).
+		Group("tags.key").
+		Order("count desc, tags.key asc").
+		Limit(limit).
+		Scan(&tags).Error
+	return tags, err
+}
+
+func (s *GormStore) ListNoteTags(noteID string) ([]string, error) {
+	var keys []string
+	err := s.db.
+		Model(&TagMap{}).
+		Joins("inner join tags on tags.no = tag_maps.tag_no").
+		Where(TagMap{
+			NoteID: noteID,
+		}).
+		Order("tag_maps.no asc").
+		Pluck("tags.key", &keys).Error
+	return keys, err
+}
+
+func (s *GormStore) ListUserNoteTags(userNo int) (map[string][]string, error) {
+	type result struct {
+		NoteID string
+		Key    string
+	}
+	var results []result
+	if err := s.db.
+		Table("tag_maps").
+		Select("tag_maps.note_id, tags.key").
+		Joins("inner join tags on tags.no = tag_maps.tag_no").
+		Joins("inner join notes on notes.id = tag_maps.note_id").
+		Where("notes.user_no = ?", userNo).
+		Order("tag_maps.no asc").
+		Scan(&results).Error; err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string][]string)
+	for _, v := range results {
+		tags[v.NoteID] = append(tags[v.NoteID], v.Key)
+	}
+	return tags, nil
+}
+
+// firstOrCreateNote はノートを返し、なければ空のノートを作る
+// SaveNote と同じく作成時の履歴も残すので、履歴の最初の版がノートの作成時になる
+func firstOrCreateNote(tx *gorm.DB, userNo, problemNo int) (Note, error) {
+	var note Note
+	err := tx.
+		Where(Note{
+			ProblemNo: problemNo,
+			UserNo:    userNo,
+		}).
+		Take(&note).Error
+	if !gorm.IsRecordNotFoundError(err) {
+		return note, err
+	}
+
+	randID, err := newID()
+	if err != nil {
+		return note, err
+	}
+	note = Note{
+		ID:        randID,
+		ProblemNo: problemNo,
+		UserNo:    userNo,
+		Public:    1,
+	}
+	if err := tx.Create(&note).Error; err != nil {
+		return note, err
+	}
+	return note, tx.
+		Create(&NoteRevision{
+			NoteID: note.ID,
+			Text:   note.Text,
+			Public: note.Public,
+		}).Error
+}
+
+func (s *GormStore) AddNoteTag(userNo, problemNo int, key string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var tag Tag
+		if err := tx.
+			Where(Tag{
+				Key: key,
+			}).
+			FirstOrCreate(&tag).Error; err != nil {
+			return err
+		}
+		note, err := firstOrCreateNote(tx, userNo, problemNo)
+		if err != nil {
+			return err
+		}
+		var tagMap TagMap
+		return tx.
+			Where(TagMap{
+				NoteID: note.ID,
+				TagNo:  tag.No,
+			}).
+			FirstOrCreate(&tagMap).Error
+	})
+}
+
+func (s *GormStore) RemoveNoteTag(noteID string, tagNo int) error {
+	var tagMap TagMap
+	if err := s.db.
+		Where(TagMap{
+			NoteID: noteID,
+			TagNo:  tagNo,
+		}).
+		Take(&tagMap).Error; err != nil {
+		return wrapErr(err)
+	}
+	return s.db.Delete(&tagMap).Error
+}
+
+func (s *GormStore) BulkUpdateNoteTags(userNo int, ops []NoteTagOp) ([]NoteTagResult, error) {
+	var results []NoteTagResult
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for _, op := range ops {
+			res, err := updateNoteTags(tx, userNo, op)
+			if err != nil {
+				return err
+			}
+			results = append(results, res)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func updateNoteTags(tx *gorm.DB, userNo int, op NoteTagOp) (NoteTagResult, error) {
+	res := NoteTagResult{ProblemNo: op.ProblemNo}
+
+	// 外すだけのときはノートを作らない
+	var note Note
+	var err error
+	if len(op.Add) > 0 {
+		note, err = firstOrCreateNote(tx, userNo, op.ProblemNo)
+	} else {
+		err = tx.
+			Where(Note{
+				ProblemNo: op.ProblemNo,
+				UserNo:    userNo,
+			}).
+			Take(&note).Error
+	}
+	if gorm.IsRecordNotFoundError(err) {
+		return res, nil
+	} else if err != nil {
+		return res, err
+	}
+
+	for _, key := range op.Add {
+		var tag Tag
+		if err := tx.
+			Where(Tag{
+				Key: key,
+			}).
+			FirstOrCreate(&tag).Error; err != nil {
+			return res, err
+		}
+		count := 0
+		if err := tx.
+			Model(&TagMap{}).
+			Where(TagMap{
+				NoteID: note.ID,
+				TagNo:  tag.No,
+			}).
+			Count(&count).Error; err != nil {
+			return res, err
+		}
+		if count > 0 {
+			continue
+		}
+		if err := tx.
+			Create(&TagMap{
+				NoteID: note.ID,
+				TagNo:  tag.No,
+			}).Error; err != nil {
+			return res, err
+		}
+		res.Added++
+	}
+
+	if len(op.Remove) > 0 {
+		q := tx.
+			Where("note_id = ?", note.ID).
+			Where("tag_no in (select no from tags where key in (?))", op.Remove).
+			Delete(&TagMap{})
+		if q.Error != nil {
+			return res, q.Error
+		}
+		res.Removed = int(q.RowsAffected)
+	}
+	return res, nil
+}
+
+const userNoteIDs = "select notes.id from notes inner join users on users.no = notes.user_no where users.user_id = ?"
+
+func (s *GormStore) MergeUserTags(uid string, from []string, to string) (int, error) {
+	count := 0
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var tag Tag
+		if err := tx.
+			Where(Tag{
+				Key: to,
+			}).
+			FirstOrCreate(&tag).Error; err != nil {
+			return err
+		}
+
+		var fromNos []int
+		if err := tx.
+			Model(&Tag{}).
+			Where("key in (?) and no <> ?", from, tag.No).
+			Pluck("no", &fromNos).Error; err != nil {
+			return err
+		}
+		if len(fromNos) == 0 {
+			return nil
+		}
+
+		query := tx.
+			Model(&TagMap{}).
+			Where("tag_no in (?)", fromNos).
+			Where("note_id in ("+userNoteIDs+")", uid)
+		if err := query.
+			Select("count(distinct note_id)").
+			Count(&count).Error; err != nil {
+			return err
+		}
+
+		// すでに to が付いているノートは、付け替えると重複するので消す
+		if err := query.
+			Where("note_id in (select note_id from tag_maps where tag_no = ?)", tag.No).
+			Delete(&TagMap{}).Error; err != nil {
+			return err
+		}
+		// from のタグが複数付いているノートも、tag_no が最小のもの以外を消す
+		if err := query.
+			Where("exists (select 1 from tag_maps as t where t.note_id = tag_maps.note_id and t.tag_no in (?) and t.tag_no < tag_maps.tag_no)", fromNos).
+			Delete(&TagMap{}).Error; err != nil {
+			return err
+		}
+		return query.
+			UpdateColumn("tag_no", tag.No).Error
+	})
+	return count, err
+}
+
+func (s *GormStore) SubmissionResults(uid, domain string) (map[int]bool, error) {
+	type result struct {
+		ProblemNo int
+		Accepted  bool
+	}
+	var res []result
+	if err := s.db.
+		Model(&Submission{}).
+		Select("problem_no, bool_or(result = ?) as accepted", resultAccepted).
+		Where(Submission{
+			UserID: uid,
+			Domain: domain,
+		}).
+		Group("problem_no").
+		Scan(&res).Error; err != nil {
+		return nil, err
+	}
+
+	results := make(map[int]bool)
+	for _, v := range res {
+		results[v.ProblemNo] = v.Accepted
+	}
+	return results, nil
+}
+
+func (s *GormStore) SolvedProblemNos(uid, domain string) ([]int, error) {
+	var problemNoList []int
+	err := s.db.
+		Model(&Submission{}).
+		Where(Submission{
+			UserID: uid,
+			Domain: domain,
+			Result: resultAccepted,
+		}).
+		Order("problem_no asc").
+		Pluck("distinct problem_no", &problemNoList).Error
+	return problemNoList, err
+}
+
+func (s *GormStore) ListAccessTokens(uid string) ([]AccessToken, error) {
+	var tokens []AccessToken
+	err := s.db.
+		Order("created_at desc").
+		Where(AccessToken{
+			UserID: uid,
+		}).
+		Find(&tokens).Error
+	return tokens, err
+}
+
+func (s *GormStore) CountAccessTokens(uid string) (int, error) {
+	count := 0
+	err := s.db.
+		Model(&AccessToken{}).
+		Where(AccessToken{
+			UserID: uid,
+		}).
+		Count(&count).Error
+	return count, err
+}
+
+func (s *GormStore) CreateAccessToken(token *AccessToken) error {
+	return s.db.Create(token).Error
+}
+
+func (s *GormStore) GetAccessToken(uid string, no int) (AccessToken, error) {
+	var token AccessToken
+	err := s.db.
+		Where(AccessToken{
+			No:     no,
+			UserID: uid,
+		}).
+		Take(&token).Error
+	return token, wrapErr(err)
+}
+
+func (s *GormStore) GetAccessTokenByHash(hash string) (AccessToken, error) {
+	var token AccessToken
+	err := s.db.
+		Where(AccessToken{
+			Hash: hash,
+		}).
+		Take(&token).Error
+	return token, wrapErr(err)
+}
+
+func (s *GormStore) TouchAccessToken(no int, t time.Time) error {
+	return s.db.
+		Model(&AccessToken{No: no}).
+		UpdateColumn("last_used_at", t).Error
+}
+
+func (s *GormStore) DeleteAccessToken(no int) error {
+	return s.db.Delete(&AccessToken{No: no}).Error
+}
+
+func (s *GormStore) Follow(followerNo, followeeNo int) error {
+	return s.db.
+		Where(Follow{
+			FollowerNo: followerNo,
+			FolloweeNo: followeeNo,
+		}).
+		FirstOrCreate(&Follow{}).Error
+}
+
+func (s *GormStore) Unfollow(followerNo, followeeNo int) error {
+	res := s.db.
+		Where(Follow{
+			FollowerNo: followerNo,
+			FolloweeNo: followeeNo,
+		}).
+		Delete(&Follow{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *GormStore) ListFollowers(userNo int) ([]User, error) {
+	var users []User
+	err := s.db.
+		Joins("inner join follows on follows.follower_no = users.no").
+		Where("follows.followee_no = ?", userNo).
+		Order("follows.created_at desc").
+		Find(&users).Error
+	return users, err
+}
+
+func (s *GormStore) ListFollowing(userNo int) ([]User, error) {
+	var users []User
+	err := s.db.
+		Joins("inner join follows on follows.followee_no = users.no").
+		Where("follows.follower_no = ?", userNo).
+		Order("follows.created_at desc").
+		Find(&users).Error
+	return users, err
+}
+
+func (s *GormStore) ListFeed(userNo int, after *NoteCursor, limit int) ([]Note, error) {
+	query := s.db.
+		Where("notes.user_no in (select followee_no from follows where follower_no = ?)", userNo).
+		Where(Note{
+			Public: 2,
+		})
+	// updated_at が同じノートも取りこぼさないように id と組にして比較する
+	if after != nil {
+		query = query.Where("(notes.updated_at, notes.id) < (?, ?)", after.UpdatedAt, after.ID)
+	}
+
+	var notes []Note
+	err := query.
+		Order("notes.updated_at desc, notes.id desc").
+		Limit(limit).
+		Preload("User").
+		Preload("Problem").
+		Find(&notes).Error
+	return notes, err
+}
+
+func (s *GormStore) StarNote(userNo int, noteID string) (int, error) {
+	return s.updateStar(userNo, noteID, true)
+}
+
+func (s *GormStore) UnstarNote(userNo int, noteID string) (int, error) {
+	return s.updateStar(userNo, noteID, false)
+}
+
+// updated_at を変えないように UpdateColumn でスター数を更新する
+func (s *GormStore) updateStar(userNo int, noteID string, star bool) (int, error) {
+	var note Note
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		filter := Star{
+			UserNo: userNo,
+			NoteID: noteID,
+		}
+		delta := 0
+		if star {
+			count := 0
+			if err := tx.Model(&Star{}).Where(filter).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				if err := tx.Create(&filter).Error; err != nil {
+					return err
+				}
+				delta = 1
+			}
+		} else {
+			res := tx.Where(filter).Delete(&Star{})
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return ErrNotFound
+			}
+			delta = -1
+		}
+		if delta != 0 {
+			if err := tx.
+				Model(&Note{ID: noteID}).
+				UpdateColumn("star_count", gorm.Expr("star_count + ?", delta)).Error; err != nil {
+				return err
+			}
+		}
+		return tx.
+			Select("star_count").
+			Where(Note{
+				ID: noteID,
+			}).
+			Take(&note).Error
+	})
+	return note.StarCount, wrapErr(err)
+}
+
+func (s *GormStore) ListStarredNotes(userNo, limit, skip int) ([]Note, int, error) {
+	query := s.db.
+		Model(&Note{}).
+		Joins("inner join stars on stars.note_id = notes.id").
+		Where("stars.user_no = ?", userNo).
+		Where(Note{
+			Public: 2,
+		})
+
+	count := 0
+	if err := query.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var notes []Note
+	if err := query.
+		Limit(limit).Offset(skip).Order("stars.created_at desc").
+		Preload("User").
+		Preload("Problem").
+		Find(&notes).Error; err != nil {
+		return nil, 0, err
+	}
+	return notes, count, nil
+}
+
+func (s *GormStore) ListNoteComments(noteID string) ([]NoteComment, error) {
+	var comments []NoteComment
+	err := s.db.
+		Preload("User").
+		Where(NoteComment{
+			NoteID: noteID,
+		}).
+		Order("created_at, no").
+		Find(&comments).Error
+	return comments, err
+}
+
+func (s *GormStore) GetNoteComment(noteID string, no int) (NoteComment, error) {
+	var comment NoteComment
+	err := s.db.
+		Preload("User").
+		Where(NoteComment{
+			No:     no,
+			NoteID: noteID,
+		}).
+		Take(&comment).Error
+	return comment, wrapErr(err)
+}
+
+func (s *GormStore) CreateNoteComment(comment *NoteComment) error {
+	return s.db.Create(comment).Error
+}
+
+// 返信は parent_no の外部キーの on delete cascade で消える
+func (s *GormStore) DeleteNoteComment(no int) error {
+	return s.db.
+		Where(NoteComment{
+			No: no,
+		}).
+		Delete(&NoteComment{}).Error
+}
+
+func (s *GormStore) SetNoteCommentsDisabled(noteID string, disabled bool) error {
+	return s.db.
+		Model(&Note{ID: noteID}).
+		UpdateColumn("comments_disabled", disabled).Error
+}
+
+var _ Store = (*GormStore)(nil)
@@ -0,0 +1,1350 @@
+package store
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/tsushiy/codernote-backend/db"
+)
+
+// MemoryStore はハンドラのテスト用にメモリ上でデータを保持する
+// 検索は全文検索の代わりに、すべての単語を大文字小文字を区別せずに含むかどうかで判定する
+type MemoryStore struct {
+	mu sync.Mutex
+
+	users       []User
+	details     map[string]UserDetail
+	problems    []Problem
+	contests    []Contest
+	notes       []Note
+	revisions   []NoteRevision
+	tags        []Tag
+	tagMaps     []TagMap
+	submissions []Submission
+	tokens      []AccessToken
+	follows     []Follow
+	stars       []Star
+	comments    []NoteComment
+
+	lastNo int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		details: make(map[string]UserDetail),
+	}
+}
+
+func (s *MemoryStore) nextNo() int {
+	s.lastNo++
+	return s.lastNo
+}
+
+// AddProblem は問題を追加する。No が0なら採番する
+func (s *MemoryStore) AddProblem(p Problem) Problem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p.No == 0 {
+		p.No = s.nextNo()
+	}
+	p.URL = p.CanonicalURL()
+	s.problems = append(s.problems, p)
+	return p
+}
+
+// AddContest はコンテストを追加する。No が0なら採番する
+func (s *MemoryStore) AddContest(c Contest) Contest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.No == 0 {
+		c.No = s.nextNo()
+	}
+	s.contests = append(s.contests, c)
+	return c
+}
+
+// AddSubmission は提出を追加する
+func (s *MemoryStore) AddSubmission(sub Submission) Submission {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub.No = s.nextNo()
+	s.submissions = append(s.submissions, sub)
+	return sub
+}
+
+func (s *MemoryStore) findUser(uid string) (int, bool) {
+	for i, v := range s.users {
+		if v.UserID == uid {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (s *MemoryStore) userByNo(no int) User {
+	for _, v := range s.users {
+		if v.No == no {
+			return v
+		}
+	}
+	return User{}
+}
+
+func (s *MemoryStore) problemByNo(no int) (Problem, bool) {
+	for _, v := range s.problems {
+		if v.No == no {
+			return v, true
+		}
+	}
+	return Problem{}, false
+}
+
+func (s *MemoryStore) GetUser(uid string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i, ok := s.findUser(uid); ok {
+		return s.users[i], nil
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *MemoryStore) GetUserByName(name string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.users {
+		if v.Name == name {
+			return v, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *MemoryStore) FirstOrCreateUser(uid, name string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i, ok := s.findUser(uid); ok {
+		return s.users[i], nil
+	}
+	for _, v := range s.users {
+		if v.Name == name {
+			return User{}, errors.New("duplicate user name")
+		}
+	}
+	now := time.Now()
+	user := User{
+		No:        s.nextNo(),
+		UserID:    uid,
+		Name:      name,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	s.users = append(s.users, user)
+	return user, nil
+}
+
+func (s *MemoryStore) UpdateUserName(uid, name string) (User, error) {
+	s.mu.Lock()
+	i, ok := s.findUser(uid)
+	for _, v := range s.users {
+		if v.Name == name && v.UserID != uid {
+			s.mu.Unlock()
+			return User{}, errors.New("duplicate user name")
+		}
+	}
+	if ok {
+		s.users[i].Name = name
+		s.users[i].UpdatedAt = time.Now()
+		user := s.users[i]
+		s.mu.Unlock()
+		return user, nil
+	}
+	s.mu.Unlock()
+	return s.FirstOrCreateUser(uid, name)
+}
+
+func (s *MemoryStore) GetUserDetail(uid string) (UserDetail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.details[uid]; ok {
+		return d, nil
+	}
+	return UserDetail{UserID: uid}, nil
+}
+
+func (s *MemoryStore) UpdateUserDetail(d UserDetail) (UserDetail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.details[d.UserID] = d
+	return d, nil
+}
+
+func (s *MemoryStore) DeleteUser(uid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.findUser(uid)
+	if !ok {
+		return ErrNotFound
+	}
+	no := s.users[i].No
+	s.users = append(s.users[:i], s.users[i+1:]...)
+	delete(s.details, uid)
+
+	noteIDs := make(map[string]bool)
+	var notes []Note
+	for _, v := range s.notes {
+		if v.UserNo == no {
+			noteIDs[v.ID] = true
+		} else {
+			notes = append(notes, v)
+		}
+	}
+	var stars []Star
+	for _, v := range s.stars {
+		if v.UserNo == no {
+			for j := range notes {
+				if notes[j].ID == v.NoteID {
+					notes[j].StarCount--
+				}
+			}
+		} else if !noteIDs[v.NoteID] {
+			stars = append(stars, v)
+		}
+	}
+	s.notes = notes
+	s.stars = stars
+
+	var comments []NoteComment
+	deleted := make(map[int]bool)
+	for _, v := range s.comments {
+		if v.UserNo == no || noteIDs[v.NoteID] || (v.ParentNo != nil && deleted[*v.ParentNo]) {
+			deleted[v.No] = true
+			continue
+		}
+		comments = append(comments, v)
+	}
+	s.comments = comments
+	var tagMaps []TagMap
+	for _, v := range s.tagMaps {
+		if !noteIDs[v.NoteID] {
+			tagMaps = append(tagMaps, v)
+		}
+	}
+	s.tagMaps = tagMaps
+	var revisions []NoteRevision
+	for _, v := range s.revisions {
+		if !noteIDs[v.NoteID] {
+			revisions = append(revisions, v)
+		}
+	}
+	s.revisions = revisions
+	var follows []Follow
+	for _, v := range s.follows {
+		if v.FollowerNo != no && v.FolloweeNo != no {
+			follows = append(follows, v)
+		}
+	}
+	s.follows = follows
+	var tokens []AccessToken
+	for _, v := range s.tokens {
+		if v.UserID != uid {
+			tokens = append(tokens, v)
+		}
+	}
+	s.tokens = tokens
+	var submissions []Submission
+	for _, v := range s.submissions {
+		if v.UserID != uid {
+			submissions = append(submissions, v)
+		}
+	}
+	s.submissions = submissions
+	return nil
+}
+
+func (s *MemoryStore) GetProblem(no int) (Problem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.problemByNo(no); ok {
+		return p, nil
+	}
+	return Problem{}, ErrNotFound
+}
+
+// v が nil なら、範囲が指定されていないときだけ true を返す
+func inRange(v, min, max *float64) bool {
+	if min == nil && max == nil {
+		return true
+	}
+	return v != nil && (min == nil || *min <= *v) && (max == nil || *v <= *max)
+}
+
+func inDifficultyRange(p Problem, minValue, maxValue, minEstimate, maxEstimate *float64) bool {
+	return inRange(p.DifficultyValue, minValue, maxValue) && inRange(p.DifficultyEstimate, minEstimate, maxEstimate)
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+func (s *MemoryStore) ListProblems(f ProblemFilter) ([]Problem, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	problems := []Problem{}
+	for _, v := range s.problems {
+		switch {
+		case f.Domain != "" && v.Domain != f.Domain,
+			f.ContestID != "" && v.ContestID != f.ContestID,
+			f.Text != "" && !containsFold(v.Title, f.Text),
+			f.DifficultyKind != "" && v.DifficultyKind != f.DifficultyKind,
+			!inDifficultyRange(v, f.MinDifficulty, f.MaxDifficulty, f.MinEstimate, f.MaxEstimate):
+			continue
+		}
+		problems = append(problems, v)
+	}
+
+	startTime := func(p Problem) (int, bool) {
+		for _, c := range s.contests {
+			if c.Domain == p.Domain && c.ContestID == p.ContestID {
+				return c.StartTimeSeconds, true
+			}
+		}
+		return 0, false
+	}
+	// less は昇順で比較し、値のないものは向きによらず最後にする
+	sort.SliceStable(problems, func(i, j int) bool {
+		a, b := problems[i], problems[j]
+		var less, greater bool
+		switch f.Order {
+		case ProblemOrderDifficulty, ProblemOrderEstimate:
+			da, db := a.DifficultyValue, b.DifficultyValue
+			if f.Order == ProblemOrderEstimate {
+				da, db = a.DifficultyEstimate, b.DifficultyEstimate
+			}
+			if (da == nil) != (db == nil) {
+				return da != nil
+			}
+			if da != nil {
+				less, greater = *da < *db, *da > *db
+			}
+		case ProblemOrderTitle:
+			less, greater = a.Title < b.Title, a.Title > b.Title
+		case ProblemOrderStarted:
+			sa, oka := startTime(a)
+			sb, okb := startTime(b)
+			if oka != okb {
+				return oka
+			}
+			less, greater = sa < sb, sa > sb
+		}
+		if !less && !greater {
+			if f.Order != ProblemOrderNo {
+				return a.No < b.No
+			}
+			less, greater = a.No < b.No, a.No > b.No
+		}
+		if f.Ascending {
+			return less
+		}
+		return greater
+	})
+
+	count := len(problems)
+	if f.Skip >= len(problems) {
+		return []Problem{}, count, nil
+	}
+	problems = problems[f.Skip:]
+	if f.Limit > 0 && f.Limit < len(problems) {
+		problems = problems[:f.Limit]
+	}
+	return problems, count, nil
+}
+
+func (s *MemoryStore) FindProblems(filter Problem, limit int) ([]Problem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	match := func(want, got string) bool {
+		return want == "" || want == got
+	}
+	problems := []Problem{}
+	for _, v := range s.problems {
+		if match(filter.Domain, v.Domain) && match(filter.ContestID, v.ContestID) && match(filter.ProblemID, v.ProblemID) &&
+			match(filter.FrontendID, v.FrontendID) && match(filter.Slug, v.Slug) {
+			problems = append(problems, v)
+		}
+		if limit > 0 && len(problems) == limit {
+			break
+		}
+	}
+	return problems, nil
+}
+
+func (s *MemoryStore) ListContests(f ContestFilter) ([]Contest, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	domains := make(map[string]bool)
+	for _, v := range f.Domains {
+		domains[v] = true
+	}
+	statuses := make(map[string]bool)
+	for _, v := range f.Statuses {
+		statuses[v] = true
+	}
+	hasProblemInRange := func(c Contest) bool {
+		if f.MinDifficulty == nil && f.MaxDifficulty == nil && f.MinEstimate == nil && f.MaxEstimate == nil {
+			return true
+		}
+		for _, p := range s.problems {
+			if p.Domain == c.Domain && p.ContestID == c.ContestID && (f.DifficultyKind == "" || p.DifficultyKind == f.DifficultyKind) &&
+				inDifficultyRange(p, f.MinDifficulty, f.MaxDifficulty, f.MinEstimate, f.MaxEstimate) {
+				return true
+			}
+		}
+		return false
+	}
+
+	contests := []Contest{}
+	for _, v := range s.contests {
+		switch {
+		case len(domains) > 0 && !domains[v.Domain],
+			len(statuses) > 0 && !statuses[v.Status],
+			f.ContestID != "" && v.ContestID != f.ContestID,
+			f.Text != "" && !containsFold(v.Title, f.Text),
+			f.EndAfter != 0 && (v.StartTimeSeconds <= 0 || int64(v.StartTimeSeconds+v.DurationSeconds) <= f.EndAfter),
+			!hasProblemInRange(v):
+			continue
+		}
+		contests = append(contests, v)
+	}
+	sort.SliceStable(contests, func(i, j int) bool {
+		a, b := contests[i], contests[j]
+		if f.Order == ContestOrderTitle && a.Title != b.Title {
+			return (a.Title < b.Title) == f.Ascending
+		}
+		if f.Order != ContestOrderTitle && a.StartTimeSeconds != b.StartTimeSeconds {
+			return (a.StartTimeSeconds < b.StartTimeSeconds) == f.Ascending
+		}
+		return a.No < b.No
+	})
+
+	count := len(contests)
+	if f.Skip >= len(contests) {
+		return []Contest{}, count, nil
+	}
+	contests = contests[f.Skip:]
+	if f.Limit > 0 && f.Limit < len(contests) {
+		contests = contests[:f.Limit]
+	}
+	return contests, count, nil
+}
+
+// User と Problem を埋める
+func (s *MemoryStore) fillNote(note Note) Note {
+	note.User = s.userByNo(note.UserNo)
+	note.Problem, _ = s.problemByNo(note.ProblemNo)
+	return note
+}
+
+func (s *MemoryStore) findNote(userNo, problemNo int) (int, bool) {
+	for i, v := range s.notes {
+		if v.UserNo == userNo && v.ProblemNo == problemNo {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (s *MemoryStore) GetNote(id string) (Note, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.notes {
+		if v.ID == id {
+			return s.fillNote(v), nil
+		}
+	}
+	return Note{}, ErrNotFound
+}
+
+func (s *MemoryStore) GetUserNote(uid string, problemNo int) (Note, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.findUser(uid)
+	if !ok {
+		return Note{}, ErrNotFound
+	}
+	if j, ok := s.findNote(s.users[i].No, problemNo); ok {
+		return s.fillNote(s.notes[j]), nil
+	}
+	return Note{}, ErrNotFound
+}
+
+func (s *MemoryStore) SaveNote(userNo, problemNo int, text string, public int) (Note, error) {
+	id, err := newID()
+	if err != nil {
+		return Note{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	i, ok := s.findNote(userNo, problemNo)
+	if !ok {
+		s.notes = append(s.notes, Note{
+			ID:        id,
+			CreatedAt: now,
+			ProblemNo: problemNo,
+			UserNo:    userNo,
+		})
+		i = len(s.notes) - 1
+	}
+	s.notes[i].Text = text
+	s.notes[i].Public = public
+	s.notes[i].UpdatedAt = now
+	s.revisions = append(s.revisions, NoteRevision{
+		No:        s.nextNo(),
+		NoteID:    s.notes[i].ID,
+		Text:      text,
+		Public:    public,
+		CreatedAt: now,
+	})
+	return s.notes[i], nil
+}
+
+func (s *MemoryStore) DeleteNote(note Note) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var revisions []NoteRevision
+	for _, v := range s.revisions {
+		if v.NoteID != note.ID {
+			revisions = append(revisions, v)
+		}
+	}
+	s.revisions = revisions
+	var tagMaps []TagMap
+	for _, v := range s.tagMaps {
+		if v.NoteID != note.ID {
+			tagMaps = append(tagMaps, v)
+		}
+	}
+	s.tagMaps = tagMaps
+	var stars []Star
+	for _, v := range s.stars {
+		if v.NoteID != note.ID {
+			stars = append(stars, v)
+		}
+	}
+	s.stars = stars
+	var comments []NoteComment
+	for _, v := range s.comments {
+		if v.NoteID != note.ID {
+			comments = append(comments, v)
+		}
+	}
+	s.comments = comments
+	for i, v := range s.notes {
+		if v.ID == note.ID {
+			s.notes = append(s.notes[:i], s.notes[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) noteHasTag(noteID, key string) bool {
+	for _, m := range s.tagMaps {
+		if m.NoteID != noteID {
+			continue
+		}
+		for _, t := range s.tags {
+			if t.No == m.TagNo && t.Key == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *MemoryStore) matchTags(noteID string, f NoteFilter) bool {
+	matched := 0
+	for _, v := range f.Tags {
+		if s.noteHasTag(noteID, v) {
+			matched++
+		}
+	}
+	if len(f.Tags) > 0 && (matched == 0 || !f.AnyTag && matched < len(f.Tags)) {
+		return false
+	}
+	for _, v := range f.ExcludeTags {
+		if s.noteHasTag(noteID, v) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsAllWords(s string, words []string) bool {
+	s = strings.ToLower(s)
+	for _, w := range words {
+		if !strings.Contains(s, strings.ToLower(w)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *MemoryStore) ListUserNotes(userNo int) ([]Note, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	notes := []Note{}
+	for _, v := range s.notes {
+		if v.UserNo == userNo {
+			v.Problem, _ = s.problemByNo(v.ProblemNo)
+			notes = append(notes, v)
+		}
+	}
+	sort.SliceStable(notes, func(i, j int) bool {
+		return notes[i].CreatedAt.Before(notes[j].CreatedAt)
+	})
+	return notes, nil
+}
+
+func (s *MemoryStore) ListNotes(f NoteFilter) ([]Note, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	words := strings.Fields(f.Text)
+
+	var notes []Note
+	for _, v := range s.notes {
+		note := s.fillNote(v)
+		switch {
+		case f.Domain != "" && note.Problem.Domain != f.Domain,
+			f.ProblemNo != 0 && note.ProblemNo != f.ProblemNo,
+			f.ContestID != "" && note.Problem.ContestID != f.ContestID,
+			f.UserID != "" && note.User.UserID != f.UserID,
+			f.UserName != "" && note.User.Name != f.UserName,
+			f.PublicOnly && note.Public != 2,
+			!s.matchTags(note.ID, f),
+			len(words) > 0 && !containsAllWords(note.Text, words) && !containsAllWords(note.Problem.Title, words):
+			continue
+		}
+		notes = append(notes, note)
+	}
+
+	// スコアの代わりに、タイトルに一致するものを優先する
+	sort.SliceStable(notes, func(i, j int) bool {
+		if f.Order == OrderRelevance && len(words) > 0 {
+			ti := containsAllWords(notes[i].Problem.Title, words)
+			tj := containsAllWords(notes[j].Problem.Title, words)
+			if ti != tj {
+				return ti
+			}
+		}
+		switch f.Order {
+		case OrderCreated:
+			return notes[i].CreatedAt.After(notes[j].CreatedAt)
+		case OrderStars:
+			if notes[i].StarCount != notes[j].StarCount {
+				return notes[i].StarCount > notes[j].StarCount
+			}
+		}
+		return notes[i].UpdatedAt.After(notes[j].UpdatedAt)
+	})
+
+	count := len(notes)
+	if f.Skip >= len(notes) {
+		return []Note{}, count, nil
+	}
+	notes = notes[f.Skip:]
+	if f.Limit > 0 && f.Limit < len(notes) {
+		notes = notes[:f.Limit]
+	}
+	return notes, count, nil
+}
+
+func (s *MemoryStore) NoteSnippets(text string, noteIDs []string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var quoted []string
+	for _, w := range strings.Fields(text) {
+		quoted = append(quoted, regexp.QuoteMeta(w))
+	}
+	snippets := make(map[string]string)
+	if len(quoted) == 0 {
+		return snippets, nil
+	}
+	re := regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+
+	ids := make(map[string]bool)
+	for _, v := range noteIDs {
+		ids[v] = true
+	}
+	for _, v := range s.notes {
+		if ids[v.ID] {
+			snippets[v.ID] = re.ReplaceAllString(v.Text, "<b>$0</b>")
+		}
+	}
+	return snippets, nil
+}
+
+func (s *MemoryStore) UserNoteStats(userNo, tagLimit int) (UserNoteStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := UserNoteStats{}
+	counts := make(map[string]int)
+	for _, v := range s.notes {
+		if v.UserNo != userNo || v.Public != 2 {
+			continue
+		}
+		stats.NoteCount++
+		p, _ := s.problemByNo(v.ProblemNo)
+		counts[p.Domain]++
+	}
+	for k, v := range counts {
+		stats.Domains = append(stats.Domains, DomainCount{Domain: k, Count: v})
+	}
+	sort.Slice(stats.Domains, func(i, j int) bool {
+		if stats.Domains[i].Count != stats.Domains[j].Count {
+			return stats.Domains[i].Count > stats.Domains[j].Count
+		}
+		return stats.Domains[i].Domain < stats.Domains[j].Domain
+	})
+	stats.Tags = limitTags(s.countTags(func(note Note) bool {
+		return note.UserNo == userNo && note.Public == 2
+	}), tagLimit)
+	return stats, nil
+}
+
+func (s *MemoryStore) ListNoteRevisions(noteID string) ([]NoteRevision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var revisions []NoteRevision
+	for i := len(s.revisions) - 1; i >= 0; i-- {
+		if s.revisions[i].NoteID == noteID {
+			revisions = append(revisions, s.revisions[i])
+		}
+	}
+	return revisions, nil
+}
+
+func (s *MemoryStore) GetNoteRevision(noteID string, no int) (NoteRevision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.revisions {
+		if v.NoteID == noteID && v.No == no {
+			return v, nil
+		}
+	}
+	return NoteRevision{}, ErrNotFound
+}
+
+func (s *MemoryStore) RestoreNoteRevision(note Note, revision NoteRevision) (Note, error) {
+	saved, err := s.SaveNote(note.UserNo, note.ProblemNo, revision.Text, revision.Public)
+	if err != nil {
+		return Note{}, err
+	}
+	note.Text = saved.Text
+	note.Public = saved.Public
+	note.UpdatedAt = saved.UpdatedAt
+	return note, nil
+}
+
+func (s *MemoryStore) GetTag(key string) (Tag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.tags {
+		if v.Key == key {
+			return v, nil
+		}
+	}
+	return Tag{}, ErrNotFound
+}
+
+// keep で選んだノートについて、タグごとのノート数を数える
+func (s *MemoryStore) countTags(keep func(note Note) bool) []TagCount {
+	notes := make(map[string]bool)
+	for _, v := range s.notes {
+		if keep(s.fillNote(v)) {
+			notes[v.ID] = true
+		}
+	}
+	counts := make(map[int]int)
+	for _, m := range s.tagMaps {
+		if notes[m.NoteID] {
+			counts[m.TagNo]++
+		}
+	}
+
+	var tags []TagCount
+	for _, t := range s.tags {
+		if counts[t.No] > 0 {
+			tags = append(tags, TagCount{Key: t.Key, Count: counts[t.No]})
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Key < tags[j].Key
+	})
+	return tags
+}
+
+func limitTags(tags []TagCount, limit int) []TagCount {
+	if limit > 0 && limit < len(tags) {
+		return tags[:limit]
+	}
+	return tags
+}
+
+func (s *MemoryStore) SearchTags(prefix string, limit int) ([]TagCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tags []TagCount
+	for _, v := range s.countTags(func(Note) bool { return true }) {
+		if strings.HasPrefix(strings.ToLower(v.Key), strings.ToLower(prefix)) {
+			tags = append(tags, v)
+		}
+	}
+	return limitTags(tags, limit), nil
+}
+
+func (s *MemoryStore) ListUserTags(uid string) ([]TagCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.countTags(func(note Note) bool { return note.User.UserID == uid }), nil
+}
+
+func (s *MemoryStore) PopularTags(limit int) ([]TagCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return limitTags(s.countTags(func(note Note) bool { return note.Public == 2 }), limit), nil
+}
+
+func (s *MemoryStore) ListNoteTags(noteID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for _, m := range s.tagMaps {
+		if m.NoteID != noteID {
+			continue
+		}
+		for _, t := range s.tags {
+			if t.No == m.TagNo {
+				keys = append(keys, t.Key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+func (s *MemoryStore) ListUserNoteTags(userNo int) (map[string][]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tags := make(map[string][]string)
+	for _, m := range s.tagMaps {
+		for _, v := range s.notes {
+			if v.ID == m.NoteID && v.UserNo == userNo {
+				tags[m.NoteID] = append(tags[m.NoteID], s.tagKey(m.TagNo))
+			}
+		}
+	}
+	return tags, nil
+}
+
+func (s *MemoryStore) AddNoteTag(userNo, problemNo int, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.addNoteTag(userNo, problemNo, key)
+	return err
+}
+
+// タグを新しく付けた場合はtrueを返す
+func (s *MemoryStore) addNoteTag(userNo, problemNo int, key string) (bool, error) {
+	id, err := newID()
+	if err != nil {
+		return false, err
+	}
+
+	var tag Tag
+	for _, v := range s.tags {
+		if v.Key == key {
+			tag = v
+		}
+	}
+	if tag.No == 0 {
+		tag = Tag{No: s.nextNo(), Key: key}
+		s.tags = append(s.tags, tag)
+	}
+
+	i, ok := s.findNote(userNo, problemNo)
+	if !ok {
+		now := time.Now()
+		s.notes = append(s.notes, Note{
+			ID:        id,
+			CreatedAt: now,
+			UpdatedAt: now,
+			ProblemNo: problemNo,
+			UserNo:    userNo,
+			Public:    1,
+		})
+		i = len(s.notes) - 1
+		s.revisions = append(s.revisions, NoteRevision{
+			No:        s.nextNo(),
+			NoteID:    id,
+			Public:    1,
+			CreatedAt: now,
+		})
+	}
+	noteID := s.notes[i].ID
+
+	for _, v := range s.tagMaps {
+		if v.NoteID == noteID && v.TagNo == tag.No {
+			return false, nil
+		}
+	}
+	s.tagMaps = append(s.tagMaps, TagMap{No: s.nextNo(), NoteID: noteID, TagNo: tag.No})
+	return true, nil
+}
+
+func (s *MemoryStore) RemoveNoteTag(noteID string, tagNo int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range s.tagMaps {
+		if v.NoteID == noteID && v.TagNo == tagNo {
+			s.tagMaps = append(s.tagMaps[:i], s.tagMaps[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *MemoryStore) MergeUserTags(uid string, from []string, to string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tag Tag
+	for _, v := range s.tags {
+		if v.Key == to {
+			tag = v
+		}
+	}
+	if tag.No == 0 {
+		tag = Tag{No: s.nextNo(), Key: to}
+		s.tags = append(s.tags, tag)
+	}
+
+	fromNos := make(map[int]bool)
+	for _, v := range s.tags {
+		for _, key := range from {
+			if v.Key == key && v.No != tag.No {
+				fromNos[v.No] = true
+			}
+		}
+	}
+	userNotes := make(map[string]bool)
+	hasTo := make(map[string]bool)
+	for _, v := range s.notes {
+		if s.userByNo(v.UserNo).UserID == uid {
+			userNotes[v.ID] = true
+		}
+	}
+	for _, v := range s.tagMaps {
+		if v.TagNo == tag.No {
+			hasTo[v.NoteID] = true
+		}
+	}
+
+	changed := make(map[string]bool)
+	var tagMaps []TagMap
+	for _, v := range s.tagMaps {
+		if fromNos[v.TagNo] && userNotes[v.NoteID] {
+			changed[v.NoteID] = true
+			if hasTo[v.NoteID] {
+				continue
+			}
+			v.TagNo = tag.No
+			hasTo[v.NoteID] = true
+		}
+		tagMaps = append(tagMaps, v)
+	}
+	s.tagMaps = tagMaps
+	return len(changed), nil
+}
+
+func (s *MemoryStore) BulkUpdateNoteTags(userNo int, ops []NoteTagOp) ([]NoteTagResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var results []NoteTagResult
+	for _, op := range ops {
+		res := NoteTagResult{ProblemNo: op.ProblemNo}
+		for _, key := range op.Add {
+			added, err := s.addNoteTag(userNo, op.ProblemNo, key)
+			if err != nil {
+				return nil, err
+			}
+			if added {
+				res.Added++
+			}
+		}
+
+		if i, ok := s.findNote(userNo, op.ProblemNo); ok {
+			remove := make(map[string]bool)
+			for _, v := range op.Remove {
+				remove[v] = true
+			}
+			var tagMaps []TagMap
+			for _, v := range s.tagMaps {
+				if v.NoteID == s.notes[i].ID && remove[s.tagKey(v.TagNo)] {
+					res.Removed++
+					continue
+				}
+				tagMaps = append(tagMaps, v)
+			}
+			s.tagMaps = tagMaps
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func (s *MemoryStore) tagKey(no int) string {
+	for _, v := range s.tags {
+		if v.No == no {
+			return v.Key
+		}
+	}
+	return ""
+}
+
+func (s *MemoryStore) SubmissionResults(uid, domain string) (map[int]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	results := make(map[int]bool)
+	for _, v := range s.submissions {
+		if v.UserID != uid || (domain != "" && v.Domain != domain) {
+			continue
+		}
+		results[v.ProblemNo] = results[v.ProblemNo] || v.Result == resultAccepted
+	}
+	return results, nil
+}
+
+func (s *MemoryStore) SolvedProblemNos(uid, domain string) ([]int, error) {
+	results, err := s.SubmissionResults(uid, domain)
+	if err != nil {
+		return nil, err
+	}
+	var problemNoList []int
+	for no, accepted := range results {
+		if accepted {
+			problemNoList = append(problemNoList, no)
+		}
+	}
+	sort.Ints(problemNoList)
+	return problemNoList, nil
+}
+
+func (s *MemoryStore) ListAccessTokens(uid string) ([]AccessToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tokens []AccessToken
+	for i := len(s.tokens) - 1; i >= 0; i-- {
+		if s.tokens[i].UserID == uid {
+			tokens = append(tokens, s.tokens[i])
+		}
+	}
+	return tokens, nil
+}
+
+func (s *MemoryStore) CountAccessTokens(uid string) (int, error) {
+	tokens, err := s.ListAccessTokens(uid)
+	return len(tokens), err
+}
+
+func (s *MemoryStore) CreateAccessToken(token *AccessToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.tokens {
+		if v.Hash == token.Hash {
+			return errors.New("duplicate token hash")
+		}
+	}
+	token.No = s.nextNo()
+	token.CreatedAt = time.Now()
+	s.tokens = append(s.tokens, *token)
+	return nil
+}
+
+func (s *MemoryStore) GetAccessToken(uid string, no int) (AccessToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.tokens {
+		if v.UserID == uid && v.No == no {
+			return v, nil
+		}
+	}
+	return AccessToken{}, ErrNotFound
+}
+
+func (s *MemoryStore) GetAccessTokenByHash(hash string) (AccessToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.tokens {
+		if v.Hash == hash {
+			return v, nil
+		}
+	}
+	return AccessToken{}, ErrNotFound
+}
+
+func (s *MemoryStore) TouchAccessToken(no int, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range s.tokens {
+		if v.No == no {
+			s.tokens[i].LastUsedAt = &t
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteAccessToken(no int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range s.tokens {
+		if v.No == no {
+			s.tokens = append(s.tokens[:i], s.tokens[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) findFollow(followerNo, followeeNo int) (int, bool) {
+	for i, v := range s.follows {
+		if v.FollowerNo == followerNo && v.FolloweeNo == followeeNo {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (s *MemoryStore) Follow(followerNo, followeeNo int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.findFollow(followerNo, followeeNo); ok {
+		return nil
+	}
+	s.follows = append(s.follows, Follow{
+		No:         s.nextNo(),
+		FollowerNo: followerNo,
+		FolloweeNo: followeeNo,
+		CreatedAt:  time.Now(),
+	})
+	return nil
+}
+
+func (s *MemoryStore) Unfollow(followerNo, followeeNo int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.findFollow(followerNo, followeeNo)
+	if !ok {
+		return ErrNotFound
+	}
+	s.follows = append(s.follows[:i], s.follows[i+1:]...)
+	return nil
+}
+
+// 新しくフォローした順に返す
+func (s *MemoryStore) ListFollowers(userNo int) ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := []User{}
+	for i := len(s.follows) - 1; i >= 0; i-- {
+		if v := s.follows[i]; v.FolloweeNo == userNo {
+			users = append(users, s.userByNo(v.FollowerNo))
+		}
+	}
+	return users, nil
+}
+
+func (s *MemoryStore) ListFollowing(userNo int) ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := []User{}
+	for i := len(s.follows) - 1; i >= 0; i-- {
+		if v := s.follows[i]; v.FollowerNo == userNo {
+			users = append(users, s.userByNo(v.FolloweeNo))
+		}
+	}
+	return users, nil
+}
+
+// a が b より新しい位置にあるか
+func noteBefore(a, b NoteCursor) bool {
+	if !a.UpdatedAt.Equal(b.UpdatedAt) {
+		return a.UpdatedAt.After(b.UpdatedAt)
+	}
+	return a.ID > b.ID
+}
+
+func (s *MemoryStore) ListFeed(userNo int, after *NoteCursor, limit int) ([]Note, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	notes := []Note{}
+	for _, v := range s.notes {
+		if _, ok := s.findFollow(userNo, v.UserNo); !ok || v.Public != 2 {
+			continue
+		}
+		if after != nil && !noteBefore(*after, NoteCursor{v.UpdatedAt, v.ID}) {
+			continue
+		}
+		notes = append(notes, s.fillNote(v))
+	}
+	sort.Slice(notes, func(i, j int) bool {
+		return noteBefore(NoteCursor{notes[i].UpdatedAt, notes[i].ID}, NoteCursor{notes[j].UpdatedAt, notes[j].ID})
+	})
+	if limit > 0 && limit < len(notes) {
+		notes = notes[:limit]
+	}
+	return notes, nil
+}
+
+func (s *MemoryStore) noteIndex(id string) (int, bool) {
+	for i, v := range s.notes {
+		if v.ID == id {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (s *MemoryStore) StarNote(userNo int, noteID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.noteIndex(noteID)
+	if !ok {
+		return 0, ErrNotFound
+	}
+	for _, v := range s.stars {
+		if v.UserNo == userNo && v.NoteID == noteID {
+			return s.notes[i].StarCount, nil
+		}
+	}
+	s.stars = append(s.stars, Star{
+		No:        s.nextNo(),
+		UserNo:    userNo,
+		NoteID:    noteID,
+		CreatedAt: time.Now(),
+	})
+	s.notes[i].StarCount++
+	return s.notes[i].StarCount, nil
+}
+
+func (s *MemoryStore) UnstarNote(userNo int, noteID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.noteIndex(noteID)
+	if !ok {
+		return 0, ErrNotFound
+	}
+	for j, v := range s.stars {
+		if v.UserNo == userNo && v.NoteID == noteID {
+			s.stars = append(s.stars[:j], s.stars[j+1:]...)
+			s.notes[i].StarCount--
+			return s.notes[i].StarCount, nil
+		}
+	}
+	return 0, ErrNotFound
+}
+
+func (s *MemoryStore) ListStarredNotes(userNo, limit, skip int) ([]Note, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	notes := []Note{}
+	for j := len(s.stars) - 1; j >= 0; j-- {
+		v := s.stars[j]
+		if v.UserNo != userNo {
+			continue
+		}
+		if i, ok := s.noteIndex(v.NoteID); ok && s.notes[i].Public == 2 {
+			notes = append(notes, s.fillNote(s.notes[i]))
+		}
+	}
+
+	count := len(notes)
+	if skip >= len(notes) {
+		return []Note{}, count, nil
+	}
+	notes = notes[skip:]
+	if limit > 0 && limit < len(notes) {
+		notes = notes[:limit]
+	}
+	return notes, count, nil
+}
+
+func (s *MemoryStore) ListNoteComments(noteID string) ([]NoteComment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	comments := []NoteComment{}
+	for _, v := range s.comments {
+		if v.NoteID == noteID {
+			v.User = s.userByNo(v.UserNo)
+			comments = append(comments, v)
+		}
+	}
+	return comments, nil
+}
+
+func (s *MemoryStore) GetNoteComment(noteID string, no int) (NoteComment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.comments {
+		if v.NoteID == noteID && v.No == no {
+			v.User = s.userByNo(v.UserNo)
+			return v, nil
+		}
+	}
+	return NoteComment{}, ErrNotFound
+}
+
+func (s *MemoryStore) CreateNoteComment(comment *NoteComment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	comment.No = s.nextNo()
+	comment.CreatedAt = time.Now()
+	s.comments = append(s.comments, *comment)
+	return nil
+}
+
+func (s *MemoryStore) DeleteNoteComment(no int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 返信は必ず親より後に作られるので、前から順に見れば子孫をすべて集められる
+	deleted := map[int]bool{no: true}
+	var comments []NoteComment
+	for _, v := range s.comments {
+		if deleted[v.No] || (v.ParentNo != nil && deleted[*v.ParentNo]) {
+			deleted[v.No] = true
+			continue
+		}
+		comments = append(comments, v)
+	}
+	s.comments = comments
+	return nil
+}
+
+func (s *MemoryStore) SetNoteCommentsDisabled(noteID string, disabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.noteIndex(noteID)
+	if !ok {
+		return ErrNotFound
+	}
+	s.notes[i].CommentsDisabled = disabled
+	return nil
+}
+
+var _ Store = (*MemoryStore)(nil)
@@ -0,0 +1,270 @@
+package store
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/tsushiy/codernote-backend/db"
+)
+
+// ErrNotFound は対象のレコードが存在しない場合に返る
+var ErrNotFound = errors.New("record not found")
+
+// Store はAPIサーバが使うデータの読み書きをまとめたもの
+// GormStore が本番用のPostgreSQL、MemoryStore がテスト用のメモリ上の実装
+type Store interface {
+	UserStore
+	ProblemStore
+	ContestStore
+	NoteStore
+	TagStore
+	SubmissionStore
+	AccessTokenStore
+	FollowStore
+	StarStore
+	CommentStore
+}
+
+type UserStore interface {
+	GetUser(uid string) (User, error)
+	GetUserByName(name string) (User, error)
+	FirstOrCreateUser(uid, name string) (User, error)
+	UpdateUserName(uid, name string) (User, error)
+	// 設定がまだない場合は空の設定を返す
+	GetUserDetail(uid string) (UserDetail, error)
+	UpdateUserDetail(detail UserDetail) (UserDetail, error)
+	// ユーザーと、そのノートや設定などユーザーに紐づくものをすべて削除する
+	DeleteUser(uid string) error
+}
+
+type ProblemOrder int
+
+const (
+	// 番号順
+	ProblemOrderNo ProblemOrder = iota
+	// DifficultyValue 順
+	ProblemOrderDifficulty
+	// DifficultyEstimate 順
+	ProblemOrderEstimate
+	ProblemOrderTitle
+	// 問題のコンテストの開始時刻順
+	ProblemOrderStarted
+)
+
+type ProblemFilter struct {
+	Domain    string
+	ContestID string
+	// タイトルに含まれる文字列。大文字小文字は区別しない
+	Text string
+	// 空でなければ、DifficultyKind が一致する問題のみ
+	DifficultyKind string
+	// nil でなければ、DifficultyValue や DifficultyEstimate がその範囲にある問題のみ
+	MinDifficulty *float64
+	MaxDifficulty *float64
+	MinEstimate   *float64
+	MaxEstimate   *float64
+	// 0なら制限しない
+	Limit     int
+	Skip      int
+	Order     ProblemOrder
+	Ascending bool
+}
+
+type ProblemStore interface {
+	GetProblem(no int) (Problem, error)
+	// 条件に合う問題と、Limit, Skip を適用する前の件数を返す
+	ListProblems(f ProblemFilter) ([]Problem, int, error)
+	// filter の空でない Domain, ContestID, ProblemID, FrontendID, Slug がすべて一致する問題を最大 limit 件返す
+	FindProblems(filter Problem, limit int) ([]Problem, error)
+}
+
+type ContestOrder int
+
+const (
+	ContestOrderStarted ContestOrder = iota
+	ContestOrderTitle
+)
+
+type ContestFilter struct {
+	Domains []string
+	// 空でなければ、保存されている Status がいずれかに一致するコンテストのみ
+	Statuses  []string
+	ContestID string
+	// タイトルに含まれる文字列。大文字小文字は区別しない
+	Text string
+	// nil でなければ、DifficultyValue や DifficultyEstimate がその範囲にある問題を含むコンテストのみ
+	// DifficultyKind が空でなければ、その種類の問題だけで比べる
+	DifficultyKind string
+	MinDifficulty  *float64
+	MaxDifficulty  *float64
+	MinEstimate    *float64
+	MaxEstimate    *float64
+	// 0でなければ、開始時刻があり、この時刻より後に終了するコンテストのみ
+	EndAfter int64
+	// 0なら制限しない
+	Limit     int
+	Skip      int
+	Order     ContestOrder
+	Ascending bool
+}
+
+type ContestStore interface {
+	// 条件に合うコンテストと、Limit, Skip を適用する前の件数を返す
+	ListContests(f ContestFilter) ([]Contest, int, error)
+}
+
+type NoteOrder int
+
+const (
+	OrderUpdated NoteOrder = iota
+	// Text による検索のスコア順
+	OrderRelevance
+	OrderCreated
+	// スターの多い順
+	OrderStars
+)
+
+type NoteFilter struct {
+	Domain    string
+	ProblemNo int
+	ContestID string
+	UserID    string
+	UserName  string
+	// Tags のすべて(AnyTag なら少なくとも1つ)が付いていて、ExcludeTags のどれも付いていないノート
+	Tags        []string
+	AnyTag      bool
+	ExcludeTags []string
+	Text        string
+	PublicOnly  bool
+	Limit       int
+	Skip        int
+	Order       NoteOrder
+}
+
+// DomainCount はドメインと、そのドメインの問題のノートの数
+type DomainCount struct {
+	Domain string
+	Count  int
+}
+
+type UserNoteStats struct {
+	NoteCount int
+	Domains   []DomainCount
+	Tags      []TagCount
+}
+
+type NoteStore interface {
+	// User と Problem も埋めて返す
+	GetNote(id string) (Note, error)
+	GetUserNote(uid string, problemNo int) (Note, error)
+	// ノートを作成または更新し、履歴を追加する
+	SaveNote(userNo, problemNo int, text string, public int) (Note, error)
+	DeleteNote(note Note) error
+	// ユーザーのすべてのノートを作成日時の古い順に、Problem も埋めて返す
+	ListUserNotes(userNo int) ([]Note, error)
+	// 条件に合うノートと、Limit, Skip を適用する前の件数を返す
+	ListNotes(f NoteFilter) ([]Note, int, error)
+	// 検索語を強調したノート本文の抜粋をノートIDごとに返す
+	NoteSnippets(text string, noteIDs []string) (map[string]string, error)
+
+	// ユーザーの公開ノートの数と、ドメインごとの数、よく使っているタグを返す
+	UserNoteStats(userNo, tagLimit int) (UserNoteStats, error)
+
+	ListNoteRevisions(noteID string) ([]NoteRevision, error)
+	GetNoteRevision(noteID string, no int) (NoteRevision, error)
+	RestoreNoteRevision(note Note, revision NoteRevision) (Note, error)
+}
+
+type StarStore interface {
+	// スター後のノートのスター数を返す。すでにスターしていれば何もしない
+	StarNote(userNo int, noteID string) (int, error)
+	// スター解除後のノートのスター数を返す。スターしていなければ ErrNotFound を返す
+	UnstarNote(userNo int, noteID string) (int, error)
+	// スターした公開ノートをスターした日時の新しい順に返す
+	ListStarredNotes(userNo, limit, skip int) ([]Note, int, error)
+}
+
+type CommentStore interface {
+	// 作成日時の古い順に、User も埋めて返す
+	ListNoteComments(noteID string) ([]NoteComment, error)
+	GetNoteComment(noteID string, no int) (NoteComment, error)
+	CreateNoteComment(comment *NoteComment) error
+	// 返信もまとめて削除する
+	DeleteNoteComment(no int) error
+	SetNoteCommentsDisabled(noteID string, disabled bool) error
+}
+
+// TagCount はタグと、そのタグが付いているノートの数
+type TagCount struct {
+	Key   string
+	Count int
+}
+
+// NoteTagOp は1つの問題のノートに付け外しするタグ
+type NoteTagOp struct {
+	ProblemNo int
+	Add       []string
+	Remove    []string
+}
+
+// NoteTagResult は NoteTagOp で実際に付けた、外したタグの数
+type NoteTagResult struct {
+	ProblemNo int
+	Added     int
+	Removed   int
+}
+
+// NoteCursor は updated_at, id の降順に並べたノートの位置
+type NoteCursor struct {
+	UpdatedAt time.Time
+	ID        string
+}
+
+type FollowStore interface {
+	// すでにフォローしていれば何もしない
+	Follow(followerNo, followeeNo int) error
+	// フォローしていなければ ErrNotFound を返す
+	Unfollow(followerNo, followeeNo int) error
+	ListFollowers(userNo int) ([]User, error)
+	ListFollowing(userNo int) ([]User, error)
+	// フォローしているユーザーの公開ノートを updated_at の降順に返す
+	// after が nil でなければ、その位置より後のノートのみ
+	ListFeed(userNo int, after *NoteCursor, limit int) ([]Note, error)
+}
+
+type TagStore interface {
+	GetTag(key string) (Tag, error)
+	// prefix で始まるタグを、使われているノートの多い順に返す。大文字小文字は区別しない
+	SearchTags(prefix string, limit int) ([]TagCount, error)
+	// ユーザーが使っているタグを、ノートの多い順に返す
+	ListUserTags(uid string) ([]TagCount, error)
+	// 公開されているノートでよく使われているタグを返す
+	PopularTags(limit int) ([]TagCount, error)
+	ListNoteTags(noteID string) ([]string, error)
+	// ユーザーのノートごとのタグを返す
+	ListUserNoteTags(userNo int) (map[string][]string, error)
+	// ノートがなければ空のノートを作成してタグを付ける
+	AddNoteTag(userNo, problemNo int, key string) error
+	RemoveNoteTag(noteID string, tagNo int) error
+	// ユーザーのノートに付いている from のタグをすべて to に付け替え、変更したノートの数を返す
+	// 他のユーザーのノートは変更しない
+	MergeUserTags(uid string, from []string, to string) (int, error)
+	// すべての操作を1つのトランザクションで行う。ノートがなければ空のノートを作成する
+	BulkUpdateNoteTags(userNo int, ops []NoteTagOp) ([]NoteTagResult, error)
+}
+
+type SubmissionStore interface {
+	// 提出のある問題について、ACしているかどうかを返す
+	SubmissionResults(uid, domain string) (map[int]bool, error)
+	SolvedProblemNos(uid, domain string) ([]int, error)
+}
+
+type AccessTokenStore interface {
+	ListAccessTokens(uid string) ([]AccessToken, error)
+	CountAccessTokens(uid string) (int, error)
+	CreateAccessToken(token *AccessToken) error
+	GetAccessToken(uid string, no int) (AccessToken, error)
+	GetAccessTokenByHash(hash string) (AccessToken, error)
+	TouchAccessToken(no int, t time.Time) error
+	DeleteAccessToken(no int) error
+}
@@ -0,0 +1,25 @@
+package main
+
+const (
+	statusAccepted = "AC"
+	statusWrong    = "WA"
+	statusUnsolved = "unsolved"
+)
+
+// 提出のない問題はmapに含まれない
+func (s *server) submissionStatuses(uid, domain string) (map[int]string, error) {
+	results, err := s.store.SubmissionResults(uid, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[int]string)
+	for no, accepted := range results {
+		if accepted {
+			statuses[no] = statusAccepted
+		} else {
+			statuses[no] = statusWrong
+		}
+	}
+	return statuses, nil
+}
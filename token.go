@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+
+	. "github.com/tsushiy/codernote-backend/db"
+)
+
+const (
+	accessTokenPrefix = "cnpat_"
+	maxAccessTokens   = 20
+	// 毎リクエストで書き込まないように、最終使用日時はこの間隔でのみ更新する
+	lastUsedInterval = time.Minute
+)
+
+const (
+	scopeRead      = "read"
+	scopeReadWrite = "read-write"
+)
+
+func genAccessToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return accessTokenPrefix + hex.EncodeToString(b), nil
+}
+
+func hashAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *server) verifyAccessToken(raw string) (AccessToken, error) {
+	token, err := s.store.GetAccessTokenByHash(hashAccessToken(raw))
+	if err != nil {
+		return token, err
+	}
+
+	now := time.Now()
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > lastUsedInterval {
+		if err := s.store.TouchAccessToken(token.No, now); err != nil {
+			log.Println(err)
+		}
+	}
+	return token, nil
+}
+
+func isAllowedScope(scope, method string) bool {
+	switch scope {
+	case scopeReadWrite:
+		return true
+	case scopeRead:
+		return method == http.MethodGet
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	. "github.com/tsushiy/codernote-backend/db"
+)
+
+const (
+	atcoderDomain       = "atcoder"
+	codeforcesDomain    = "codeforces"
+	codeforcesGymDomain = "codeforces-gym"
+	yukicoderDomain     = "yukicoder"
+	aojDomain           = "aoj"
+	leetcodeDomain      = "leetcode"
+)
+
+// 問題ページのURLのパスと、一致したときに問題を探す条件
+var problemURLPatterns = []struct {
+	host    string
+	pattern *regexp.Regexp
+	problem func(m []string, q url.Values) Problem
+}{
+	// 複数のコンテストで出題された問題は、URLのコンテストと保存されている ContestID が異なる
+	// AtCoderの問題IDはコンテストをまたいで一意なので、クローラと同じく問題IDだけで探す
+	{"atcoder.jp", regexp.MustCompile(`^/contests/[^/]+/tasks/([^/]+)$`), func(m []string, q url.Values) Problem {
+		return Problem{Domain: atcoderDomain, ProblemID: m[1]}
+	}},
+	{"codeforces.com", regexp.MustCompile(`^/(?:contest|problemset/problem)/([0-9]+)(?:/problem)?/([0-9A-Za-z]+)$`), func(m []string, q url.Values) Problem {
+		return Problem{Domain: codeforcesDomain, ContestID: m[1], ProblemID: strings.ToUpper(m[2])}
+	}},
+	{"codeforces.com", regexp.MustCompile(`^/gym/([0-9]+)/problem/([0-9A-Za-z]+)$`), func(m []string, q url.Values) Problem {
+		return Problem{Domain: codeforcesGymDomain, ContestID: m[1], ProblemID: strings.ToUpper(m[2])}
+	}},
+	{"yukicoder.me", regexp.MustCompile(`^/problems/no/([0-9]+)$`), func(m []string, q url.Values) Problem {
+		return Problem{Domain: yukicoderDomain, FrontendID: m[1]}
+	}},
+	{"yukicoder.me", regexp.MustCompile(`^/problems/([0-9]+)$`), func(m []string, q url.Values) Problem {
+		return Problem{Domain: yukicoderDomain, ProblemID: m[1]}
+	}},
+	{"onlinejudge.u-aizu.ac.jp", regexp.MustCompile(`^/(?:problems|courses/.+)/([^/]+)$`), func(m []string, q url.Values) Problem {
+		return Problem{Domain: aojDomain, ProblemID: m[1]}
+	}},
+	// 旧AOJのURLは問題IDをクエリに持つ
+	{"judge.u-aizu.ac.jp", regexp.MustCompile(`^/onlinejudge/description\.jsp$`), func(m []string, q url.Values) Problem {
+		return Problem{Domain: aojDomain, ProblemID: q.Get("id")}
+	}},
+	{"leetcode.com", regexp.MustCompile(`^/problems/([^/]+)$`), func(m []string, q url.Values) Problem {
+		return Problem{Domain: leetcodeDomain, Slug: m[1]}
+	}},
+}
+
+// parseProblemURL は問題ページのURLから、問題を探す条件を返す
+// 返す Problem は Domain と、空でない ContestID, ProblemID, FrontendID, Slug だけが意味を持つ
+func parseProblemURL(raw string) (Problem, bool) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return Problem{}, false
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	path := strings.TrimSuffix(u.Path, "/")
+	for _, v := range problemURLPatterns {
+		if v.host != host {
+			continue
+		}
+		if m := v.pattern.FindStringSubmatch(path); m != nil {
+			p := v.problem(m, u.Query())
+			return p, p.ProblemID != "" || p.FrontendID != "" || p.Slug != ""
+		}
+	}
+	return Problem{}, false
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// TokenVerifier はJWTを検証してユーザのuidを返す
+type TokenVerifier interface {
+	Verify(raw string) (string, error)
+}
+
+// 公開鍵の取得に失敗した場合のエラー。トークンが不正な場合と区別する
+type keyFetchError struct {
+	err error
+}
+
+func (e *keyFetchError) Error() string {
+	return e.err.Error()
+}
+
+func (e *keyFetchError) Unwrap() error {
+	return e.err
+}
+
+// AUTH_VERIFIER=local でローカルの鍵で署名したトークンを検証する。デフォルトはFirebase
+func newTokenVerifierFromEnv() (TokenVerifier, error) {
+	switch getEnv("AUTH_VERIFIER", "firebase") {
+	case "firebase":
+		projectID := getEnv("FIREBASE_PROJECT_ID", "codernote-project")
+		return newFirebaseVerifier(projectID), nil
+	case "local":
+		return newLocalVerifierFromEnv()
+	}
+	return nil, errors.New("unknown AUTH_VERIFIER: " + os.Getenv("AUTH_VERIFIER"))
+}
+
+type firebaseVerifier struct {
+	audience string
+	issuer   string
+	keys     *keyStore
+}
+
+func newFirebaseVerifier(projectID string) *firebaseVerifier {
+	keys := newKeyStore(googlePublicKeyURL)
+	go keys.run(nil)
+	return &firebaseVerifier{
+		audience: projectID,
+		issuer:   "https://securetoken.google.com/" + projectID,
+		keys:     keys,
+	}
+}
+
+func (v *firebaseVerifier) Verify(raw string) (string, error) {
+	token, err := jwt.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, errors.New("invalid signing method")
+		}
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, errors.New("invalid signing method")
+		}
+
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, errors.New("token header should have kid field")
+		}
+		return v.keys.Key(kid)
+	})
+	if err != nil {
+		// jwt-goはKeyfuncのエラーをValidationErrorで包む
+		if verr, ok := err.(*jwt.ValidationError); ok {
+			if kerr, ok := verr.Inner.(*keyFetchError); ok {
+				return "", kerr
+			}
+		}
+		return "", err
+	}
+
+	if !isValidToken(token, v.audience, v.issuer) {
+		return "", errors.New("invalid token")
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	if authTime, ok := claims["auth_time"].(float64); !ok || int64(authTime) > time.Now().Unix() {
+		return "", errors.New("invalid auth_time")
+	}
+
+	return claims["sub"].(string), nil
+}
+
+// 開発環境やテストで、ローカルで署名したトークンを検証する
+type localVerifier struct {
+	audience string
+	issuer   string
+	method   jwt.SigningMethod
+	key      interface{}
+}
+
+func newLocalVerifierFromEnv() (*localVerifier, error) {
+	audience := getEnv("AUTH_LOCAL_AUDIENCE", "codernote-local")
+	issuer := getEnv("AUTH_LOCAL_ISSUER", "codernote-local")
+
+	if secret := os.Getenv("AUTH_LOCAL_HMAC_SECRET"); secret != "" {
+		return newHMACVerifier([]byte(secret), audience, issuer), nil
+	}
+
+	pem := []byte(os.Getenv("AUTH_LOCAL_RSA_PUBLIC_KEY"))
+	if path := os.Getenv("AUTH_LOCAL_RSA_PUBLIC_KEY_FILE"); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		pem = b
+	}
+	if len(pem) == 0 {
+		return nil, errors.New("AUTH_LOCAL_HMAC_SECRET or AUTH_LOCAL_RSA_PUBLIC_KEY(_FILE) is required")
+	}
+	key, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+	if err != nil {
+		return nil, err
+	}
+	return newRSAVerifier(key, audience, issuer), nil
+}
+
+func newHMACVerifier(secret []byte, audience, issuer string) *localVerifier {
+	return &localVerifier{
+		audience: audience,
+		issuer:   issuer,
+		method:   jwt.SigningMethodHS256,
+		key:      secret,
+	}
+}
+
+func newRSAVerifier(key *rsa.PublicKey, audience, issuer string) *localVerifier {
+	return &localVerifier{
+		audience: audience,
+		issuer:   issuer,
+		method:   jwt.SigningMethodRS256,
+		key:      key,
+	}
+}
+
+func (v *localVerifier) Verify(raw string) (string, error) {
+	token, err := jwt.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != v.method {
+			return nil, fmt.Errorf("invalid signing method %s", token.Method.Alg())
+		}
+		return v.key, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if !isValidToken(token, v.audience, v.issuer) {
+		return "", errors.New("invalid token")
+	}
+	return token.Claims.(jwt.MapClaims)["sub"].(string), nil
+}
+
+func isValidToken(token *jwt.Token, audience, issuer string) bool {
+	now := time.Now().Unix()
+	if !token.Valid {
+		return false
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	if claims.VerifyExpiresAt(now, true) == false {
+		return false
+	}
+	if claims.VerifyIssuedAt(now, true) == false {
+		return false
+	}
+	if claims.VerifyAudience(audience, true) == false {
+		return false
+	}
+	if claims.VerifyIssuer(issuer, true) == false {
+		return false
+	}
+	if sub, ok := claims["sub"].(string); !ok || sub == "" {
+		return false
+	}
+	return true
+}
+
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
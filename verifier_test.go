@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func newTestRSAKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// 公開鍵をPKIXのPEMにする
+func encodePublicKeyPEM(t *testing.T, key *rsa.PublicKey) string {
+	b, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: b}))
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+	raw, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestRSAVerifier(t *testing.T) {
+	key := newTestRSAKey(t)
+	otherKey := newTestRSAKey(t)
+	v := newRSAVerifier(&key.PublicKey, testAudience, testIssuer)
+
+	now := time.Now()
+	claims := func(modify func(jwt.MapClaims)) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"sub": "alice",
+			"aud": testAudience,
+			"iss": testIssuer,
+			"iat": now.Unix(),
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		if modify != nil {
+			modify(c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"valid", signToken(t, jwt.SigningMethodRS256, key, claims(nil)), true},
+		{"expired", signToken(t, jwt.SigningMethodRS256, key, claims(func(c jwt.MapClaims) {
+			c["iat"] = now.Add(-2 * time.Hour).Unix()
+			c["exp"] = now.Add(-time.Hour).Unix()
+		})), false},
+		{"issued in the future", signToken(t, jwt.SigningMethodRS256, key, claims(func(c jwt.MapClaims) {
+			c["iat"] = now.Add(time.Hour).Unix()
+		})), false},
+		{"wrong issuer", signToken(t, jwt.SigningMethodRS256, key, claims(func(c jwt.MapClaims) {
+			c["iss"] = "other-issuer"
+		})), false},
+		{"wrong audience", signToken(t, jwt.SigningMethodRS256, key, claims(func(c jwt.MapClaims) {
+			c["aud"] = "other-audience"
+		})), false},
+		{"no subject", signToken(t, jwt.SigningMethodRS256, key, claims(func(c jwt.MapClaims) {
+			delete(c, "sub")
+		})), false},
+		{"signed with another key", signToken(t, jwt.SigningMethodRS256, otherKey, claims(nil)), false},
+		// 公開鍵をHMACの鍵として使った署名は受け付けない
+		{"hmac with public key", signToken(t, jwt.SigningMethodHS256, []byte(encodePublicKeyPEM(t, &key.PublicKey)), claims(nil)), false},
+		{"malformed", "not-a-token", false},
+	}
+	for _, tt := range tests {
+		uid, err := v.Verify(tt.token)
+		if tt.ok {
+			if err != nil || uid != "alice" {
+				t.Errorf("%s: Verify() = %q, %v, want alice", tt.name, uid, err)
+			}
+		} else if err == nil {
+			t.Errorf("%s: Verify() = %q, want error", tt.name, uid)
+		}
+	}
+}
+
+func TestLocalVerifierFromEnv(t *testing.T) {
+	key := newTestRSAKey(t)
+	for k, v := range map[string]string{
+		"AUTH_LOCAL_RSA_PUBLIC_KEY": encodePublicKeyPEM(t, &key.PublicKey),
+		"AUTH_LOCAL_AUDIENCE":       testAudience,
+		"AUTH_LOCAL_ISSUER":         testIssuer,
+	} {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	v, err := newLocalVerifierFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	raw := signToken(t, jwt.SigningMethodRS256, key, jwt.MapClaims{
+		"sub": "alice",
+		"aud": testAudience,
+		"iss": testIssuer,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	})
+	if uid, err := v.Verify(raw); err != nil || uid != "alice" {
+		t.Errorf("Verify() = %q, %v, want alice", uid, err)
+	}
+
+	os.Setenv("AUTH_LOCAL_RSA_PUBLIC_KEY", "invalid")
+	if _, err := newLocalVerifierFromEnv(); err == nil {
+		t.Error("invalid public key is accepted")
+	}
+}